@@ -3,13 +3,15 @@ package main
 import (
 	"context"
 	"firetail-lambda-extension/extensionsapi"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-// awaitShutdown calls /event/next until a shutdown event is received, or the context is cancelled.
-// It returns a reason, or an error, depending upon the cause of the shutdown.
-func awaitShutdown(extensionClient *extensionsapi.Client, ctx context.Context) (string, error) {
+// awaitShutdown calls /event/next until a shutdown event is received, or the context is cancelled. The execution environment may be
+// frozen as soon as /event/next is called, so after each invoke event the onInvoke callback, if provided, is called with the event's
+// request ID & deadline before the next call to /event/next. It returns a reason, or an error, depending upon the cause of the shutdown.
+func awaitShutdown(extensionClient *extensionsapi.Client, ctx context.Context, onInvoke func(requestID string, deadline time.Time)) (string, error) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -22,6 +24,9 @@ func awaitShutdown(extensionClient *extensionsapi.Client, ctx context.Context) (
 			if res.EventType == extensionsapi.Shutdown {
 				return "received shutdown event", nil
 			}
+			if res.EventType == extensionsapi.Invoke && onInvoke != nil {
+				onInvoke(res.RequestID, time.UnixMilli(res.DeadlineMs))
+			}
 		}
 	}
 }
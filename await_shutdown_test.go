@@ -26,7 +26,7 @@ func TestAwaitShutdownContextCancelled(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	cancel()
 
-	reason, err := awaitShutdown(extensionClient, ctx)
+	reason, err := awaitShutdown(extensionClient, ctx, nil)
 
 	assert.Nil(t, err)
 	assert.Equal(t, "context cancelled", reason)
@@ -37,7 +37,7 @@ func TestAwaitShutdownNextEventErrs(t *testing.T) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 
-	reason, err := awaitShutdown(extensionClient, ctx)
+	reason, err := awaitShutdown(extensionClient, ctx, nil)
 
 	require.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to get next event")
@@ -56,7 +56,34 @@ func TestAwaitShutdownShutdownEvent(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
-	reason, err := awaitShutdown(extensionClient, ctx)
+	reason, err := awaitShutdown(extensionClient, ctx, nil)
 	require.Nil(t, err)
 	assert.Equal(t, "received shutdown event", reason)
 }
+
+func TestAwaitShutdownInvokeEvents(t *testing.T) {
+	nextEventResponses := []string{
+		`{"eventType": "INVOKE", "requestId": "test-request-id-1"}`,
+		`{"eventType": "INVOKE", "requestId": "test-request-id-2"}`,
+		`{"eventType": "SHUTDOWN"}`,
+	}
+	mockExtensionsApi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, nextEventResponses[0])
+		nextEventResponses = nextEventResponses[1:]
+	}))
+	defer mockExtensionsApi.Close()
+
+	t.Setenv("AWS_LAMBDA_RUNTIME_API", strings.Join(strings.Split(mockExtensionsApi.URL, ":")[1:], ":")[2:])
+	extensionClient := extensionsapi.NewClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	invokedRequestIDs := []string{}
+	reason, err := awaitShutdown(extensionClient, ctx, func(requestID string, deadline time.Time) {
+		invokedRequestIDs = append(invokedRequestIDs, requestID)
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "received shutdown event", reason)
+	assert.Equal(t, []string{"test-request-id-1", "test-request-id-2"}, invokedRequestIDs)
+}
@@ -0,0 +1,347 @@
+package firetail
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	authorizerEffectAllow = "Allow"
+	authorizerEffectDeny  = "Deny"
+)
+
+// maskedValue replaces secrets, such as the credentials of authorization tokens, in log entries
+const maskedValue = "********"
+
+// The prefixes of the identity sources of REQUEST authorizers which are headers or query string parameters, for REST & WebSocket APIs
+// (method.request) and HTTP APIs ($request)
+var (
+	authorizerHeaderIdentitySourcePrefixes      = []string{"method.request.header.", "$request.header."}
+	authorizerQueryStringIdentitySourcePrefixes = []string{"method.request.querystring.", "$request.querystring."}
+)
+
+// AuthorizerMasking configures which values of the requests REQUEST authorizers are invoked with are masked before they're logged, on top
+// of the Authorization header. The events of REST API REQUEST authorizers don't state which identity sources the authorizer is configured
+// with, so any headers or query string parameters they use other than the Authorization header must be configured here to be masked.
+type AuthorizerMasking struct {
+	IdentitySources []string // The identity sources as configured on the authorizer, e.g. method.request.header.X-Api-Token
+}
+
+// DefaultAuthorizerMasking is the AuthorizerMasking used for all log entries of Lambda authorizers
+var DefaultAuthorizerMasking = &AuthorizerMasking{}
+
+// LoadEnvVars configures the AuthorizerMasking from the FIRETAIL_AUTHORIZER_IDENTITY_SOURCES env var, which is a comma-separated list of
+// identity sources
+func (m *AuthorizerMasking) LoadEnvVars() error {
+	identitySourcesStr, isSet := os.LookupEnv("FIRETAIL_AUTHORIZER_IDENTITY_SOURCES")
+	if !isSet {
+		return nil
+	}
+	m.IdentitySources = []string{}
+	for _, identitySource := range strings.Split(identitySourcesStr, ",") {
+		identitySource = strings.TrimSpace(identitySource)
+		if identitySource == "" {
+			continue
+		}
+		if _, isHeader := cutIdentitySourcePrefix(identitySource, authorizerHeaderIdentitySourcePrefixes); !isHeader {
+			if _, isQueryString := cutIdentitySourcePrefix(identitySource, authorizerQueryStringIdentitySourcePrefixes); !isQueryString {
+				return errors.Errorf("FIRETAIL_AUTHORIZER_IDENTITY_SOURCES contains %s but must only contain headers or query string parameters",
+					identitySource)
+			}
+		}
+		m.IdentitySources = append(m.IdentitySources, identitySource)
+	}
+	return nil
+}
+
+// cutIdentitySourcePrefix returns the name of the header or query string parameter an identity source refers to, and true, if it has one
+// of the provided prefixes. Otherwise, it returns the empty string and false.
+func cutIdentitySourcePrefix(identitySource string, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		if name := strings.TrimPrefix(identitySource, prefix); name != identitySource && name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// authorizerEvent is the event API Gateway invokes Lambda authorizers with. TOKEN authorizers only receive the token & method ARN. REQUEST
+// authorizers receive the request's headers, path & context, and for HTTP APIs (version 2.0) a route ARN instead of a method ARN.
+type authorizerEvent struct {
+	Version            string              `json:"version"`
+	Type               string              `json:"type"` // TOKEN or REQUEST
+	AuthorizationToken string              `json:"authorizationToken"`
+	MethodArn          string              `json:"methodArn"`
+	RouteArn           string              `json:"routeArn"`
+	IdentitySource     json.RawMessage     `json:"identitySource"` // A list of the identity source values for HTTP APIs, or a string for WebSocket APIs
+	Resource           string              `json:"resource"`
+	Path               string              `json:"path"`
+	HTTPMethod         string              `json:"httpMethod"`
+	RouteKey           string              `json:"routeKey"`
+	RawPath            string              `json:"rawPath"`
+	Headers            map[string]string   `json:"headers"`
+	MultiValueHeaders  map[string][]string `json:"multiValueHeaders"`
+	Cookies            []string            `json:"cookies"`
+	// The query string parameters aren't logged, but the values of those which are identity sources are masked wherever they're repeated
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	RequestContext                  struct {
+		DomainName       string `json:"domainName"`
+		Stage            string `json:"stage"`
+		Protocol         string `json:"protocol"`
+		RequestTimeEpoch int64  `json:"requestTimeEpoch"`
+		TimeEpoch        int64  `json:"timeEpoch"`
+		Identity         struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"`
+		HTTP struct {
+			Method   string `json:"method"`
+			Protocol string `json:"protocol"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// authorizerResponse is the response of a Lambda authorizer: either an IAM policy, or for HTTP APIs a simple response stating whether the
+// request is authorized
+type authorizerResponse struct {
+	PrincipalID    string `json:"principalId"`
+	PolicyDocument *struct {
+		Statement []authorizerPolicyStatement `json:"Statement"`
+	} `json:"policyDocument"`
+	IsAuthorized *bool                  `json:"isAuthorized"`
+	Context      map[string]interface{} `json:"context"`
+}
+
+// authorizerPolicyStatement is a statement of an IAM policy returned by a Lambda authorizer. Its Resource may be a string or a list.
+type authorizerPolicyStatement struct {
+	Effect   string          `json:"Effect"`
+	Resource json.RawMessage `json:"Resource"`
+}
+
+// getAuthorizerLogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is an API Gateway
+// Lambda authorizer event. Otherwise, it returns nil and false.
+//
+// The log entry's request is the API request the authorizer was invoked to authorize, as far as the event describes it; a TOKEN
+// authorizer's event only has its method ARN. The log entry's response is the response API Gateway will make because of the authorizer's
+// decision: a 403 if it denied the request, or a 200 if it allowed it to proceed to the integration. The authorizer's decision is
+// summarised in the log entry's metadata. The authorizer's response isn't logged, as its principal ID & context values may identify the
+// caller or carry secrets, so only its decision, its principal ID hashed according to the DefaultIdentifierHashing & the keys of its
+// context are. The authorization token & identity sources are never logged in the clear.
+func (r *Record) getAuthorizerLogEntry() (*LogEntry, bool) {
+	var authorizerEvent authorizerEvent
+	if err := json.Unmarshal(r.Event, &authorizerEvent); err != nil ||
+		(authorizerEvent.Type != "TOKEN" && authorizerEvent.Type != "REQUEST") ||
+		(authorizerEvent.MethodArn == "" && authorizerEvent.RouteArn == "") {
+		return nil, false
+	}
+
+	methodArn := authorizerEvent.MethodArn
+	if methodArn == "" {
+		methodArn = authorizerEvent.RouteArn
+	}
+	method, resource := parseMethodArn(methodArn)
+
+	logEntryRequest := LogEntryRequest{
+		Headers:  DefaultAuthorizerMasking.maskHeaders(normaliseHeaders(authorizerEvent.Headers, authorizerEvent.MultiValueHeaders), &authorizerEvent),
+		Method:   LogEntryMethod(method),
+		URI:      methodArn,
+		Resource: resource,
+	}
+	requestTime := authorizerEvent.RequestContext.RequestTimeEpoch
+	if authorizerEvent.Version == "2.0" {
+		logEntryRequest.HTTPProtocol = LogEntryHTTPProtocol(authorizerEvent.RequestContext.HTTP.Protocol)
+		logEntryRequest.IP = authorizerEvent.RequestContext.HTTP.SourceIP
+		logEntryRequest.Method = LogEntryMethod(authorizerEvent.RequestContext.HTTP.Method)
+		logEntryRequest.Resource = getResourceFromRouteKey(authorizerEvent.RouteKey, authorizerEvent.RawPath, authorizerEvent.RequestContext.Stage)
+		if authorizerEvent.RequestContext.DomainName != "" {
+			logEntryRequest.URI = "https://" + authorizerEvent.RequestContext.DomainName + authorizerEvent.RawPath
+		}
+		if len(authorizerEvent.Cookies) > 0 {
+			logEntryRequest.Headers["cookie"] = append(logEntryRequest.Headers["cookie"], strings.Join(authorizerEvent.Cookies, "; "))
+		}
+		requestTime = authorizerEvent.RequestContext.TimeEpoch
+	} else if authorizerEvent.Type == "REQUEST" {
+		logEntryRequest.HTTPProtocol = LogEntryHTTPProtocol(authorizerEvent.RequestContext.Protocol)
+		logEntryRequest.IP = authorizerEvent.RequestContext.Identity.SourceIP
+		logEntryRequest.Method = LogEntryMethod(authorizerEvent.HTTPMethod)
+		logEntryRequest.Resource = normaliseResource(authorizerEvent.Resource)
+		if authorizerEvent.RequestContext.DomainName != "" {
+			logEntryRequest.URI = "https://" + authorizerEvent.RequestContext.DomainName + authorizerEvent.Path
+		}
+	}
+
+	logEntry := r.newLogEntry(logEntryRequest, requestTime)
+
+	authorizerMetadata := &LogEntryAuthorizerMetadata{
+		Type:        authorizerEvent.Type,
+		MethodArn:   methodArn,
+		ContextKeys: []string{},
+	}
+	if authorizerEvent.AuthorizationToken != "" {
+		authorizerMetadata.AuthorizationToken = maskAuthorizationToken(authorizerEvent.AuthorizationToken)
+	}
+
+	var authorizerResponse authorizerResponse
+	if err := json.Unmarshal(r.RawResponse, &authorizerResponse); err == nil {
+		authorizerMetadata.PrincipalID = DefaultIdentifierHashing.Hash(authorizerResponse.PrincipalID)
+		authorizerMetadata.Effect = authorizerResponse.getEffect(methodArn)
+		for contextKey := range authorizerResponse.Context {
+			authorizerMetadata.ContextKeys = append(authorizerMetadata.ContextKeys, contextKey)
+		}
+		sort.Strings(authorizerMetadata.ContextKeys)
+	}
+
+	logEntry.Response = LogEntryResponse{Headers: map[string][]string{}}
+	switch authorizerMetadata.Effect {
+	case authorizerEffectAllow:
+		logEntry.Response.StatusCode = 200
+	case authorizerEffectDeny:
+		logEntry.Response.StatusCode = 403
+	}
+	logEntry.Metadata.Authorizer = authorizerMetadata
+
+	return logEntry, true
+}
+
+// getIdentitySources returns the identity source values of an HTTP or WebSocket API authorizer event
+func (e *authorizerEvent) getIdentitySources() []string {
+	var identitySources []string
+	if err := json.Unmarshal(e.IdentitySource, &identitySources); err == nil {
+		return identitySources
+	}
+	var identitySource string
+	if err := json.Unmarshal(e.IdentitySource, &identitySource); err == nil && identitySource != "" {
+		return strings.Split(identitySource, ",")
+	}
+	return nil
+}
+
+// getEffect returns the decision the authorizer's response will result in for the given method ARN. Simple responses state it directly.
+// For IAM policies, API Gateway denies the request if any statement matching the method ARN denies it, and otherwise allows it if any
+// matching statement allows it. If no statement matches, the request is implicitly denied. If the response is neither, the empty string is
+// returned.
+func (a *authorizerResponse) getEffect(methodArn string) string {
+	if a.IsAuthorized != nil {
+		if *a.IsAuthorized {
+			return authorizerEffectAllow
+		}
+		return authorizerEffectDeny
+	}
+	if a.PolicyDocument == nil {
+		return ""
+	}
+
+	effect := authorizerEffectDeny
+	for _, statement := range a.PolicyDocument.Statement {
+		if !statement.matches(methodArn) {
+			continue
+		}
+		if strings.EqualFold(statement.Effect, authorizerEffectDeny) {
+			return authorizerEffectDeny
+		}
+		if strings.EqualFold(statement.Effect, authorizerEffectAllow) {
+			effect = authorizerEffectAllow
+		}
+	}
+	return effect
+}
+
+// matches returns true if any of the statement's resources match the method ARN. Resources may contain * and ? wildcards.
+func (s *authorizerPolicyStatement) matches(methodArn string) bool {
+	var resources []string
+	if err := json.Unmarshal(s.Resource, &resources); err != nil {
+		var resource string
+		if err := json.Unmarshal(s.Resource, &resource); err != nil {
+			return false
+		}
+		resources = []string{resource}
+	}
+	for _, resource := range resources {
+		pattern := regexp.QuoteMeta(resource)
+		pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+		pattern = strings.ReplaceAll(pattern, `\?`, ".")
+		if matched, err := regexp.MatchString("^"+pattern+"$", methodArn); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMethodArn returns the method & resource path from an execute-api method ARN, which has the form
+// arn:aws:execute-api:{region}:{accountId}:{apiId}/{stage}/{method}/{resourcePath}. Route ARNs of HTTP APIs have the same form. If the ARN
+// can't be parsed, empty strings are returned.
+func parseMethodArn(methodArn string) (string, string) {
+	arnParts := strings.SplitN(methodArn, ":", 6)
+	if len(arnParts) != 6 {
+		return "", ""
+	}
+	resourceParts := strings.SplitN(arnParts[5], "/", 4)
+	if len(resourceParts) < 3 {
+		return "", ""
+	}
+	method := resourceParts[2]
+	if method == "*" || method == "ANY" {
+		method = ""
+	}
+	resourcePath := "/"
+	if len(resourceParts) == 4 {
+		resourcePath += resourceParts[3]
+	}
+	return method, normaliseResource(resourcePath)
+}
+
+// maskAuthorizationToken masks an authorization token so that it can be logged. Its scheme (e.g. Bearer) is kept, but its credentials are
+// replaced entirely.
+func maskAuthorizationToken(authorizationToken string) string {
+	if scheme, credentials, found := strings.Cut(strings.TrimSpace(authorizationToken), " "); found && strings.TrimSpace(credentials) != "" {
+		return scheme + " " + maskedValue
+	}
+	return maskedValue
+}
+
+// maskHeaders masks the Authorization header, any header configured as an identity source, and any other header whose value is the value
+// of one of the identity sources the authorizer was given, as these carry the credentials the authorizer checks
+func (m *AuthorizerMasking) maskHeaders(headers map[string][]string, authorizerEvent *authorizerEvent) map[string][]string {
+	isIdentitySourceHeader := map[string]bool{"authorization": true}
+	isIdentitySourceValue := map[string]bool{}
+	for _, identitySource := range authorizerEvent.getIdentitySources() {
+		isIdentitySourceValue[strings.TrimSpace(identitySource)] = true
+	}
+	for _, identitySource := range m.IdentitySources {
+		if headerName, isHeader := cutIdentitySourcePrefix(identitySource, authorizerHeaderIdentitySourcePrefixes); isHeader {
+			isIdentitySourceHeader[normaliseHeaderName(headerName)] = true
+		} else if parameterName, isQueryString := cutIdentitySourcePrefix(identitySource, authorizerQueryStringIdentitySourcePrefixes); isQueryString {
+			for _, parameterValue := range authorizerEvent.getQueryStringParameter(parameterName) {
+				isIdentitySourceValue[parameterValue] = true
+			}
+		}
+	}
+	delete(isIdentitySourceValue, "")
+
+	for headerName, headerValues := range headers {
+		for i, headerValue := range headerValues {
+			if isIdentitySourceHeader[headerName] || isIdentitySourceValue[headerValue] {
+				headers[headerName][i] = maskAuthorizationToken(headerValue)
+			}
+		}
+	}
+	return headers
+}
+
+// getQueryStringParameter returns the values of the query string parameter with the provided name from the authorizer event
+func (e *authorizerEvent) getQueryStringParameter(parameterName string) []string {
+	if parameterValues, ok := e.MultiValueQueryStringParameters[parameterName]; ok {
+		return parameterValues
+	}
+	if parameterValue, ok := e.QueryStringParameters[parameterName]; ok {
+		// HTTP APIs join the values of repeated query string parameters with commas
+		return append([]string{parameterValue}, strings.Split(parameterValue, ",")...)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package firetail
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxBatchItems  = 100
+	DefaultMaxBatchBytes  = 1024 * 1024
+	DefaultMaxBatchLinger = 100 * time.Millisecond
+)
+
+// BatchLimits configures when a Batcher passes the batch it's receiving records into to its callback. The size of a batch is estimated
+// from its records, so batches whose log entries turn out to be larger than the MaxBytes are split across several requests to Firetail.
+type BatchLimits struct {
+	MaxItems  int           // The maximum number of records in a batch
+	MaxBytes  int           // The maximum approximate size of the records in a batch, in bytes. A single record larger than this is batched alone
+	MaxLinger time.Duration // The maximum time the first record in a batch waits for the batch to fill before the batch is flushed
+}
+
+// DefaultBatchLimits are the BatchLimits used to batch records sent to Firetail
+var DefaultBatchLimits = &BatchLimits{
+	MaxItems:  DefaultMaxBatchItems,
+	MaxBytes:  DefaultMaxBatchBytes,
+	MaxLinger: DefaultMaxBatchLinger,
+}
+
+// LoadEnvVars configures the BatchLimits from the FIRETAIL_MAX_BATCH_SIZE, FIRETAIL_MAX_BATCH_BYTES & FIRETAIL_MAX_BATCH_LINGER env vars.
+// FIRETAIL_MAX_BATCH_LINGER is parsed by time.ParseDuration.
+func (l *BatchLimits) LoadEnvVars() error {
+	for _, intEnvVar := range []struct {
+		name  string
+		value *int
+	}{
+		{"FIRETAIL_MAX_BATCH_SIZE", &l.MaxItems},
+		{"FIRETAIL_MAX_BATCH_BYTES", &l.MaxBytes},
+	} {
+		valueStr, isSet := os.LookupEnv(intEnvVar.name)
+		if !isSet {
+			continue
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return errors.WithMessage(err, intEnvVar.name+" invalid")
+		}
+		if value < 1 {
+			return errors.Errorf("%s is %d but must be >= 1", intEnvVar.name, value)
+		}
+		*intEnvVar.value = value
+	}
+	if maxLingerStr, isSet := os.LookupEnv("FIRETAIL_MAX_BATCH_LINGER"); isSet {
+		maxLinger, err := time.ParseDuration(maxLingerStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_MAX_BATCH_LINGER invalid")
+		}
+		if maxLinger <= 0 {
+			return errors.Errorf("FIRETAIL_MAX_BATCH_LINGER is %s but must be > 0", maxLingerStr)
+		}
+		l.MaxLinger = maxLinger
+	}
+	return nil
+}
+
+// Batcher receives records from a channel into batches, and passes each batch to a callback once it reaches its BatchLimits' MaxItems or
+// MaxBytes, or its first record has waited for the MaxLinger. It blocks while waiting for records rather than polling the channel, so it
+// uses no CPU while the function is idle. The execution environment may be frozen while records are batched, so Flush can be used to pass
+// the batch to the callback early, such as before the extension signals it has finished handling an invocation.
+type Batcher struct {
+	limits        BatchLimits
+	records       <-chan Record
+	flushRequests chan chan struct{}
+	done          chan struct{}
+}
+
+// NewBatcher returns a Batcher which receives records from the provided channel into batches limited by the provided BatchLimits
+func NewBatcher(records <-chan Record, limits BatchLimits) *Batcher {
+	return &Batcher{
+		limits:        limits,
+		records:       records,
+		flushRequests: make(chan chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Run receives records into batches & passes them to the batch callback until the records channel is closed, at which point it passes
+// any remaining records to the batch callback & returns. The batch callback is called from the goroutine Run is called from, so no
+// records are received while it is running.
+func (b *Batcher) Run(batchCallback func([]Record)) {
+	defer close(b.done)
+
+	batch := []Record{}
+	batchBytes := 0
+	var lingerTimer *time.Timer
+	var lingerTimerChannel <-chan time.Time
+
+	flush := func() {
+		if lingerTimer != nil {
+			lingerTimer.Stop()
+			lingerTimer, lingerTimerChannel = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		batchCallback(batch)
+		batch = []Record{}
+		batchBytes = 0
+	}
+
+	add := func(record Record) {
+		recordBytes := record.size()
+		if len(batch) > 0 && batchBytes+recordBytes > b.limits.MaxBytes {
+			flush()
+		}
+		batch = append(batch, record)
+		batchBytes += recordBytes
+		if len(batch) >= b.limits.MaxItems || batchBytes >= b.limits.MaxBytes {
+			flush()
+		} else if lingerTimer == nil {
+			lingerTimer = time.NewTimer(b.limits.MaxLinger)
+			lingerTimerChannel = lingerTimer.C
+		}
+	}
+
+	for {
+		select {
+		case record, open := <-b.records:
+			if !open {
+				flush()
+				return
+			}
+			add(record)
+
+		case <-lingerTimerChannel:
+			lingerTimer, lingerTimerChannel = nil, nil
+			flush()
+
+		case flushed := <-b.flushRequests:
+			// Any records already in the channel's buffer are included in the flush
+			for drained := false; !drained; {
+				select {
+				case record, open := <-b.records:
+					if !open {
+						flush()
+						close(flushed)
+						return
+					}
+					add(record)
+				default:
+					drained = true
+				}
+			}
+			flush()
+			close(flushed)
+		}
+	}
+}
+
+// Flush passes the records in the current batch, and any records waiting in the records channel, to the batch callback, and returns once
+// it has returned. If the context is done first, its err is returned. If the Batcher has stopped running, Flush returns immediately.
+func (b *Batcher) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case b.flushRequests <- flushed:
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// size returns the approximate size of the record in bytes, for limiting the size of batches
+func (r *Record) size() int {
+	return len(r.Event) + len(r.RawResponse) + len(r.Response.Body)
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin
+
+package firetail
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+// getCPUTime returns the user & system CPU time used by the process so far
+func getCPUTime(b *testing.B) time.Duration {
+	var rusage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+}
+
+// BenchmarkBatcherIdle reports the CPU time a running Batcher uses per second while it has no records to receive, which should be
+// close to zero as it blocks on its records channel rather than polling it
+func BenchmarkBatcherIdle(b *testing.B) {
+	recordsChannel := make(chan Record)
+	batcher := NewBatcher(recordsChannel, *DefaultBatchLimits)
+	go batcher.Run(func(batch []Record) {})
+	defer close(recordsChannel)
+
+	idleTime := 10 * time.Millisecond
+	b.ResetTimer()
+	cpuTimeBefore := getCPUTime(b)
+	for i := 0; i < b.N; i++ {
+		time.Sleep(idleTime)
+	}
+	cpuTimeUsed := getCPUTime(b) - cpuTimeBefore
+	b.ReportMetric(float64(cpuTimeUsed.Microseconds())/(float64(b.N)*idleTime.Seconds()), "cpu-µs/idle-s")
+}
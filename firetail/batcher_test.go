@@ -0,0 +1,144 @@
+package firetail
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runTestBatcher(recordsChannel chan Record, limits BatchLimits) (*Batcher, chan []Record) {
+	batches := make(chan []Record, 100)
+	batcher := NewBatcher(recordsChannel, limits)
+	go func() {
+		batcher.Run(func(batch []Record) { batches <- batch })
+		close(batches)
+	}()
+	return batcher, batches
+}
+
+func TestBatcherMaxItems(t *testing.T) {
+	recordsChannel := make(chan Record, 10)
+	_, batches := runTestBatcher(recordsChannel, BatchLimits{MaxItems: 3, MaxBytes: 1024, MaxLinger: time.Hour})
+
+	for i := 0; i < 7; i++ {
+		recordsChannel <- Record{ExecutionTime: float64(i)}
+	}
+	assert.Len(t, <-batches, 3)
+	assert.Len(t, <-batches, 3)
+
+	// The last record is flushed when the channel is closed
+	close(recordsChannel)
+	lastBatch := <-batches
+	require.Len(t, lastBatch, 1)
+	assert.Equal(t, float64(6), lastBatch[0].ExecutionTime)
+	_, open := <-batches
+	assert.False(t, open)
+}
+
+func TestBatcherMaxBytes(t *testing.T) {
+	recordsChannel := make(chan Record, 10)
+	_, batches := runTestBatcher(recordsChannel, BatchLimits{MaxItems: 100, MaxBytes: 100, MaxLinger: time.Hour})
+
+	record := Record{Event: json.RawMessage(`"` + strings.Repeat("a", 38) + `"`)}
+	recordsChannel <- record
+	recordsChannel <- record
+	recordsChannel <- record
+	assert.Len(t, <-batches, 2)
+
+	// A record larger than the max bytes is batched alone
+	recordsChannel <- Record{Event: json.RawMessage(`"` + strings.Repeat("a", 200) + `"`)}
+	assert.Len(t, <-batches, 1)
+	assert.Len(t, <-batches, 1)
+	close(recordsChannel)
+}
+
+func TestBatcherMaxLinger(t *testing.T) {
+	recordsChannel := make(chan Record, 10)
+	_, batches := runTestBatcher(recordsChannel, BatchLimits{MaxItems: 100, MaxBytes: 1024, MaxLinger: 10 * time.Millisecond})
+
+	recordsChannel <- Record{}
+	recordsChannel <- Record{}
+	select {
+	case batch := <-batches:
+		assert.Len(t, batch, 2)
+	case <-time.After(time.Second):
+		t.Fatal("Batch wasn't flushed after the max linger time")
+	}
+	close(recordsChannel)
+}
+
+func TestBatcherFlush(t *testing.T) {
+	recordsChannel := make(chan Record, 10)
+	batcher, batches := runTestBatcher(recordsChannel, BatchLimits{MaxItems: 100, MaxBytes: 1024, MaxLinger: time.Hour})
+
+	recordsChannel <- Record{}
+	recordsChannel <- Record{}
+	require.Nil(t, batcher.Flush(context.Background()))
+	assert.Len(t, <-batches, 2)
+
+	// Flushing an empty batch doesn't call the batch callback
+	require.Nil(t, batcher.Flush(context.Background()))
+	assert.Len(t, batches, 0)
+
+	// Once the batcher has stopped, flushing returns immediately
+	close(recordsChannel)
+	_, open := <-batches
+	assert.False(t, open)
+	require.Nil(t, batcher.Flush(context.Background()))
+}
+
+func TestBatcherFlushContextDone(t *testing.T) {
+	recordsChannel := make(chan Record, 10)
+	batcher := NewBatcher(recordsChannel, BatchLimits{MaxItems: 100, MaxBytes: 1024, MaxLinger: time.Hour})
+	unblockCallback := make(chan struct{})
+	go batcher.Run(func(batch []Record) { <-unblockCallback })
+	defer close(recordsChannel)
+	defer close(unblockCallback)
+
+	recordsChannel <- Record{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, batcher.Flush(ctx))
+}
+
+func TestBatchLimitsLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_MAX_BATCH_SIZE", "50")
+	t.Setenv("FIRETAIL_MAX_BATCH_BYTES", "2048")
+	t.Setenv("FIRETAIL_MAX_BATCH_LINGER", "1s")
+	batchLimits := &BatchLimits{}
+	require.Nil(t, batchLimits.LoadEnvVars())
+	assert.Equal(t, &BatchLimits{MaxItems: 50, MaxBytes: 2048, MaxLinger: time.Second}, batchLimits)
+
+	t.Setenv("FIRETAIL_MAX_BATCH_LINGER", "0s")
+	err := (&BatchLimits{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_MAX_BATCH_LINGER is 0s but must be > 0", err.Error())
+
+	t.Setenv("FIRETAIL_MAX_BATCH_BYTES", "0")
+	err = (&BatchLimits{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_MAX_BATCH_BYTES is 0 but must be >= 1", err.Error())
+}
+
+func BenchmarkBatcherThroughput(b *testing.B) {
+	recordsChannel := make(chan Record, 1000)
+	batcher := NewBatcher(recordsChannel, *DefaultBatchLimits)
+	done := make(chan struct{})
+	go func() {
+		batcher.Run(func(batch []Record) {})
+		close(done)
+	}()
+
+	record := Record{Event: json.RawMessage(`{"version":"2.0","routeKey":"$default"}`)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recordsChannel <- record
+	}
+	close(recordsChannel)
+	<-done
+}
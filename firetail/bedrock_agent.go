@@ -0,0 +1,152 @@
+package firetail
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// bedrockAgentEvent is the event Amazon Bedrock Agents invoke action group functions defined by an OpenAPI schema with
+type bedrockAgentEvent struct {
+	MessageVersion string `json:"messageVersion"`
+	Agent          struct {
+		Name    string `json:"name"`
+		ID      string `json:"id"`
+		Alias   string `json:"alias"`
+		Version string `json:"version"`
+	} `json:"agent"`
+	SessionID   string                   `json:"sessionId"`
+	ActionGroup string                   `json:"actionGroup"`
+	APIPath     string                   `json:"apiPath"`
+	HTTPMethod  string                   `json:"httpMethod"`
+	Parameters  []bedrockAgentParameter  `json:"parameters"`
+	RequestBody *bedrockAgentRequestBody `json:"requestBody"`
+}
+
+type bedrockAgentParameter struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// bedrockAgentRequestBody maps the content type of the request body to the properties the agent elicited for it
+type bedrockAgentRequestBody struct {
+	Content map[string]struct {
+		Properties []bedrockAgentParameter `json:"properties"`
+	} `json:"content"`
+}
+
+// bedrockAgentResponse is the envelope action group functions defined by an OpenAPI schema must respond with. Its responseBody maps the
+// content type of the response body to the body itself.
+type bedrockAgentResponse struct {
+	Response struct {
+		HTTPStatusCode int64 `json:"httpStatusCode"`
+		ResponseBody   map[string]struct {
+			Body string `json:"body"`
+		} `json:"responseBody"`
+	} `json:"response"`
+}
+
+// getBedrockAgentLogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is an event from
+// a Bedrock Agent calling an action group defined by an OpenAPI schema. Otherwise, it returns nil and false.
+//
+// The agent's call is logged as a request to the operation of the schema it calls. Its resource is the operation's path and its URI is that
+// path with the agent's path parameters substituted into it and any other parameters as its query string, as the event doesn't say where
+// each parameter belongs. The request body is a JSON object of the properties the agent provided. Bedrock Agent events don't include the
+// time of the request or a client IP.
+func (r *Record) getBedrockAgentLogEntry() (*LogEntry, bool) {
+	var bedrockAgentEvent bedrockAgentEvent
+	if err := json.Unmarshal(r.Event, &bedrockAgentEvent); err != nil || bedrockAgentEvent.MessageVersion == "" ||
+		bedrockAgentEvent.Agent.ID == "" || bedrockAgentEvent.APIPath == "" {
+		return nil, false
+	}
+
+	requestHeaders := map[string][]string{}
+	requestBody := ""
+	if bedrockAgentEvent.RequestBody != nil {
+		contentTypes := []string{}
+		for contentType := range bedrockAgentEvent.RequestBody.Content {
+			contentTypes = append(contentTypes, contentType)
+		}
+		sort.Strings(contentTypes)
+		if len(contentTypes) > 0 {
+			requestHeaders["content-type"] = []string{contentTypes[0]}
+			requestBody = getBedrockAgentParametersJSON(bedrockAgentEvent.RequestBody.Content[contentTypes[0]].Properties)
+		}
+	}
+
+	logEntry := r.newLogEntry(
+		LogEntryRequest{
+			Body:     requestBody,
+			Headers:  requestHeaders,
+			Method:   LogEntryMethod(strings.ToUpper(bedrockAgentEvent.HTTPMethod)),
+			URI:      getBedrockAgentURI(bedrockAgentEvent.APIPath, bedrockAgentEvent.Parameters),
+			Resource: normaliseResource(bedrockAgentEvent.APIPath),
+		},
+		0,
+	)
+
+	var bedrockAgentResponse bedrockAgentResponse
+	if err := json.Unmarshal(r.RawResponse, &bedrockAgentResponse); err == nil && bedrockAgentResponse.Response.ResponseBody != nil {
+		logEntry.Response = LogEntryResponse{
+			Headers:    map[string][]string{},
+			StatusCode: bedrockAgentResponse.Response.HTTPStatusCode,
+		}
+		// Bedrock treats responses without a status code as successful
+		if logEntry.Response.StatusCode == 0 {
+			logEntry.Response.StatusCode = 200
+		}
+		contentTypes := []string{}
+		for contentType := range bedrockAgentResponse.Response.ResponseBody {
+			contentTypes = append(contentTypes, contentType)
+		}
+		sort.Strings(contentTypes)
+		if len(contentTypes) > 0 {
+			logEntry.Response.Headers["content-type"] = []string{contentTypes[0]}
+			logEntry.Response.Body = bedrockAgentResponse.Response.ResponseBody[contentTypes[0]].Body
+		}
+	}
+
+	logEntry.Metadata.BedrockAgent = &LogEntryBedrockAgentMetadata{
+		AgentID:      bedrockAgentEvent.Agent.ID,
+		AgentName:    bedrockAgentEvent.Agent.Name,
+		AgentAlias:   bedrockAgentEvent.Agent.Alias,
+		AgentVersion: bedrockAgentEvent.Agent.Version,
+		SessionID:    bedrockAgentEvent.SessionID,
+		ActionGroup:  bedrockAgentEvent.ActionGroup,
+	}
+
+	return logEntry, true
+}
+
+// getBedrockAgentURI substitutes the parameters named in the API path into it, and appends the remaining parameters as a query string
+func getBedrockAgentURI(apiPath string, parameters []bedrockAgentParameter) string {
+	queryParameters := url.Values{}
+	for _, parameter := range parameters {
+		pathParameter := "{" + parameter.Name + "}"
+		if strings.Contains(apiPath, pathParameter) {
+			apiPath = strings.ReplaceAll(apiPath, pathParameter, url.PathEscape(parameter.Value))
+			continue
+		}
+		queryParameters.Add(parameter.Name, parameter.Value)
+	}
+	if len(queryParameters) > 0 {
+		return apiPath + "?" + queryParameters.Encode()
+	}
+	return apiPath
+}
+
+// getBedrockAgentParametersJSON returns a JSON object of the names & values of the parameters. The values are given as strings by Bedrock,
+// so they are kept as strings.
+func getBedrockAgentParametersJSON(parameters []bedrockAgentParameter) string {
+	parameterValues := map[string]string{}
+	for _, parameter := range parameters {
+		parameterValues[parameter.Name] = parameter.Value
+	}
+	parametersJSON, err := json.Marshal(parameterValues)
+	if err != nil {
+		return ""
+	}
+	return string(parametersJSON)
+}
@@ -0,0 +1,86 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBedrockAgentEvent = `{
+	"messageVersion": "1.0",
+	"agent": {"name": "PetAgent", "id": "AGENT123", "alias": "TSTALIASID", "version": "DRAFT"},
+	"inputText": "Update the name of pet 42 to Rex",
+	"sessionId": "123456789012345",
+	"actionGroup": "PetActions",
+	"apiPath": "/pets/{petId}",
+	"httpMethod": "put",
+	"parameters": [{"name": "petId", "type": "string", "value": "42"}, {"name": "notify", "type": "boolean", "value": "true"}],
+	"requestBody": {"content": {"application/json": {"properties": [{"name": "name", "type": "string", "value": "Rex"}]}}},
+	"sessionAttributes": {},
+	"promptSessionAttributes": {}
+}`
+
+func TestGetBedrockAgentLogEntry(t *testing.T) {
+	testRecord := Record{
+		Event:         json.RawMessage(testBedrockAgentEvent),
+		RawResponse:   json.RawMessage(`{"messageVersion":"1.0","response":{"actionGroup":"PetActions","apiPath":"/pets/{petId}","httpMethod":"PUT","httpStatusCode":201,"responseBody":{"application/json":{"body":"{\"id\":42,\"name\":\"Rex\"}"}}}}`),
+		ExecutionTime: 50,
+		CapturedAt:    1668685315222,
+	}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+
+	assert.Equal(t, int64(1668685315222), logEntry.DateCreated)
+	assert.Equal(t, LogEntryRequest{
+		Body:     `{"name":"Rex"}`,
+		Headers:  map[string][]string{"content-type": {"application/json"}},
+		Method:   Put,
+		URI:      "/pets/42?notify=true",
+		Resource: "/pets/{petId}",
+	}, logEntry.Request)
+	assert.Equal(t, LogEntryResponse{
+		Body:       `{"id":42,"name":"Rex"}`,
+		Headers:    map[string][]string{"content-type": {"application/json"}},
+		StatusCode: 201,
+	}, logEntry.Response)
+	assert.Equal(t, &LogEntryBedrockAgentMetadata{
+		AgentID:      "AGENT123",
+		AgentName:    "PetAgent",
+		AgentAlias:   "TSTALIASID",
+		AgentVersion: "DRAFT",
+		SessionID:    "123456789012345",
+		ActionGroup:  "PetActions",
+	}, logEntry.Metadata.BedrockAgent)
+}
+
+func TestGetBedrockAgentLogEntryDefaultStatusCode(t *testing.T) {
+	testRecord := Record{
+		Event:       json.RawMessage(testBedrockAgentEvent),
+		RawResponse: json.RawMessage(`{"messageVersion":"1.0","response":{"responseBody":{"application/json":{"body":"{}"}}}}`),
+	}
+
+	logEntry, ok := testRecord.getBedrockAgentLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, int64(200), logEntry.Response.StatusCode)
+	assert.Equal(t, "{}", logEntry.Response.Body)
+}
+
+func TestGetBedrockAgentLogEntryNotBedrockAgent(t *testing.T) {
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(getNewAPIGatewayV2HTTPRequest())
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes)}
+
+	logEntry, ok := testRecord.getBedrockAgentLogEntry()
+	assert.False(t, ok)
+	assert.Nil(t, logEntry)
+}
+
+func TestGetBedrockAgentURI(t *testing.T) {
+	assert.Equal(t, "/pets", getBedrockAgentURI("/pets", nil))
+	assert.Equal(t, "/pets/a%2Fb", getBedrockAgentURI("/pets/{petId}", []bedrockAgentParameter{{Name: "petId", Value: "a/b"}}))
+	assert.Equal(t, "/pets?limit=10&type=cat", getBedrockAgentURI("/pets", []bedrockAgentParameter{{Name: "type", Value: "cat"}, {Name: "limit", Value: "10"}}))
+}
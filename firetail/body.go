@@ -0,0 +1,53 @@
+package firetail
+
+import (
+	"encoding/base64"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// textualContentTypes are the media types, besides text/*, +json and +xml, whose bodies are safe to log as strings
+var textualContentTypes = map[string]bool{
+	"application/graphql":               true,
+	"application/javascript":            true,
+	"application/json":                  true,
+	"application/ld+json":               true,
+	"application/x-javascript":          true,
+	"application/x-www-form-urlencoded": true,
+	"application/x-yaml":                true,
+	"application/xml":                   true,
+	"application/yaml":                  true,
+}
+
+// isTextualContentType returns true if the provided Content-Type header value describes a textual media type
+func isTextualContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		textualContentTypes[mediaType]
+}
+
+// decodeBase64Body takes a base64 encoded body and the value of the Content-Type header that accompanied it. If the content type is
+// textual, it returns the decoded body and false. Otherwise, it returns the body as it was given and true to indicate that the body is
+// binary data which is still base64 encoded. If no content type is given, the body is treated as textual if it decodes to valid UTF-8.
+func decodeBase64Body(body, contentType string) (string, bool) {
+	decodedBody, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return body, true
+	}
+	if contentType == "" {
+		if utf8.Valid(decodedBody) {
+			return string(decodedBody), false
+		}
+		return body, true
+	}
+	if !isTextualContentType(contentType) {
+		return body, true
+	}
+	return string(decodedBody), false
+}
@@ -0,0 +1,56 @@
+package firetail
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTextualContentType(t *testing.T) {
+	testCases := map[string]bool{
+		"text/plain":                        true,
+		"text/html; charset=utf-8":          true,
+		"application/json":                  true,
+		"Application/JSON; charset=UTF-8":   true,
+		"application/problem+json":          true,
+		"application/atom+xml":              true,
+		"application/x-www-form-urlencoded": true,
+		"image/png":                         false,
+		"application/octet-stream":          false,
+		"application/pdf":                   false,
+	}
+	for contentType, expected := range testCases {
+		assert.Equal(t, expected, isTextualContentType(contentType), contentType)
+	}
+}
+
+func TestDecodeBase64BodyTextual(t *testing.T) {
+	body, isBinary := decodeBase64Body(base64.StdEncoding.EncodeToString([]byte(`{"hello":"world"}`)), "application/json")
+	assert.Equal(t, `{"hello":"world"}`, body)
+	assert.False(t, isBinary)
+}
+
+func TestDecodeBase64BodyBinary(t *testing.T) {
+	encodedBody := base64.StdEncoding.EncodeToString([]byte{0x89, 0x50, 0x4e, 0x47})
+	body, isBinary := decodeBase64Body(encodedBody, "image/png")
+	assert.Equal(t, encodedBody, body)
+	assert.True(t, isBinary)
+}
+
+func TestDecodeBase64BodyNoContentType(t *testing.T) {
+	body, isBinary := decodeBase64Body(base64.StdEncoding.EncodeToString([]byte("hello")), "")
+	assert.Equal(t, "hello", body)
+	assert.False(t, isBinary)
+
+	encodedBody := base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe, 0xfd})
+	body, isBinary = decodeBase64Body(encodedBody, "")
+	assert.Equal(t, encodedBody, body)
+	assert.True(t, isBinary)
+}
+
+func TestDecodeBase64BodyInvalidBase64(t *testing.T) {
+	body, isBinary := decodeBase64Body("not base64!", "text/plain")
+	assert.Equal(t, "not base64!", body)
+	assert.True(t, isBinary)
+}
@@ -0,0 +1,185 @@
+package firetail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pkg/errors"
+)
+
+// The types of caller identity, which describe how the caller was authenticated
+const (
+	callerTypeAPIKey  = "apiKey"
+	callerTypeCognito = "cognito"
+	callerTypeIAM     = "iam"
+	callerTypeJWT     = "jwt"
+	callerTypeLambda  = "lambda"
+)
+
+// The algorithms identifiers of callers can be hashed with before they're logged
+const (
+	IdentifierHashingNone       = "none"
+	IdentifierHashingSHA256     = "sha256"
+	IdentifierHashingHMACSHA256 = "hmac-sha256"
+)
+
+// IdentifierHashing configures how the identifiers in caller identities (subjects, IAM principals & account IDs) and the principal IDs of
+// Lambda authorizers are hashed before they're logged. Hashed identifiers are prefixed with the algorithm used, e.g. sha256:{hex digest},
+// so that they can still be used to attribute traffic to callers without revealing who they are.
+type IdentifierHashing struct {
+	Algorithm string // One of IdentifierHashingNone, IdentifierHashingSHA256 or IdentifierHashingHMACSHA256
+	Key       []byte // The key used by IdentifierHashingHMACSHA256
+}
+
+// DefaultIdentifierHashing is the IdentifierHashing used for all log entries. It doesn't hash identifiers until configured otherwise.
+var DefaultIdentifierHashing = &IdentifierHashing{Algorithm: IdentifierHashingNone}
+
+// LoadEnvVars configures the IdentifierHashing from the FIRETAIL_IDENTIFIER_HASHING & FIRETAIL_IDENTIFIER_HASH_KEY env vars
+func (h *IdentifierHashing) LoadEnvVars() error {
+	return h.loadEnvVars("FIRETAIL_IDENTIFIER_HASHING", "FIRETAIL_IDENTIFIER_HASH_KEY")
+}
+
+// loadEnvVars configures the IdentifierHashing from the env vars with the provided names, which hold the algorithm & the HMAC key
+func (h *IdentifierHashing) loadEnvVars(algorithmEnvVar, keyEnvVar string) error {
+	if algorithm, isSet := os.LookupEnv(algorithmEnvVar); isSet {
+		switch algorithm {
+		case IdentifierHashingNone, IdentifierHashingSHA256, IdentifierHashingHMACSHA256:
+			h.Algorithm = algorithm
+		default:
+			return errors.Errorf("%s is %s but must be one of %s, %s or %s", algorithmEnvVar, algorithm,
+				IdentifierHashingNone, IdentifierHashingSHA256, IdentifierHashingHMACSHA256)
+		}
+	}
+	if key, isSet := os.LookupEnv(keyEnvVar); isSet {
+		h.Key = []byte(key)
+	}
+	if h.Algorithm == IdentifierHashingHMACSHA256 && len(h.Key) == 0 {
+		return errors.Errorf("%s must be set when %s is %s", keyEnvVar, algorithmEnvVar, IdentifierHashingHMACSHA256)
+	}
+	return nil
+}
+
+// Hash returns the identifier hashed with the configured algorithm. Empty identifiers are left empty.
+func (h *IdentifierHashing) Hash(identifier string) string {
+	if identifier == "" {
+		return ""
+	}
+	switch h.Algorithm {
+	case IdentifierHashingSHA256:
+		digest := sha256.Sum256([]byte(identifier))
+		return IdentifierHashingSHA256 + ":" + hex.EncodeToString(digest[:])
+	case IdentifierHashingHMACSHA256:
+		mac := hmac.New(sha256.New, h.Key)
+		mac.Write([]byte(identifier))
+		return IdentifierHashingHMACSHA256 + ":" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return identifier
+}
+
+// hashIdentifiers hashes the identifiers of the caller identity in place. API key IDs identify consumers rather than callers, so they're
+// hashed by the ConsumerIdentification instead.
+func (c *LogEntryCallerMetadata) hashIdentifiers(identifierHashing *IdentifierHashing) {
+	c.Subject = identifierHashing.Hash(c.Subject)
+	c.IAMPrincipal = identifierHashing.Hash(c.IAMPrincipal)
+	c.AccountID = identifierHashing.Hash(c.AccountID)
+}
+
+// getAPIGatewayV1CallerIdentity returns the identity of the caller of an API Gateway v1 request, as given by a Cognito user pool or
+// Lambda authorizer, IAM authorization, or an API key. It returns nil if the request wasn't authenticated. Only the ID of an API key is
+// used, never the key itself.
+func getAPIGatewayV1CallerIdentity(requestContext events.APIGatewayProxyRequestContext) *LogEntryCallerMetadata {
+	callerIdentity := &LogEntryCallerMetadata{APIKeyID: requestContext.Identity.APIKeyID}
+
+	if claims, ok := requestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		callerIdentity.Type = callerTypeCognito
+		callerIdentity.Subject = getStringClaim(claims, "sub")
+		callerIdentity.Issuer = getStringClaim(claims, "iss")
+		callerIdentity.Scopes = getScopesFromClaims(claims)
+	} else if principalID, ok := requestContext.Authorizer["principalId"].(string); ok && principalID != "" {
+		callerIdentity.Type = callerTypeLambda
+		callerIdentity.Subject = principalID
+	} else if requestContext.Identity.UserArn != "" {
+		callerIdentity.Type = callerTypeIAM
+		callerIdentity.IAMPrincipal = requestContext.Identity.UserArn
+		callerIdentity.AccountID = requestContext.Identity.AccountID
+	} else if callerIdentity.APIKeyID != "" {
+		callerIdentity.Type = callerTypeAPIKey
+	} else {
+		return nil
+	}
+
+	return callerIdentity
+}
+
+// getAPIGatewayV2CallerIdentity returns the identity of the caller of an API Gateway v2 request, as given by a JWT, Lambda or IAM
+// authorizer. It returns nil if the request wasn't authenticated.
+func getAPIGatewayV2CallerIdentity(requestContext events.APIGatewayV2HTTPRequestContext) *LogEntryCallerMetadata {
+	authorizer := requestContext.Authorizer
+	if authorizer == nil {
+		return nil
+	}
+
+	switch {
+	case authorizer.JWT != nil:
+		claims := map[string]interface{}{}
+		for claimName, claimValue := range authorizer.JWT.Claims {
+			claims[claimName] = claimValue
+		}
+		callerIdentity := &LogEntryCallerMetadata{
+			Type:    callerTypeJWT,
+			Subject: authorizer.JWT.Claims["sub"],
+			Issuer:  authorizer.JWT.Claims["iss"],
+			Scopes:  authorizer.JWT.Scopes,
+		}
+		if len(callerIdentity.Scopes) == 0 {
+			callerIdentity.Scopes = getScopesFromClaims(claims)
+		}
+		return callerIdentity
+	case authorizer.IAM != nil:
+		return &LogEntryCallerMetadata{
+			Type:         callerTypeIAM,
+			IAMPrincipal: authorizer.IAM.UserARN,
+			AccountID:    authorizer.IAM.AccountID,
+		}
+	case authorizer.Lambda != nil:
+		callerIdentity := &LogEntryCallerMetadata{Type: callerTypeLambda}
+		if principalID, ok := authorizer.Lambda["principalId"].(string); ok {
+			callerIdentity.Subject = principalID
+		}
+		return callerIdentity
+	}
+
+	return nil
+}
+
+// getStringClaim returns the value of a claim if it is a string, or otherwise the empty string
+func getStringClaim(claims map[string]interface{}, claimName string) string {
+	if claimValue, ok := claims[claimName].(string); ok {
+		return claimValue
+	}
+	return ""
+}
+
+// getScopesFromClaims returns the scopes in the scope (a space-separated string, per RFC 8693) or scp (a list, as used by some identity
+// providers) claim, sorted
+func getScopesFromClaims(claims map[string]interface{}) []string {
+	scopes := strings.Fields(getStringClaim(claims, "scope"))
+	if scpClaim, ok := claims["scp"].([]interface{}); ok {
+		for _, scope := range scpClaim {
+			scopes = append(scopes, fmt.Sprint(scope))
+		}
+	} else if scpClaim := getStringClaim(claims, "scp"); scpClaim != "" {
+		scopes = append(scopes, strings.Fields(scpClaim)...)
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	sort.Strings(scopes)
+	return scopes
+}
@@ -0,0 +1,191 @@
+package firetail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The states of a CircuitBreaker
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// The policies for batches of records which aren't sent because a CircuitBreaker is open
+const (
+	OverflowPolicySpool = "spool"
+	OverflowPolicyDrop  = "drop"
+)
+
+// CircuitOpenError is returned when a batch of records isn't sent to Firetail because the CircuitBreaker is open
+type CircuitOpenError struct {
+	OverflowPolicy string
+}
+
+func (e *CircuitOpenError) Error() string {
+	if e.OverflowPolicy == OverflowPolicyDrop {
+		return "Circuit breaker is open, dropping batch"
+	}
+	return "Circuit breaker is open, spooling batch"
+}
+
+// CircuitBreaker configures a circuit breaker around sending batches of records to Firetail, so that during an outage invocations don't
+// spend time on uploads which will fail. The circuit opens after FailureThreshold consecutive sends fail, whether or not their err is
+// retriable. While it's open, batches aren't sent & are instead handled according to the OverflowPolicy: OverflowPolicySpool keeps them in
+// the spool to be replayed, and OverflowPolicyDrop drops them. Once the circuit has been open for the Cooldown it's half-open, and the next
+// send is let through as a trial: if it succeeds the circuit closes, otherwise it opens again.
+type CircuitBreaker struct {
+	FailureThreshold int           // The number of consecutive failed sends after which the circuit opens
+	Cooldown         time.Duration // How long the circuit stays open before a trial send is let through
+	OverflowPolicy   string        // One of OverflowPolicySpool or OverflowPolicyDrop
+
+	mutex               sync.Mutex
+	state               string
+	consecutiveFailures int
+	openedAt            time.Time
+	spooledRecords      int64
+	droppedRecords      int64
+}
+
+// DefaultCircuitBreaker is the CircuitBreaker used when sending records to Firetail
+var DefaultCircuitBreaker = &CircuitBreaker{
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+	OverflowPolicy:   OverflowPolicySpool,
+}
+
+// LoadEnvVars configures the CircuitBreaker from the FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD, FIRETAIL_CIRCUIT_BREAKER_COOLDOWN &
+// FIRETAIL_OVERFLOW_POLICY env vars. FIRETAIL_CIRCUIT_BREAKER_COOLDOWN is parsed by time.ParseDuration.
+func (b *CircuitBreaker) LoadEnvVars() error {
+	if failureThresholdStr, isSet := os.LookupEnv("FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD"); isSet {
+		failureThreshold, err := strconv.Atoi(failureThresholdStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD invalid")
+		}
+		if failureThreshold < 1 {
+			return errors.Errorf("FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD is %d but must be >= 1", failureThreshold)
+		}
+		b.FailureThreshold = failureThreshold
+	}
+	if cooldownStr, isSet := os.LookupEnv("FIRETAIL_CIRCUIT_BREAKER_COOLDOWN"); isSet {
+		cooldown, err := time.ParseDuration(cooldownStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_CIRCUIT_BREAKER_COOLDOWN invalid")
+		}
+		if cooldown <= 0 {
+			return errors.Errorf("FIRETAIL_CIRCUIT_BREAKER_COOLDOWN is %s but must be > 0", cooldownStr)
+		}
+		b.Cooldown = cooldown
+	}
+	if overflowPolicy, isSet := os.LookupEnv("FIRETAIL_OVERFLOW_POLICY"); isSet {
+		switch overflowPolicy {
+		case OverflowPolicySpool, OverflowPolicyDrop:
+			b.OverflowPolicy = overflowPolicy
+		default:
+			return errors.Errorf("FIRETAIL_OVERFLOW_POLICY is %s but must be one of %s or %s", overflowPolicy, OverflowPolicySpool,
+				OverflowPolicyDrop)
+		}
+	}
+	return nil
+}
+
+// Send calls send with the batch unless the circuit is open, in which case the batch is handled according to the OverflowPolicy. If
+// the OverflowPolicy is OverflowPolicySpool a RetriableError is returned so the spool keeps the batch, and otherwise a PermanentError is
+// returned so it's dropped. Both wrap a CircuitOpenError. Only sends which succeed count as successes.
+func (b *CircuitBreaker) Send(batch []Record, send func([]Record) error) error {
+	if !b.allow() {
+		circuitOpenErr := &CircuitOpenError{OverflowPolicy: b.OverflowPolicy}
+		if b.OverflowPolicy == OverflowPolicyDrop {
+			atomic.AddInt64(&b.droppedRecords, int64(len(batch)))
+			return &PermanentError{circuitOpenErr}
+		}
+		atomic.AddInt64(&b.spooledRecords, int64(len(batch)))
+		return &RetriableError{Err: circuitOpenErr}
+	}
+	err := send(batch)
+	b.recordResult(err != nil)
+	return err
+}
+
+// allow returns true if a send should be let through, moving the circuit from open to half-open once the cooldown has elapsed
+func (b *CircuitBreaker) allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		b.setState(CircuitHalfOpen)
+		return true
+	case CircuitHalfOpen:
+		// A trial send is already in progress
+		return false
+	}
+	return true
+}
+
+// recordResult records the result of a send, opening or closing the circuit if necessary
+func (b *CircuitBreaker) recordResult(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !failed {
+		b.consecutiveFailures = 0
+		if b.state == CircuitOpen || b.state == CircuitHalfOpen {
+			b.setState(CircuitClosed)
+		}
+		return
+	}
+	b.consecutiveFailures++
+	if b.state == CircuitHalfOpen || (b.state != CircuitOpen && b.consecutiveFailures >= b.FailureThreshold) {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+// setState moves the circuit to the provided state & logs its diagnostics. The mutex must be held.
+func (b *CircuitBreaker) setState(state string) {
+	b.state = state
+	log.Printf("Circuit breaker is %s, %s", state, b.diagnostics().String())
+}
+
+// CircuitBreakerDiagnostics describes the state of a CircuitBreaker, and the records it has kept from being sent
+type CircuitBreakerDiagnostics struct {
+	State               string
+	ConsecutiveFailures int
+	SpooledRecords      int64 // The number of records spooled rather than sent while the circuit was open
+	DroppedRecords      int64 // The number of records dropped rather than sent while the circuit was open
+}
+
+func (d CircuitBreakerDiagnostics) String() string {
+	return fmt.Sprintf("state: %s, consecutive failures: %d, spooled records: %d, dropped records: %d", d.State, d.ConsecutiveFailures,
+		d.SpooledRecords, d.DroppedRecords)
+}
+
+// Diagnostics returns the current state of the CircuitBreaker, and the records it has kept from being sent
+func (b *CircuitBreaker) Diagnostics() CircuitBreakerDiagnostics {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.diagnostics()
+}
+
+func (b *CircuitBreaker) diagnostics() CircuitBreakerDiagnostics {
+	state := b.state
+	if state == "" {
+		state = CircuitClosed
+	}
+	return CircuitBreakerDiagnostics{
+		State:               state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		SpooledRecords:      atomic.LoadInt64(&b.spooledRecords),
+		DroppedRecords:      atomic.LoadInt64(&b.droppedRecords),
+	}
+}
@@ -0,0 +1,148 @@
+package firetail
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func failingSend(batch []Record) error {
+	return &RetriableError{Err: errors.New("Got 503 response from firetail api")}
+}
+
+func succeedingSend(batch []Record) error {
+	return nil
+}
+
+func TestCircuitBreakerOpensAfterFailureThreshold(t *testing.T) {
+	circuitBreaker := &CircuitBreaker{FailureThreshold: 3, Cooldown: time.Hour, OverflowPolicy: OverflowPolicySpool}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, CircuitClosed, circuitBreaker.Diagnostics().State)
+		err := circuitBreaker.Send([]Record{{}}, failingSend)
+		assert.Equal(t, "Got 503 response from firetail api", err.Error())
+	}
+	assert.Equal(t, CircuitOpen, circuitBreaker.Diagnostics().State)
+
+	// While the circuit is open, batches aren't sent
+	err := circuitBreaker.Send([]Record{{}, {}}, func(batch []Record) error {
+		t.Fatal("Batch shouldn't be sent while the circuit is open")
+		return nil
+	})
+	require.NotNil(t, err)
+	var circuitOpenErr *CircuitOpenError
+	require.True(t, errors.As(err, &circuitOpenErr))
+	assert.Equal(t, "Circuit breaker is open, spooling batch", err.Error())
+	assert.True(t, isRetriable(err))
+	assert.Equal(t, CircuitBreakerDiagnostics{State: CircuitOpen, ConsecutiveFailures: 3, SpooledRecords: 2}, circuitBreaker.Diagnostics())
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	circuitBreaker := &CircuitBreaker{FailureThreshold: 2, Cooldown: time.Hour, OverflowPolicy: OverflowPolicySpool}
+	circuitBreaker.Send([]Record{{}}, failingSend)
+	circuitBreaker.Send([]Record{{}}, succeedingSend)
+	circuitBreaker.Send([]Record{{}}, failingSend)
+	assert.Equal(t, CircuitBreakerDiagnostics{State: CircuitClosed, ConsecutiveFailures: 1}, circuitBreaker.Diagnostics())
+
+	// Only successes reset the failures, so permanent errors count as failures too
+	circuitBreaker.Send([]Record{{}}, func(batch []Record) error {
+		return &PermanentError{errors.New("Got 400 response from firetail api")}
+	})
+	assert.Equal(t, CircuitOpen, circuitBreaker.Diagnostics().State)
+	assert.Equal(t, 2, circuitBreaker.Diagnostics().ConsecutiveFailures)
+}
+
+func TestCircuitBreakerDropOverflowPolicy(t *testing.T) {
+	circuitBreaker := &CircuitBreaker{FailureThreshold: 1, Cooldown: time.Hour, OverflowPolicy: OverflowPolicyDrop}
+	circuitBreaker.Send([]Record{{}}, failingSend)
+
+	err := circuitBreaker.Send([]Record{{}, {}, {}}, succeedingSend)
+	require.NotNil(t, err)
+	assert.Equal(t, "Circuit breaker is open, dropping batch", err.Error())
+	assert.False(t, isRetriable(err))
+	assert.Equal(t, int64(3), circuitBreaker.Diagnostics().DroppedRecords)
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	circuitBreaker := &CircuitBreaker{FailureThreshold: 1, Cooldown: 10 * time.Millisecond, OverflowPolicy: OverflowPolicySpool}
+	circuitBreaker.Send([]Record{{}}, failingSend)
+	assert.Equal(t, CircuitOpen, circuitBreaker.Diagnostics().State)
+
+	// After the cooldown a failed trial send opens the circuit again
+	time.Sleep(20 * time.Millisecond)
+	err := circuitBreaker.Send([]Record{{}}, func(batch []Record) error {
+		assert.Equal(t, CircuitHalfOpen, circuitBreaker.Diagnostics().State)
+		// Only one trial send is let through at a time
+		assert.NotNil(t, circuitBreaker.Send([]Record{{}}, succeedingSend))
+		return failingSend(batch)
+	})
+	assert.Equal(t, "Got 503 response from firetail api", err.Error())
+	assert.Equal(t, CircuitOpen, circuitBreaker.Diagnostics().State)
+	assert.NotNil(t, circuitBreaker.Send([]Record{{}}, succeedingSend))
+
+	// After the cooldown a trial send which fails with a permanent error, such as a 401, doesn't close the circuit
+	time.Sleep(20 * time.Millisecond)
+	err = circuitBreaker.Send([]Record{{}}, func(batch []Record) error {
+		return &PermanentError{errors.New("Got 401 response from firetail api")}
+	})
+	assert.Equal(t, "Got 401 response from firetail api", err.Error())
+	assert.Equal(t, CircuitOpen, circuitBreaker.Diagnostics().State)
+
+	// After the cooldown a successful trial send closes the circuit
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, circuitBreaker.Send([]Record{{}}, succeedingSend))
+	assert.Equal(t, CircuitClosed, circuitBreaker.Diagnostics().State)
+	assert.Nil(t, circuitBreaker.Send([]Record{{}}, succeedingSend))
+}
+
+func TestSendWithRetriesStopsWhenCircuitOpen(t *testing.T) {
+	deadLetters := [][]Record{}
+	attempts := 0
+	circuitBreaker := &CircuitBreaker{FailureThreshold: 1, Cooldown: time.Hour, OverflowPolicy: OverflowPolicySpool}
+	err := getTestRetryPolicy(&deadLetters).SendWithRetries([]Record{{}}, func(batch []Record) error {
+		return circuitBreaker.Send(batch, func(batch []Record) error {
+			attempts++
+			return failingSend(batch)
+		})
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "Circuit breaker is open, spooling batch", err.Error())
+	assert.Equal(t, 1, attempts)
+	// The batch is kept in the spool, so it mustn't be dead-lettered
+	assert.Empty(t, deadLetters)
+
+	// If the circuit breaker drops batches while it's open, they're dead-lettered
+	circuitBreaker.OverflowPolicy = OverflowPolicyDrop
+	err = getTestRetryPolicy(&deadLetters).SendWithRetries([]Record{{}}, func(batch []Record) error {
+		return circuitBreaker.Send(batch, succeedingSend)
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "Circuit breaker is open, dropping batch", err.Error())
+	assert.Len(t, deadLetters, 1)
+}
+
+func TestCircuitBreakerLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD", "10")
+	t.Setenv("FIRETAIL_CIRCUIT_BREAKER_COOLDOWN", "1m")
+	t.Setenv("FIRETAIL_OVERFLOW_POLICY", "drop")
+	circuitBreaker := &CircuitBreaker{}
+	require.Nil(t, circuitBreaker.LoadEnvVars())
+	assert.Equal(t, &CircuitBreaker{FailureThreshold: 10, Cooldown: time.Minute, OverflowPolicy: OverflowPolicyDrop}, circuitBreaker)
+
+	t.Setenv("FIRETAIL_OVERFLOW_POLICY", "buffer")
+	err := (&CircuitBreaker{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_OVERFLOW_POLICY is buffer but must be one of spool or drop", err.Error())
+
+	t.Setenv("FIRETAIL_CIRCUIT_BREAKER_COOLDOWN", "0s")
+	err = (&CircuitBreaker{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_CIRCUIT_BREAKER_COOLDOWN is 0s but must be > 0", err.Error())
+
+	t.Setenv("FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD", "0")
+	err = (&CircuitBreaker{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_CIRCUIT_BREAKER_FAILURE_THRESHOLD is 0 but must be >= 1", err.Error())
+}
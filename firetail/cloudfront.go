@@ -0,0 +1,134 @@
+package firetail
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// cloudFrontEvent is the event Lambda@Edge functions are invoked with by CloudFront
+type cloudFrontEvent struct {
+	Records []struct {
+		CF struct {
+			Config   cloudFrontConfig    `json:"config"`
+			Request  *cloudFrontRequest  `json:"request"`
+			Response *cloudFrontResponse `json:"response"`
+		} `json:"cf"`
+	} `json:"Records"`
+}
+
+type cloudFrontConfig struct {
+	DistributionDomainName string `json:"distributionDomainName"`
+	DistributionID         string `json:"distributionId"`
+	EventType              string `json:"eventType"` // One of viewer-request, origin-request, origin-response or viewer-response
+	RequestID              string `json:"requestId"`
+}
+
+// cloudFrontHeaders maps lower-cased header names to a list of key-value pairs, where the key is the header name as the client sent it
+type cloudFrontHeaders map[string][]struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type cloudFrontRequest struct {
+	ClientIP    string            `json:"clientIp"`
+	Headers     cloudFrontHeaders `json:"headers"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"querystring"`
+	URI         string            `json:"uri"`
+	Body        *struct {
+		Data     string `json:"data"`
+		Encoding string `json:"encoding"`
+	} `json:"body"`
+}
+
+type cloudFrontResponse struct {
+	Status            string            `json:"status"`
+	StatusDescription string            `json:"statusDescription"`
+	Headers           cloudFrontHeaders `json:"headers"`
+	Body              string            `json:"body"`
+	BodyEncoding      string            `json:"bodyEncoding"`
+}
+
+// toMultiValueHeaders converts CloudFront's headers into the multi-value headers format used by normaliseHeaders
+func (h cloudFrontHeaders) toMultiValueHeaders() map[string][]string {
+	multiValueHeaders := map[string][]string{}
+	for headerName, keyValuePairs := range h {
+		for _, keyValuePair := range keyValuePairs {
+			multiValueHeaders[headerName] = append(multiValueHeaders[headerName], keyValuePair.Value)
+		}
+	}
+	return multiValueHeaders
+}
+
+// getCloudFrontLogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is a Lambda@Edge
+// event from CloudFront. Otherwise, it returns nil and false. The response is the one returned by the function if it returned a response
+// (which it must for origin-response & viewer-response triggers, and may for request triggers to generate a response), or else the
+// response from the event. If the function returned a request, there is no response to log yet.
+func (r *Record) getCloudFrontLogEntry() (*LogEntry, bool) {
+	var cloudFrontEvent cloudFrontEvent
+	if err := json.Unmarshal(r.Event, &cloudFrontEvent); err != nil || len(cloudFrontEvent.Records) == 0 ||
+		cloudFrontEvent.Records[0].CF.Request == nil {
+		return nil, false
+	}
+	cf := cloudFrontEvent.Records[0].CF
+
+	requestHeaders := normaliseHeaders(nil, cf.Request.Headers.toMultiValueHeaders())
+	host := getHeaderValue(requestHeaders, "host")
+	if host == "" {
+		host = cf.Config.DistributionDomainName
+	}
+	requestBody, requestBodyIsBase64Encoded := "", false
+	if cf.Request.Body != nil {
+		requestBody = cf.Request.Body.Data
+		if cf.Request.Body.Encoding == "base64" {
+			requestBody, requestBodyIsBase64Encoded = decodeBase64Body(requestBody, getHeaderValue(requestHeaders, "content-type"))
+		}
+	}
+
+	// CloudFront events don't include the time of the request
+	logEntry := r.newLogEntry(
+		LogEntryRequest{
+			Body:            requestBody,
+			Headers:         requestHeaders,
+			HTTPProtocol:    HTTP11,
+			IP:              cf.Request.ClientIP,
+			Method:          LogEntryMethod(cf.Request.Method),
+			URI:             "https://" + host + cf.Request.URI,
+			Resource:        normaliseResource(cf.Request.URI),
+			IsBase64Encoded: requestBodyIsBase64Encoded,
+		},
+		0,
+	)
+
+	var functionResponse cloudFrontResponse
+	if err := json.Unmarshal(r.RawResponse, &functionResponse); err == nil && functionResponse.Status != "" {
+		logEntry.Response = functionResponse.toLogEntryResponse()
+	} else if cf.Response != nil {
+		logEntry.Response = cf.Response.toLogEntryResponse()
+	} else {
+		logEntry.Response = LogEntryResponse{Headers: map[string][]string{}}
+	}
+
+	logEntry.Metadata.CloudFront = &LogEntryCloudFrontMetadata{
+		DistributionID:         cf.Config.DistributionID,
+		DistributionDomainName: cf.Config.DistributionDomainName,
+		EventType:              cf.Config.EventType,
+		RequestID:              cf.Config.RequestID,
+	}
+
+	return logEntry, true
+}
+
+// toLogEntryResponse returns the value for the response field of a Firetail SaaS LogEntry for a CloudFront response
+func (c *cloudFrontResponse) toLogEntryResponse() LogEntryResponse {
+	statusCode, _ := strconv.ParseInt(c.Status, 10, 64)
+	logEntryResponse := LogEntryResponse{
+		Body:       c.Body,
+		Headers:    normaliseHeaders(nil, c.Headers.toMultiValueHeaders()),
+		StatusCode: statusCode,
+	}
+	if c.BodyEncoding == "base64" {
+		logEntryResponse.Body, logEntryResponse.IsBase64Encoded = decodeBase64Body(c.Body, getHeaderValue(logEntryResponse.Headers, "content-type"))
+	}
+	return logEntryResponse
+}
@@ -0,0 +1,131 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCloudFrontOriginResponseEvent = `{
+	"Records": [{
+		"cf": {
+			"config": {
+				"distributionDomainName": "d111111abcdef8.cloudfront.net",
+				"distributionId": "EDFDVBD6EXAMPLE",
+				"eventType": "origin-response",
+				"requestId": "4TyzHTaYWb1GX1qTfsHhEqV6HUDd_BzoBZnwfnvQc_1oF26ClkoUSEQ=="
+			},
+			"request": {
+				"clientIp": "203.0.113.178",
+				"headers": {
+					"host": [{"key": "Host", "value": "www.example.com"}],
+					"user-agent": [{"key": "User-Agent", "value": "Amazon CloudFront"}],
+					"accept-encoding": [{"key": "Accept-Encoding", "value": "gzip, br"}]
+				},
+				"method": "GET",
+				"querystring": "size=large",
+				"uri": "/images/cat.jpg"
+			},
+			"response": {
+				"status": "200",
+				"statusDescription": "OK",
+				"headers": {
+					"content-type": [{"key": "Content-Type", "value": "image/jpeg"}]
+				}
+			}
+		}
+	}]
+}`
+
+func TestGetCloudFrontLogEntryFunctionReturnedResponse(t *testing.T) {
+	testRecord := Record{
+		Event:         json.RawMessage(testCloudFrontOriginResponseEvent),
+		RawResponse:   json.RawMessage(`{"status":"302","statusDescription":"Found","headers":{"location":[{"key":"Location","value":"https://www.example.com/images/dog.jpg"}]}}`),
+		ExecutionTime: 50,
+	}
+
+	logEntry, ok := testRecord.getCloudFrontLogEntry()
+	require.True(t, ok)
+
+	assert.Equal(t, Get, logEntry.Request.Method)
+	assert.Equal(t, "203.0.113.178", logEntry.Request.IP)
+	assert.Equal(t, "https://www.example.com/images/cat.jpg", logEntry.Request.URI)
+	assert.Equal(t, "/images/cat.jpg", logEntry.Request.Resource)
+	assert.Equal(t, []string{"gzip", "br"}, logEntry.Request.Headers["accept-encoding"])
+	assert.Equal(t, float64(50), logEntry.ExecutionTime)
+	assert.Equal(t, int64(302), logEntry.Response.StatusCode)
+	assert.Equal(t, map[string][]string{"location": {"https://www.example.com/images/dog.jpg"}}, logEntry.Response.Headers)
+	assert.Equal(t,
+		&LogEntryCloudFrontMetadata{
+			DistributionID:         "EDFDVBD6EXAMPLE",
+			DistributionDomainName: "d111111abcdef8.cloudfront.net",
+			EventType:              "origin-response",
+			RequestID:              "4TyzHTaYWb1GX1qTfsHhEqV6HUDd_BzoBZnwfnvQc_1oF26ClkoUSEQ==",
+		},
+		logEntry.Metadata.CloudFront,
+	)
+}
+
+func TestGetCloudFrontLogEntryEventResponse(t *testing.T) {
+	testRecord := Record{
+		Event:       json.RawMessage(testCloudFrontOriginResponseEvent),
+		RawResponse: json.RawMessage(`null`),
+	}
+
+	logEntry, ok := testRecord.getCloudFrontLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, int64(200), logEntry.Response.StatusCode)
+	assert.Equal(t, []string{"image/jpeg"}, logEntry.Response.Headers["content-type"])
+}
+
+func TestGetCloudFrontLogEntryGeneratedResponse(t *testing.T) {
+	testRecord := Record{
+		Event:       json.RawMessage(`{"Records":[{"cf":{"config":{"distributionDomainName":"d111111abcdef8.cloudfront.net","eventType":"viewer-request"},"request":{"clientIp":"203.0.113.178","headers":{},"method":"POST","uri":"/login","body":{"data":"dXNlcj1hZG1pbg==","encoding":"base64"}}}}]}`),
+		RawResponse: json.RawMessage(`{"status":"200","headers":{"content-type":[{"key":"Content-Type","value":"application/json"}]},"body":"eyJvayI6dHJ1ZX0=","bodyEncoding":"base64"}`),
+	}
+
+	logEntry, ok := testRecord.getCloudFrontLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, "https://d111111abcdef8.cloudfront.net/login", logEntry.Request.URI)
+	assert.Equal(t, "user=admin", logEntry.Request.Body)
+	assert.False(t, logEntry.Request.IsBase64Encoded)
+	assert.Equal(t, int64(200), logEntry.Response.StatusCode)
+	assert.Equal(t, `{"ok":true}`, logEntry.Response.Body)
+	assert.False(t, logEntry.Response.IsBase64Encoded)
+}
+
+func TestGetCloudFrontLogEntryBinaryRequestBody(t *testing.T) {
+	testRecord := Record{
+		Event: json.RawMessage(`{"Records":[{"cf":{"config":{"eventType":"origin-request"},"request":{"clientIp":"203.0.113.178","headers":{"content-type":[{"key":"Content-Type","value":"image/png"}]},"method":"PUT","uri":"/avatar","body":{"data":"iVBORw0KGgo=","encoding":"base64"}}}}]}`),
+	}
+
+	logEntry, ok := testRecord.getCloudFrontLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, "iVBORw0KGgo=", logEntry.Request.Body)
+	assert.True(t, logEntry.Request.IsBase64Encoded)
+}
+
+func TestGetCloudFrontLogEntryFunctionReturnedRequest(t *testing.T) {
+	testRecord := Record{
+		Event:       json.RawMessage(`{"Records":[{"cf":{"config":{"eventType":"viewer-request"},"request":{"clientIp":"203.0.113.178","headers":{"host":[{"key":"Host","value":"www.example.com"}]},"method":"GET","uri":"/"}}}]}`),
+		RawResponse: json.RawMessage(`{"clientIp":"203.0.113.178","headers":{"host":[{"key":"Host","value":"www.example.com"}]},"method":"GET","uri":"/index.html"}`),
+	}
+
+	logEntry, ok := testRecord.getCloudFrontLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, "/", logEntry.Request.Resource)
+	assert.Equal(t, LogEntryResponse{Headers: map[string][]string{}}, logEntry.Response)
+}
+
+func TestGetCloudFrontLogEntryNotCloudFront(t *testing.T) {
+	apiGatewayProxyRequestBytes, err := json.Marshal(getNewAPIGatewayProxyRequest())
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayProxyRequestBytes)}
+
+	logEntry, ok := testRecord.getCloudFrontLogEntry()
+	assert.False(t, ok)
+	assert.Nil(t, logEntry)
+}
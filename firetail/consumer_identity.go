@@ -0,0 +1,159 @@
+package firetail
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The formats API Gateway may give the validity dates of client certificates in, e.g. "May 28 12:30:02 2019 GMT"
+var clientCertTimeLayouts = []string{"Jan _2 15:04:05 2006 MST", time.RFC3339}
+
+// ConsumerIdentification configures how the consumers of an API, identified by the API key or mTLS client certificate they present, are
+// logged. Unlike the identifiers of callers, API key IDs & certificate subjects and serial numbers are hashed unless configured otherwise.
+type ConsumerIdentification struct {
+	Hashing           IdentifierHashing // How API key IDs, certificate subjects & certificate serial numbers are hashed before they're logged
+	CertExpiryWarning time.Duration     // Client certificates which expire within this duration of the request are flagged as expiring soon
+}
+
+// DefaultConsumerIdentification is the ConsumerIdentification used for all log entries
+var DefaultConsumerIdentification = &ConsumerIdentification{
+	Hashing:           IdentifierHashing{Algorithm: IdentifierHashingSHA256},
+	CertExpiryWarning: 30 * 24 * time.Hour,
+}
+
+// LoadEnvVars configures the ConsumerIdentification from the FIRETAIL_CONSUMER_HASHING, FIRETAIL_CONSUMER_HASH_KEY &
+// FIRETAIL_CLIENT_CERT_EXPIRY_WARNING env vars
+func (c *ConsumerIdentification) LoadEnvVars() error {
+	if err := c.Hashing.loadEnvVars("FIRETAIL_CONSUMER_HASHING", "FIRETAIL_CONSUMER_HASH_KEY"); err != nil {
+		return err
+	}
+	if expiryWarningStr, isSet := os.LookupEnv("FIRETAIL_CLIENT_CERT_EXPIRY_WARNING"); isSet {
+		expiryWarning, err := time.ParseDuration(expiryWarningStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_CLIENT_CERT_EXPIRY_WARNING invalid")
+		}
+		if expiryWarning < 0 {
+			return errors.Errorf("FIRETAIL_CLIENT_CERT_EXPIRY_WARNING is %s but must be >= 0", expiryWarningStr)
+		}
+		c.CertExpiryWarning = expiryWarning
+	}
+	return nil
+}
+
+// apiGatewayClientCert is the client certificate API Gateway gives in the request context of both payload formats when mutual TLS is
+// enabled. The v1 payload format's identity isn't given a client certificate by the events package, so both are unmarshalled into this.
+type apiGatewayClientCert struct {
+	SubjectDN    string `json:"subjectDN"`
+	IssuerDN     string `json:"issuerDN"`
+	SerialNumber string `json:"serialNumber"`
+	Validity     struct {
+		NotBefore string `json:"notBefore"`
+		NotAfter  string `json:"notAfter"`
+	} `json:"validity"`
+}
+
+// apiGatewayV1ClientCertEvent is the part of an API Gateway v1 event holding the client certificate
+type apiGatewayV1ClientCertEvent struct {
+	RequestContext struct {
+		Identity struct {
+			ClientCert *apiGatewayClientCert `json:"clientCert"`
+		} `json:"identity"`
+	} `json:"requestContext"`
+}
+
+// apiGatewayV2ClientCertEvent is the part of an API Gateway v2 event holding the client certificate
+type apiGatewayV2ClientCertEvent struct {
+	RequestContext struct {
+		Authentication *struct {
+			ClientCert *apiGatewayClientCert `json:"clientCert"`
+		} `json:"authentication"`
+	} `json:"requestContext"`
+}
+
+// getAPIGatewayV1ClientCert returns the client certificate in an API Gateway v1 event, or nil if it doesn't have one
+func getAPIGatewayV1ClientCert(event json.RawMessage) *apiGatewayClientCert {
+	var clientCertEvent apiGatewayV1ClientCertEvent
+	if err := json.Unmarshal(event, &clientCertEvent); err != nil {
+		return nil
+	}
+	return clientCertEvent.RequestContext.Identity.ClientCert
+}
+
+// getAPIGatewayV2ClientCert returns the client certificate in an API Gateway v2 event, or nil if it doesn't have one
+func getAPIGatewayV2ClientCert(event json.RawMessage) *apiGatewayClientCert {
+	var clientCertEvent apiGatewayV2ClientCertEvent
+	if err := json.Unmarshal(event, &clientCertEvent); err != nil || clientCertEvent.RequestContext.Authentication == nil {
+		return nil
+	}
+	return clientCertEvent.RequestContext.Authentication.ClientCert
+}
+
+// getConsumerIdentity returns the identity of the consumer presenting an API key and/or client certificate, or nil if neither were
+// presented. The PEM of the certificate is never used.
+func getConsumerIdentity(apiKeyID string, clientCert *apiGatewayClientCert) *LogEntryConsumerMetadata {
+	if clientCert != nil && clientCert.SubjectDN == "" && clientCert.SerialNumber == "" {
+		clientCert = nil
+	}
+	if apiKeyID == "" && clientCert == nil {
+		return nil
+	}
+
+	consumerIdentity := &LogEntryConsumerMetadata{APIKeyID: apiKeyID}
+	if clientCert != nil {
+		consumerIdentity.ClientCert = &LogEntryClientCertMetadata{
+			SubjectDN:    clientCert.SubjectDN,
+			IssuerDN:     clientCert.IssuerDN,
+			SerialNumber: clientCert.SerialNumber,
+			NotBefore:    parseClientCertTime(clientCert.Validity.NotBefore),
+			NotAfter:     parseClientCertTime(clientCert.Validity.NotAfter),
+		}
+	}
+	return consumerIdentity
+}
+
+// parseClientCertTime returns a client certificate validity date in UNIX milliseconds, or 0 if it can't be parsed
+func parseClientCertTime(clientCertTime string) int64 {
+	for _, layout := range clientCertTimeLayouts {
+		if parsedTime, err := time.Parse(layout, clientCertTime); err == nil {
+			return parsedTime.UnixMilli()
+		}
+	}
+	return 0
+}
+
+// inspectConsumer hashes the identifiers of the log entry's consumer & caller API key ID, and the API keys in the request's x-api-key
+// header, and flags client certificates which expire within the CertExpiryWarning of the request
+func (c *ConsumerIdentification) inspectConsumer(logEntry *LogEntry) {
+	for i, apiKey := range logEntry.Request.Headers["x-api-key"] {
+		logEntry.Request.Headers["x-api-key"][i] = c.hashAPIKey(apiKey)
+	}
+	if logEntry.Metadata.Caller != nil {
+		logEntry.Metadata.Caller.APIKeyID = c.Hashing.Hash(logEntry.Metadata.Caller.APIKeyID)
+	}
+	consumerIdentity := logEntry.Metadata.Consumer
+	if consumerIdentity == nil {
+		return
+	}
+	consumerIdentity.APIKeyID = c.Hashing.Hash(consumerIdentity.APIKeyID)
+	if clientCert := consumerIdentity.ClientCert; clientCert != nil {
+		clientCert.SubjectDN = c.Hashing.Hash(clientCert.SubjectDN)
+		clientCert.SerialNumber = c.Hashing.Hash(clientCert.SerialNumber)
+		clientCert.ExpiresSoon = clientCert.NotAfter > 0 && logEntry.DateCreated > 0 &&
+			clientCert.NotAfter-logEntry.DateCreated < c.CertExpiryWarning.Milliseconds()
+	}
+}
+
+// hashAPIKey hashes an API key so that it can be logged. Unlike an API key ID, an API key is a secret, so it is masked rather than logged
+// in clear if hashing is disabled.
+func (c *ConsumerIdentification) hashAPIKey(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	if c.Hashing.Algorithm == IdentifierHashingNone {
+		return maskedValue
+	}
+	return c.Hashing.Hash(apiKey)
+}
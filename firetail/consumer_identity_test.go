@@ -0,0 +1,159 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testClientCert = `{
+	"clientCertPem": "-----BEGIN CERTIFICATE-----\nMIIEZTCCAk0CAQEwDQ...\n-----END CERTIFICATE-----",
+	"subjectDN": "CN=client.example.com,O=Example Corp",
+	"issuerDN": "CN=Example Corp Private CA",
+	"serialNumber": "a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1",
+	"validity": {"notBefore": "May 28 12:30:02 2019 GMT", "notAfter": "Aug  5 09:36:04 2021 GMT"}
+}`
+
+func TestGetAPIGatewayV1LogEntryConsumer(t *testing.T) {
+	event := `{
+		"resource": "/pets",
+		"httpMethod": "GET",
+		"requestContext": {
+			"httpMethod": "GET",
+			"requestTimeEpoch": 1626428164000,
+			"identity": {"apiKey": "secret-api-key", "apiKeyId": "a1b2c3d4", "clientCert": ` + testClientCert + `}
+		}
+	}`
+	testRecord := Record{Event: json.RawMessage(event)}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	assert.Equal(t, &LogEntryConsumerMetadata{
+		APIKeyID: "sha256:7dcf407fa84a0e0519c7991154c4148de0244d7589020c0d9842db9efad82094",
+		ClientCert: &LogEntryClientCertMetadata{
+			SubjectDN:    DefaultConsumerIdentification.Hashing.Hash("CN=client.example.com,O=Example Corp"),
+			IssuerDN:     "CN=Example Corp Private CA",
+			SerialNumber: DefaultConsumerIdentification.Hashing.Hash("a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1"),
+			NotBefore:    1559046602000,
+			NotAfter:     1628156164000,
+			ExpiresSoon:  true,
+		},
+	}, logEntry.Metadata.Consumer)
+	assert.Equal(t, "sha256:7dcf407fa84a0e0519c7991154c4148de0244d7589020c0d9842db9efad82094", logEntry.Metadata.Caller.APIKeyID)
+
+	logEntryBytes, err := json.Marshal(logEntry)
+	require.Nil(t, err)
+	assert.NotContains(t, string(logEntryBytes), "secret-api-key")
+	assert.NotContains(t, string(logEntryBytes), "BEGIN CERTIFICATE")
+}
+
+func TestGetLogEntryHashesAPIKeyHeader(t *testing.T) {
+	defaultConsumerIdentification := *DefaultConsumerIdentification
+	defer func() { *DefaultConsumerIdentification = defaultConsumerIdentification }()
+
+	event := `{
+		"resource": "/pets",
+		"httpMethod": "GET",
+		"headers": {"X-Api-Key": "secret-api-key"},
+		"requestContext": {"httpMethod": "GET", "identity": {"apiKey": "secret-api-key", "apiKeyId": "a1b2c3d4"}}
+	}`
+	testRecord := Record{Event: json.RawMessage(event)}
+
+	testCases := []struct {
+		algorithm      string
+		expectedAPIKey string
+	}{
+		{IdentifierHashingSHA256, "sha256:61372661cf51fbc346920c1886f5cf76d5acd0e1b223b555e357c461bea4d5f9"},
+		{IdentifierHashingNone, maskedValue},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.algorithm, func(t *testing.T) {
+			DefaultConsumerIdentification.Hashing.Algorithm = testCase.algorithm
+
+			logEntry, err := testRecord.getLogEntry()
+			require.Nil(t, err)
+			assert.Equal(t, []string{testCase.expectedAPIKey}, logEntry.Request.Headers["x-api-key"])
+
+			logEntryBytes, err := json.Marshal(logEntry)
+			require.Nil(t, err)
+			assert.NotContains(t, string(logEntryBytes), "secret-api-key")
+		})
+	}
+}
+
+func TestGetAPIGatewayV2LogEntryConsumer(t *testing.T) {
+	defaultConsumerIdentification := *DefaultConsumerIdentification
+	defer func() { *DefaultConsumerIdentification = defaultConsumerIdentification }()
+	DefaultConsumerIdentification.Hashing.Algorithm = IdentifierHashingNone
+
+	event := `{
+		"version": "2.0",
+		"routeKey": "GET /pets",
+		"requestContext": {
+			"timeEpoch": 1559046602000,
+			"http": {"method": "GET", "path": "/pets"},
+			"authentication": {"clientCert": ` + testClientCert + `}
+		}
+	}`
+	testRecord := Record{Event: json.RawMessage(event)}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	assert.Equal(t, &LogEntryConsumerMetadata{
+		ClientCert: &LogEntryClientCertMetadata{
+			SubjectDN:    "CN=client.example.com,O=Example Corp",
+			IssuerDN:     "CN=Example Corp Private CA",
+			SerialNumber: "a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1:a1",
+			NotBefore:    1559046602000,
+			NotAfter:     1628156164000,
+			ExpiresSoon:  false,
+		},
+	}, logEntry.Metadata.Consumer)
+}
+
+func TestGetConsumerIdentityNone(t *testing.T) {
+	assert.Nil(t, getConsumerIdentity("", nil))
+	assert.Nil(t, getConsumerIdentity("", &apiGatewayClientCert{}))
+
+	apiGatewayProxyRequest := getNewAPIGatewayProxyRequest()
+	apiGatewayProxyRequest.RequestContext.Identity = events.APIGatewayRequestIdentity{SourceIP: "192.0.2.1"}
+	apiGatewayProxyRequestBytes, err := json.Marshal(apiGatewayProxyRequest)
+	require.Nil(t, err)
+
+	logEntry, err := (&Record{Event: json.RawMessage(apiGatewayProxyRequestBytes)}).getLogEntry()
+	require.Nil(t, err)
+	assert.Nil(t, logEntry.Metadata.Consumer)
+}
+
+func TestParseClientCertTime(t *testing.T) {
+	assert.Equal(t, int64(1628156164000), parseClientCertTime("Aug  5 09:36:04 2021 GMT"))
+	assert.Equal(t, int64(1628156164000), parseClientCertTime("2021-08-05T09:36:04Z"))
+	assert.Equal(t, int64(0), parseClientCertTime(""))
+	assert.Equal(t, int64(0), parseClientCertTime("not a date"))
+}
+
+func TestConsumerIdentificationLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_CONSUMER_HASHING", "hmac-sha256")
+	t.Setenv("FIRETAIL_CONSUMER_HASH_KEY", "key")
+	t.Setenv("FIRETAIL_CLIENT_CERT_EXPIRY_WARNING", "168h")
+	consumerIdentification := &ConsumerIdentification{Hashing: IdentifierHashing{Algorithm: IdentifierHashingSHA256}}
+	require.Nil(t, consumerIdentification.LoadEnvVars())
+	assert.Equal(t, &ConsumerIdentification{
+		Hashing:           IdentifierHashing{Algorithm: IdentifierHashingHMACSHA256, Key: []byte("key")},
+		CertExpiryWarning: 7 * 24 * time.Hour,
+	}, consumerIdentification)
+
+	t.Setenv("FIRETAIL_CLIENT_CERT_EXPIRY_WARNING", "-1h")
+	err := (&ConsumerIdentification{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_CLIENT_CERT_EXPIRY_WARNING is -1h but must be >= 0", err.Error())
+
+	t.Setenv("FIRETAIL_CONSUMER_HASHING", "md5")
+	err = (&ConsumerIdentification{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_CONSUMER_HASHING is md5 but must be one of none, sha256 or hmac-sha256", err.Error())
+}
@@ -0,0 +1,205 @@
+package firetail
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// eventBridgeEventSource is the event source used for EventBridge events, which don't state their own event source
+const eventBridgeEventSource = "aws:events"
+
+// eventSourceProbe is used to detect the source of events from non-HTTP event sources. SQS, Kinesis, DynamoDB Streams & S3 state their
+// source in the eventSource field of each of their records, SNS in the EventSource field, and Kafka & MQ in a top-level eventSource field.
+// EventBridge events don't state their source, but always have a source and detail-type.
+type eventSourceProbe struct {
+	EventSource    string          `json:"eventSource"`
+	EventSourceARN string          `json:"eventSourceArn"`
+	Records        json.RawMessage `json:"Records"`
+	Source         string          `json:"source"`
+	DetailType     string          `json:"detail-type"`
+}
+
+// batchResponse is the response functions triggered by SQS, Kinesis or DynamoDB Streams with ReportBatchItemFailures enabled return to
+// report which items in the batch failed. Its shape is the same for all three, see events.SQSEventResponse.
+type batchResponse struct {
+	BatchItemFailures *[]struct {
+		ItemIdentifier *string `json:"itemIdentifier"`
+	} `json:"batchItemFailures"`
+}
+
+// batchResponseEventSources are the event sources whose functions may return a batchResponse
+var batchResponseEventSources = map[string]bool{
+	"aws:sqs":      true,
+	"aws:kinesis":  true,
+	"aws:dynamodb": true,
+}
+
+// summarisedEventSources are the event sources getEventMetadata summarises the events of
+var summarisedEventSources = map[string]bool{
+	"aws:sqs":              true,
+	"aws:sns":              true,
+	"aws:kinesis":          true,
+	"aws:dynamodb":         true,
+	"aws:s3":               true,
+	eventBridgeEventSource: true,
+}
+
+type eventSourceProbeRecord struct {
+	EventSource    string `json:"eventSource"`
+	SNSEventSource string `json:"EventSource"`
+}
+
+// getEventSource returns the event source of the event, e.g. aws:sqs, or the empty string if it is not from a known non-HTTP event source
+func (p *eventSourceProbe) getEventSource() string {
+	var records []eventSourceProbeRecord
+	if err := json.Unmarshal(p.Records, &records); err == nil && len(records) > 0 {
+		if records[0].EventSource != "" {
+			return records[0].EventSource
+		}
+		return records[0].SNSEventSource
+	}
+	if p.EventSource != "" {
+		return p.EventSource
+	}
+	if p.Source != "" && p.DetailType != "" {
+		return eventBridgeEventSource
+	}
+	return ""
+}
+
+// getEventInvocationLogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is from a
+// non-HTTP event source such as SQS, SNS, EventBridge, Kinesis, DynamoDB Streams or S3. Otherwise, it returns nil and false.
+//
+// These invocations aren't HTTP requests, so the log entry's request is the invocation itself: a POST, like a call to Lambda's Invoke API,
+// to the ARN of the event source as its URI with the event source as its resource. The response is the result the function returned. The
+// event itself isn't logged, as it can be large & is likely to contain personal data, but a summary of it is included in the log entry's
+// metadata. As these events don't state when the function was invoked, the log entry's DateCreated is left for getLogEntry to fill in
+// with the time the record was captured.
+func (r *Record) getEventInvocationLogEntry() (*LogEntry, bool) {
+	var probe eventSourceProbe
+	if err := json.Unmarshal(r.Event, &probe); err != nil {
+		return nil, false
+	}
+	eventSource := probe.getEventSource()
+	if eventSource == "" {
+		return nil, false
+	}
+
+	eventMetadata := r.getEventMetadata(eventSource)
+	if eventMetadata.SourceARN == "" {
+		eventMetadata.SourceARN = probe.EventSourceARN
+	}
+
+	logEntry := r.newLogEntry(
+		LogEntryRequest{
+			Headers:  map[string][]string{},
+			Method:   Post,
+			URI:      eventMetadata.SourceARN,
+			Resource: eventSource,
+		},
+		0,
+	)
+	logEntry.Response = LogEntryResponse{
+		Body:       string(r.RawResponse),
+		Headers:    map[string][]string{},
+		StatusCode: 200,
+	}
+	logEntry.Metadata.Event = eventMetadata
+
+	return logEntry, true
+}
+
+// getEventMetadata returns a summary of the Record's Event value for the given event source: the ARN of the resource that is the source
+// of the event, the number of records in the event, and the IDs of the messages in the event. For Kinesis & DynamoDB Streams the IDs are
+// sequence numbers, as those are what the function must use to report batch item failures.
+func (r *Record) getEventMetadata(eventSource string) *LogEntryEventMetadata {
+	eventMetadata := &LogEntryEventMetadata{Source: eventSource, MessageIDs: []string{}}
+
+	switch eventSource {
+	case "aws:sqs":
+		var sqsEvent events.SQSEvent
+		if json.Unmarshal(r.Event, &sqsEvent) == nil {
+			for _, record := range sqsEvent.Records {
+				eventMetadata.SourceARN = record.EventSourceARN
+				eventMetadata.MessageIDs = append(eventMetadata.MessageIDs, record.MessageId)
+				if receiveCount, err := strconv.Atoi(record.Attributes["ApproximateReceiveCount"]); err == nil {
+					if eventMetadata.ReceiveCounts == nil {
+						eventMetadata.ReceiveCounts = map[string]int{}
+					}
+					eventMetadata.ReceiveCounts[record.MessageId] = receiveCount
+				}
+			}
+		}
+	case "aws:sns":
+		var snsEvent events.SNSEvent
+		if json.Unmarshal(r.Event, &snsEvent) == nil {
+			for _, record := range snsEvent.Records {
+				eventMetadata.SourceARN = record.SNS.TopicArn
+				eventMetadata.MessageIDs = append(eventMetadata.MessageIDs, record.SNS.MessageID)
+			}
+		}
+	case "aws:kinesis":
+		var kinesisEvent events.KinesisEvent
+		if json.Unmarshal(r.Event, &kinesisEvent) == nil {
+			for _, record := range kinesisEvent.Records {
+				eventMetadata.SourceARN = record.EventSourceArn
+				eventMetadata.MessageIDs = append(eventMetadata.MessageIDs, record.Kinesis.SequenceNumber)
+			}
+		}
+	case "aws:dynamodb":
+		var dynamoDBEvent events.DynamoDBEvent
+		if json.Unmarshal(r.Event, &dynamoDBEvent) == nil {
+			for _, record := range dynamoDBEvent.Records {
+				eventMetadata.SourceARN = record.EventSourceArn
+				eventMetadata.MessageIDs = append(eventMetadata.MessageIDs, record.Change.SequenceNumber)
+			}
+		}
+	case "aws:s3":
+		var s3Event events.S3Event
+		if json.Unmarshal(r.Event, &s3Event) == nil {
+			for _, record := range s3Event.Records {
+				eventMetadata.SourceARN = record.S3.Bucket.Arn
+				eventMetadata.MessageIDs = append(eventMetadata.MessageIDs, record.S3.Object.Key)
+			}
+		}
+	case eventBridgeEventSource:
+		var eventBridgeEvent events.CloudWatchEvent
+		if json.Unmarshal(r.Event, &eventBridgeEvent) == nil {
+			if len(eventBridgeEvent.Resources) > 0 {
+				eventMetadata.SourceARN = eventBridgeEvent.Resources[0]
+			}
+			eventMetadata.DetailType = eventBridgeEvent.DetailType
+			eventMetadata.MessageIDs = append(eventMetadata.MessageIDs, eventBridgeEvent.ID)
+		}
+	}
+
+	eventMetadata.RecordCount = len(eventMetadata.MessageIDs)
+
+	if batchResponseEventSources[eventSource] {
+		r.addBatchItemFailures(eventMetadata)
+	}
+
+	return eventMetadata
+}
+
+// addBatchItemFailures adds the failed items the function reported in its batch response, if it returned one, to the event metadata.
+// Lambda treats a failure with a missing or empty item identifier as a failure of the whole batch, so in that case every message in the
+// event is marked as failed.
+func (r *Record) addBatchItemFailures(eventMetadata *LogEntryEventMetadata) {
+	var batchResponse batchResponse
+	if err := json.Unmarshal(r.RawResponse, &batchResponse); err != nil || batchResponse.BatchItemFailures == nil {
+		return
+	}
+
+	eventMetadata.ReportsBatchItemFailures = true
+	eventMetadata.FailedMessageIDs = []string{}
+	for _, batchItemFailure := range *batchResponse.BatchItemFailures {
+		if batchItemFailure.ItemIdentifier == nil || *batchItemFailure.ItemIdentifier == "" {
+			eventMetadata.FailedMessageIDs = append([]string{}, eventMetadata.MessageIDs...)
+			return
+		}
+		eventMetadata.FailedMessageIDs = append(eventMetadata.FailedMessageIDs, *batchItemFailure.ItemIdentifier)
+	}
+}
@@ -0,0 +1,165 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEventInvocationLogEntry(t *testing.T) {
+	testCases := []struct {
+		name             string
+		event            string
+		expectedMetadata LogEntryEventMetadata
+	}{
+		{
+			"SQS",
+			`{"Records":[{"messageId":"059f36b4-87a3-44ab-83d2-661975830a7d","body":"Test message.","eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-2:123456789012:my-queue"},{"messageId":"2e1424d4-f796-459a-8184-9c92662be6da","body":"Test message.","eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-2:123456789012:my-queue"}]}`,
+			LogEntryEventMetadata{Source: "aws:sqs", SourceARN: "arn:aws:sqs:us-east-2:123456789012:my-queue", RecordCount: 2, MessageIDs: []string{"059f36b4-87a3-44ab-83d2-661975830a7d", "2e1424d4-f796-459a-8184-9c92662be6da"}},
+		},
+		{
+			"SNS",
+			`{"Records":[{"EventVersion":"1.0","EventSubscriptionArn":"arn:aws:sns:us-east-1:123456789012:sns-lambda:21be56ed-a058-49f5-8c98-aedd2564c486","EventSource":"aws:sns","Sns":{"MessageId":"95df01b4-ee98-5cb9-9903-4c221d41eb5e","TopicArn":"arn:aws:sns:us-east-1:123456789012:sns-lambda","Message":"Hello from SNS!","Timestamp":"2019-01-02T12:45:07.000Z"}}]}`,
+			LogEntryEventMetadata{Source: "aws:sns", SourceARN: "arn:aws:sns:us-east-1:123456789012:sns-lambda", RecordCount: 1, MessageIDs: []string{"95df01b4-ee98-5cb9-9903-4c221d41eb5e"}},
+		},
+		{
+			"Kinesis",
+			`{"Records":[{"kinesis":{"partitionKey":"1","sequenceNumber":"49590338271490256608559692538361571095921575989136588898","data":"SGVsbG8=","approximateArrivalTimestamp":1545084650.987},"eventSource":"aws:kinesis","eventID":"shardId-000000000006:49590338271490256608559692538361571095921575989136588898","eventSourceARN":"arn:aws:kinesis:us-east-2:123456789012:stream/lambda-stream"}]}`,
+			LogEntryEventMetadata{Source: "aws:kinesis", SourceARN: "arn:aws:kinesis:us-east-2:123456789012:stream/lambda-stream", RecordCount: 1, MessageIDs: []string{"49590338271490256608559692538361571095921575989136588898"}},
+		},
+		{
+			"DynamoDB",
+			`{"Records":[{"eventID":"1","eventName":"INSERT","eventSource":"aws:dynamodb","dynamodb":{"Keys":{"Id":{"N":"101"}},"SequenceNumber":"111","SizeBytes":26,"StreamViewType":"NEW_AND_OLD_IMAGES"},"eventSourceARN":"arn:aws:dynamodb:us-east-2:123456789012:table/my-table/stream/2023-06-10T19:26:16.525"}]}`,
+			LogEntryEventMetadata{Source: "aws:dynamodb", SourceARN: "arn:aws:dynamodb:us-east-2:123456789012:table/my-table/stream/2023-06-10T19:26:16.525", RecordCount: 1, MessageIDs: []string{"111"}},
+		},
+		{
+			"S3",
+			`{"Records":[{"eventVersion":"2.0","eventSource":"aws:s3","awsRegion":"us-east-1","eventTime":"1970-01-01T00:00:00.000Z","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"my-bucket","arn":"arn:aws:s3:::my-bucket"},"object":{"key":"HappyFace.jpg","size":1024}}}]}`,
+			LogEntryEventMetadata{Source: "aws:s3", SourceARN: "arn:aws:s3:::my-bucket", RecordCount: 1, MessageIDs: []string{"HappyFace.jpg"}},
+		},
+		{
+			"EventBridge",
+			`{"version":"0","id":"6a7e8feb-b491-4cf7-a9f1-bf3703467718","detail-type":"EC2 Instance State-change Notification","source":"aws.ec2","account":"111122223333","time":"2017-12-22T18:43:48Z","region":"us-west-1","resources":["arn:aws:ec2:us-west-1:123456789012:instance/i-1234567890abcdef0"],"detail":{"instance-id":"i-1234567890abcdef0","state":"terminated"}}`,
+			LogEntryEventMetadata{Source: "aws:events", SourceARN: "arn:aws:ec2:us-west-1:123456789012:instance/i-1234567890abcdef0", DetailType: "EC2 Instance State-change Notification", RecordCount: 1, MessageIDs: []string{"6a7e8feb-b491-4cf7-a9f1-bf3703467718"}},
+		},
+		{
+			"Kafka",
+			`{"eventSource":"aws:kafka","eventSourceArn":"arn:aws:kafka:us-east-1:123456789012:cluster/vpc-2priv-2pub/751d2973-a626-431c-9d4e-d7975eb44dd7-2","records":{"mytopic-0":[{"topic":"mytopic","partition":0,"offset":15}]}}`,
+			LogEntryEventMetadata{Source: "aws:kafka", SourceARN: "arn:aws:kafka:us-east-1:123456789012:cluster/vpc-2priv-2pub/751d2973-a626-431c-9d4e-d7975eb44dd7-2", RecordCount: 0, MessageIDs: []string{}},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testRecord := Record{
+				Event:         json.RawMessage(testCase.event),
+				RawResponse:   json.RawMessage(`"done"`),
+				ExecutionTime: 50,
+			}
+
+			logEntry, ok := testRecord.getEventInvocationLogEntry()
+			require.True(t, ok)
+
+			assert.Equal(t, testCase.expectedMetadata, *logEntry.Metadata.Event)
+			assert.Equal(t, Post, logEntry.Request.Method)
+			assert.Empty(t, logEntry.Request.Body)
+			assert.Equal(t, testCase.expectedMetadata.SourceARN, logEntry.Request.URI)
+			assert.Equal(t, testCase.expectedMetadata.Source, logEntry.Request.Resource)
+			assert.Equal(t, int64(200), logEntry.Response.StatusCode)
+			assert.Equal(t, `"done"`, logEntry.Response.Body)
+			assert.Equal(t, float64(50), logEntry.ExecutionTime)
+		})
+	}
+}
+
+func TestGetEventInvocationLogEntryNotEventInvocation(t *testing.T) {
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(getNewAPIGatewayV2HTTPRequest())
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes)}
+
+	logEntry, ok := testRecord.getEventInvocationLogEntry()
+	assert.False(t, ok)
+	assert.Nil(t, logEntry)
+}
+
+func TestGetLogEntryEventInvocationUsesCapturedAt(t *testing.T) {
+	testRecord := Record{
+		Event:      json.RawMessage(`{"Records":[{"messageId":"059f36b4-87a3-44ab-83d2-661975830a7d","eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-2:123456789012:my-queue"}]}`),
+		CapturedAt: 1668685315222,
+	}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	assert.Equal(t, int64(1668685315222), logEntry.DateCreated)
+	require.NotNil(t, logEntry.Metadata.Event)
+	assert.Equal(t, "aws:sqs", logEntry.Metadata.Event.Source)
+}
+
+func TestGetLogEntryPrefersRequestTimeToCapturedAt(t *testing.T) {
+	apiGatewayProxyRequestBytes, err := json.Marshal(getNewAPIGatewayProxyRequest())
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayProxyRequestBytes), CapturedAt: 1}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	assert.Equal(t, int64(1668685315222), logEntry.DateCreated)
+	assert.Nil(t, logEntry.Metadata.Event)
+}
+
+func TestGetEventInvocationLogEntryBatchItemFailures(t *testing.T) {
+	const sqsEvent = `{"Records":[{"messageId":"059f36b4-87a3-44ab-83d2-661975830a7d","attributes":{"ApproximateReceiveCount":"1"},"eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-2:123456789012:my-queue"},{"messageId":"2e1424d4-f796-459a-8184-9c92662be6da","attributes":{"ApproximateReceiveCount":"3"},"eventSource":"aws:sqs","eventSourceARN":"arn:aws:sqs:us-east-2:123456789012:my-queue"}]}`
+	const kinesisEvent = `{"Records":[{"kinesis":{"sequenceNumber":"4959033827149025660855969253836157109592157598913658889"},"eventSource":"aws:kinesis","eventSourceARN":"arn:aws:kinesis:us-east-2:123456789012:stream/lambda-stream"},{"kinesis":{"sequenceNumber":"4959033827149025660855969253836157109592157598913658890"},"eventSource":"aws:kinesis","eventSourceARN":"arn:aws:kinesis:us-east-2:123456789012:stream/lambda-stream"}]}`
+
+	testCases := []struct {
+		name                             string
+		event                            string
+		response                         string
+		expectedReportsBatchItemFailures bool
+		expectedFailedMessageIDs         []string
+	}{
+		{"SQSPartialFailure", sqsEvent, `{"batchItemFailures":[{"itemIdentifier":"2e1424d4-f796-459a-8184-9c92662be6da"}]}`, true, []string{"2e1424d4-f796-459a-8184-9c92662be6da"}},
+		{"SQSNoFailures", sqsEvent, `{"batchItemFailures":[]}`, true, []string{}},
+		{"SQSEmptyItemIdentifier", sqsEvent, `{"batchItemFailures":[{"itemIdentifier":""}]}`, true, []string{"059f36b4-87a3-44ab-83d2-661975830a7d", "2e1424d4-f796-459a-8184-9c92662be6da"}},
+		{"SQSNullItemIdentifier", sqsEvent, `{"batchItemFailures":[{"itemIdentifier":null}]}`, true, []string{"059f36b4-87a3-44ab-83d2-661975830a7d", "2e1424d4-f796-459a-8184-9c92662be6da"}},
+		{"SQSNoBatchResponse", sqsEvent, `null`, false, nil},
+		{"KinesisPartialFailure", kinesisEvent, `{"batchItemFailures":[{"itemIdentifier":"4959033827149025660855969253836157109592157598913658890"}]}`, true, []string{"4959033827149025660855969253836157109592157598913658890"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testRecord := Record{Event: json.RawMessage(testCase.event), RawResponse: json.RawMessage(testCase.response)}
+
+			logEntry, ok := testRecord.getEventInvocationLogEntry()
+			require.True(t, ok)
+
+			assert.Equal(t, testCase.expectedReportsBatchItemFailures, logEntry.Metadata.Event.ReportsBatchItemFailures)
+			assert.Equal(t, testCase.expectedFailedMessageIDs, logEntry.Metadata.Event.FailedMessageIDs)
+		})
+	}
+}
+
+func TestGetEventInvocationLogEntrySQSReceiveCounts(t *testing.T) {
+	testRecord := Record{
+		Event: json.RawMessage(`{"Records":[{"messageId":"059f36b4-87a3-44ab-83d2-661975830a7d","attributes":{"ApproximateReceiveCount":"1"},"eventSource":"aws:sqs"},{"messageId":"2e1424d4-f796-459a-8184-9c92662be6da","attributes":{"ApproximateReceiveCount":"3"},"eventSource":"aws:sqs"}]}`),
+	}
+
+	logEntry, ok := testRecord.getEventInvocationLogEntry()
+	require.True(t, ok)
+
+	assert.Equal(t, map[string]int{"059f36b4-87a3-44ab-83d2-661975830a7d": 1, "2e1424d4-f796-459a-8184-9c92662be6da": 3}, logEntry.Metadata.Event.ReceiveCounts)
+}
+
+func TestGetEventInvocationLogEntryIgnoresBatchResponseForOtherSources(t *testing.T) {
+	testRecord := Record{
+		Event:       json.RawMessage(`{"Records":[{"EventSource":"aws:sns","Sns":{"MessageId":"95df01b4-ee98-5cb9-9903-4c221d41eb5e","TopicArn":"arn:aws:sns:us-east-1:123456789012:sns-lambda"}}]}`),
+		RawResponse: json.RawMessage(`{"batchItemFailures":[{"itemIdentifier":"95df01b4-ee98-5cb9-9903-4c221d41eb5e"}]}`),
+	}
+
+	logEntry, ok := testRecord.getEventInvocationLogEntry()
+	require.True(t, ok)
+
+	assert.False(t, logEntry.Metadata.Event.ReportsBatchItemFailures)
+	assert.Nil(t, logEntry.Metadata.Event.FailedMessageIDs)
+}
@@ -0,0 +1,345 @@
+package firetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// EventMapper maps the events of an event source into Firetail SaaS LogEntries
+type EventMapper interface {
+	// Name returns a name for the mapper which is unique within an EventMapperRegistry
+	Name() string
+	// Detect returns how confident the mapper is that the Event is from its event source, from 0 (not at all) to 1 (certain). Every mapper
+	// is asked to detect every event, so Detect should only look at the fields which discriminate its event source from others, and
+	// leave unmarshalling the event in full to Map.
+	Detect(event *Event) float64
+	// Map returns a Firetail SaaS LogEntry for the Event, or an err if it can't be mapped after all
+	Map(event *Event) (*LogEntry, error)
+}
+
+// Event is the event of a Record as it's presented to EventMappers. Its top-level fields are unmarshalled once & shared by every mapper,
+// and the event is only unmarshalled in full, into a generic value, if a mapper asks for it, at most once.
+type Event struct {
+	Record *Record
+
+	fields       map[string]json.RawMessage
+	value        interface{}
+	valueErr     error
+	unmarshalled bool
+}
+
+// NewEvent returns the Event of the Record. If the Record's Event value isn't a JSON object, the Event has no fields.
+func NewEvent(record *Record) *Event {
+	event := &Event{Record: record}
+	if err := json.Unmarshal(record.Event, &event.fields); err != nil {
+		event.fields = nil
+	}
+	return event
+}
+
+// Has returns true if the event has a top-level field with the provided name which isn't null
+func (e *Event) Has(fieldName string) bool {
+	field, ok := e.fields[fieldName]
+	return ok && string(field) != "null"
+}
+
+// Field returns the raw value of the event's top-level field with the provided name, or nil if it has no such field
+func (e *Event) Field(fieldName string) json.RawMessage {
+	return e.fields[fieldName]
+}
+
+// String returns the value of the event's top-level field with the provided name if it is a string, or otherwise the empty string
+func (e *Event) String(fieldName string) string {
+	var value string
+	if field, ok := e.fields[fieldName]; !ok || json.Unmarshal(field, &value) != nil {
+		return ""
+	}
+	return value
+}
+
+// Value returns the whole event unmarshalled into a generic value
+func (e *Event) Value() (interface{}, error) {
+	if !e.unmarshalled {
+		e.valueErr = json.Unmarshal(e.Record.Event, &e.value)
+		e.unmarshalled = true
+	}
+	return e.value, e.valueErr
+}
+
+// EventMapperRegistry holds an ordered list of EventMappers, and maps each Record with the mapper most confident it can map it. If several
+// mappers are equally confident, the one registered last is used, so mappers registered after the built-in mappers take precedence over
+// them.
+type EventMapperRegistry struct {
+	mutex   sync.RWMutex
+	mappers []EventMapper
+}
+
+// DefaultEventMapperRegistry is the registry used to map all Records into LogEntries. It starts out with the built-in mappers registered.
+var DefaultEventMapperRegistry = NewEventMapperRegistry(getBuiltInEventMappers()...)
+
+// NewEventMapperRegistry returns an EventMapperRegistry with the provided mappers registered in order
+func NewEventMapperRegistry(mappers ...EventMapper) *EventMapperRegistry {
+	registry := &EventMapperRegistry{}
+	registry.Register(mappers...)
+	return registry
+}
+
+// Register adds mappers to the end of the registry. A mapper replaces any mapper already registered with the same name.
+func (r *EventMapperRegistry) Register(mappers ...EventMapper) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, mapper := range mappers {
+		for i, registeredMapper := range r.mappers {
+			if registeredMapper.Name() == mapper.Name() {
+				r.mappers = append(r.mappers[:i], r.mappers[i+1:]...)
+				break
+			}
+		}
+		r.mappers = append(r.mappers, mapper)
+	}
+}
+
+// Names returns the names of the registered mappers, in the order they were registered
+func (r *EventMapperRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := []string{}
+	for _, mapper := range r.mappers {
+		names = append(names, mapper.Name())
+	}
+	return names
+}
+
+// eventMapperDetection is the confidence an EventMapper detected an Event with
+type eventMapperDetection struct {
+	mapper     EventMapper
+	confidence float64
+}
+
+func (d eventMapperDetection) String() string {
+	return fmt.Sprintf("%s (%g)", d.mapper.Name(), d.confidence)
+}
+
+// detect asks the mappers to detect the Event, from the last registered to the first, until one is certain. It returns the mappers which
+// detected the Event from the most to the least confident, and every detection made, in the order the mappers were asked.
+func (r *EventMapperRegistry) detect(event *Event) ([]eventMapperDetection, []eventMapperDetection) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	detections := []eventMapperDetection{}
+	candidates := []eventMapperDetection{}
+	for i := len(r.mappers) - 1; i >= 0; i-- {
+		detection := eventMapperDetection{r.mappers[i], r.mappers[i].Detect(event)}
+		detections = append(detections, detection)
+		if detection.confidence > 0 {
+			candidates = append(candidates, detection)
+		}
+		if detection.confidence >= 1 {
+			break
+		}
+	}
+	// The candidates are already ordered from the last registered to the first, so a stable sort keeps the last registered first on ties
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].confidence > candidates[j].confidence })
+	return candidates, detections
+}
+
+// Detect returns the mapper most confident it can map the Record, or nil if no mapper has any confidence it can. Mappers are asked from
+// the last registered to the first, and as soon as one is certain no more are asked.
+func (r *EventMapperRegistry) Detect(record *Record) EventMapper {
+	candidates, _ := r.detect(NewEvent(record))
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[0].mapper
+}
+
+// MapLogEntry returns a Firetail SaaS LogEntry for the Record, mapped by the mapper most confident it can map it. If that mapper fails to
+// map it, the next most confident mapper is used, and so on. If no mapper can map the Record, the err states which mappers were tried.
+func (r *EventMapperRegistry) MapLogEntry(record *Record) (*LogEntry, error) {
+	event := NewEvent(record)
+	candidates, detections := r.detect(event)
+
+	var err error
+	for _, candidate := range candidates {
+		logEntry, mapErr := candidate.mapper.Map(event)
+		if mapErr == nil {
+			return logEntry, nil
+		}
+		err = multierror.Append(err, errors.WithMessagef(mapErr, "Event mapper %s failed to map the record's event", candidate))
+	}
+
+	triedMappers := []string{}
+	for _, detection := range detections {
+		triedMappers = append(triedMappers, detection.String())
+	}
+	if err == nil {
+		return nil, errors.Errorf("No event mapper detected the source of the record's event, tried: %s", strings.Join(triedMappers, ", "))
+	}
+	return nil, multierror.Append(err, errors.Errorf("No event mapper could map the record's event, tried: %s", strings.Join(triedMappers, ", ")))
+}
+
+// eventSignature describes the top-level fields which discriminate the events of an event source from other events. An event is only
+// detected if it has all of the Required fields, at least one of the AnyOf fields, and the string Values given. Its confidence then rises
+// from half of the MaxConfidence with each of the Optional fields it has, which the event source's events usually have.
+type eventSignature struct {
+	Required      []string
+	AnyOf         []string
+	Values        map[string][]string // The values the event's string field of each name may have
+	Optional      []string
+	MaxConfidence float64
+}
+
+// score returns the confidence with which the Event matches the signature
+func (s *eventSignature) score(event *Event) float64 {
+	for _, fieldName := range s.Required {
+		if !event.Has(fieldName) {
+			return 0
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		hasAny := false
+		for _, fieldName := range s.AnyOf {
+			hasAny = hasAny || event.Has(fieldName)
+		}
+		if !hasAny {
+			return 0
+		}
+	}
+	for fieldName, values := range s.Values {
+		fieldValue, matches := event.String(fieldName), false
+		for _, value := range values {
+			matches = matches || fieldValue == value
+		}
+		if !matches {
+			return 0
+		}
+	}
+	if len(s.Optional) == 0 {
+		return s.MaxConfidence
+	}
+	optionalFields := 0
+	for _, fieldName := range s.Optional {
+		if event.Has(fieldName) {
+			optionalFields++
+		}
+	}
+	return s.MaxConfidence * (0.5 + 0.5*float64(optionalFields)/float64(len(s.Optional)))
+}
+
+// builtInEventMapper is an EventMapper for an event source supported out of the box. Its events are detected by the detect func, and
+// mapped by a getLogEntry func which returns false if the event isn't from its event source after all.
+type builtInEventMapper struct {
+	name        string
+	detect      func(*Event) float64
+	getLogEntry func(*Record) (*LogEntry, bool)
+}
+
+func (m *builtInEventMapper) Name() string {
+	return m.name
+}
+
+func (m *builtInEventMapper) Detect(event *Event) float64 {
+	return m.detect(event)
+}
+
+func (m *builtInEventMapper) Map(event *Event) (*LogEntry, error) {
+	logEntry, ok := m.getLogEntry(event.Record)
+	if !ok {
+		return nil, errors.Errorf("Event isn't a valid %s event", m.name)
+	}
+	return logEntry, nil
+}
+
+// getBuiltInEventMappers returns the mappers for the event sources the extension supports out of the box, from the least to the most
+// specific. API Gateway events are the least specific, as other event sources' events can look like them, so they are detected with at
+// most 0.9 confidence.
+func getBuiltInEventMappers() []EventMapper {
+	return []EventMapper{
+		&builtInEventMapper{"apigateway-v1", (&eventSignature{
+			Required:      []string{"resource", "httpMethod", "requestContext"},
+			Optional:      []string{"path", "headers", "multiValueHeaders", "queryStringParameters", "pathParameters", "isBase64Encoded"},
+			MaxConfidence: 0.9,
+		}).score, (*Record).getAPIGatewayV1LogEntry},
+		&builtInEventMapper{"apigateway-v2", (&eventSignature{
+			Required:      []string{"routeKey"},
+			Values:        map[string][]string{"version": {"2.0"}},
+			Optional:      []string{"requestContext", "rawPath", "rawQueryString", "headers", "isBase64Encoded"},
+			MaxConfidence: 0.9,
+		}).score, (*Record).getAPIGatewayV2LogEntry},
+		&builtInEventMapper{"event-invocation", detectEventInvocation, (*Record).getEventInvocationLogEntry},
+		&builtInEventMapper{"bedrock-agent", (&eventSignature{
+			Required:      []string{"messageVersion", "agent", "apiPath"},
+			Optional:      []string{"httpMethod", "sessionId", "actionGroup", "inputText"},
+			MaxConfidence: 1,
+		}).score, (*Record).getBedrockAgentLogEntry},
+		&builtInEventMapper{"s3-object-lambda", (&eventSignature{
+			Required:      []string{"configuration", "userRequest"},
+			Optional:      []string{"xAmzRequestId", "userIdentity", "protocolVersion"},
+			MaxConfidence: 1,
+		}).score, (*Record).getS3ObjectLambdaLogEntry},
+		&builtInEventMapper{"cloudfront", detectCloudFront, (*Record).getCloudFrontLogEntry},
+		&builtInEventMapper{"vpc-lattice", detectVPCLattice, (*Record).getVPCLatticeLogEntry},
+		&builtInEventMapper{"apigateway-authorizer", (&eventSignature{
+			AnyOf:         []string{"methodArn", "routeArn"},
+			Values:        map[string][]string{"type": {"TOKEN", "REQUEST"}},
+			MaxConfidence: 1,
+		}).score, (*Record).getAuthorizerLogEntry},
+	}
+}
+
+// detectEventInvocation detects events from the non-HTTP event sources getEventSource recognises. Events from the event sources it
+// summarises are detected with certainty, and events from other event sources with less confidence.
+func detectEventInvocation(event *Event) float64 {
+	probe := eventSourceProbe{
+		EventSource: event.String("eventSource"),
+		Records:     event.Field("Records"),
+		Source:      event.String("source"),
+		DetailType:  event.String("detail-type"),
+	}
+	switch eventSource := probe.getEventSource(); {
+	case eventSource == "":
+		return 0
+	case summarisedEventSources[eventSource]:
+		return 1
+	default:
+		return 0.8
+	}
+}
+
+// detectCloudFront detects Lambda@Edge events, which are a list of Records each with a cf field
+func detectCloudFront(event *Event) float64 {
+	var records []map[string]json.RawMessage
+	if err := json.Unmarshal(event.Field("Records"), &records); err != nil || len(records) == 0 {
+		return 0
+	}
+	if _, ok := records[0]["cf"]; !ok {
+		return 0
+	}
+	return 1
+}
+
+// The signatures of the two event structure versions of VPC Lattice events
+var (
+	vpcLatticeV1Signature = &eventSignature{
+		Required:      []string{"raw_path", "method"},
+		Optional:      []string{"headers", "query_string_parameters", "is_base64_encoded"},
+		MaxConfidence: 1,
+	}
+	vpcLatticeV2Signature = &eventSignature{
+		Required:      []string{"path", "method", "requestContext"},
+		Values:        map[string][]string{"version": {"2.0"}},
+		Optional:      []string{"headers", "queryStringParameters", "isBase64Encoded"},
+		MaxConfidence: 1,
+	}
+)
+
+// detectVPCLattice detects VPC Lattice events of either event structure version
+func detectVPCLattice(event *Event) float64 {
+	return math.Max(vpcLatticeV1Signature.score(event), vpcLatticeV2Signature.score(event))
+}
@@ -0,0 +1,155 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEventMapper struct {
+	name       string
+	confidence float64
+	detected   *int
+}
+
+func (m *testEventMapper) Name() string {
+	return m.name
+}
+
+func (m *testEventMapper) Detect(event *Event) float64 {
+	if m.detected != nil {
+		*m.detected++
+	}
+	return m.confidence
+}
+
+func (m *testEventMapper) Map(event *Event) (*LogEntry, error) {
+	return &LogEntry{Metadata: LogEntryMetadata{Source: m.name}}, nil
+}
+
+func TestEventMapperRegistryPicksMostConfidentMapper(t *testing.T) {
+	registry := NewEventMapperRegistry(
+		&testEventMapper{name: "low", confidence: 0.2},
+		&testEventMapper{name: "high", confidence: 0.8},
+		&testEventMapper{name: "none", confidence: 0},
+	)
+
+	logEntry, err := registry.MapLogEntry(&Record{})
+	require.Nil(t, err)
+	assert.Equal(t, "high", logEntry.Metadata.Source)
+}
+
+func TestEventMapperRegistryPrefersLastRegisteredOnTie(t *testing.T) {
+	registry := NewEventMapperRegistry(
+		&testEventMapper{name: "first", confidence: 0.5},
+		&testEventMapper{name: "second", confidence: 0.5},
+	)
+
+	assert.Equal(t, "second", registry.Detect(&Record{}).Name())
+}
+
+func TestEventMapperRegistryStopsAtCertainMapper(t *testing.T) {
+	detected := 0
+	registry := NewEventMapperRegistry(
+		&testEventMapper{name: "skipped", confidence: 0.5, detected: &detected},
+		&testEventMapper{name: "certain", confidence: 1},
+	)
+
+	assert.Equal(t, "certain", registry.Detect(&Record{}).Name())
+	assert.Equal(t, 0, detected)
+}
+
+func TestEventMapperRegistryRegisterReplacesMapperWithSameName(t *testing.T) {
+	registry := NewEventMapperRegistry(
+		&testEventMapper{name: "a", confidence: 1},
+		&testEventMapper{name: "b", confidence: 0.5},
+	)
+	registry.Register(&testEventMapper{name: "a", confidence: 0.1})
+
+	assert.Equal(t, []string{"b", "a"}, registry.Names())
+	assert.Equal(t, "b", registry.Detect(&Record{}).Name())
+}
+
+func TestEventMapperRegistryNoMapperDetected(t *testing.T) {
+	registry := NewEventMapperRegistry(&testEventMapper{name: "none", confidence: 0})
+
+	logEntry, err := registry.MapLogEntry(&Record{})
+	assert.Nil(t, logEntry)
+	require.NotNil(t, err)
+	assert.Equal(t, "No event mapper detected the source of the record's event, tried: none (0)", err.Error())
+}
+
+func TestEventMapperRegistryNoMapperCouldMap(t *testing.T) {
+	registry := NewEventMapperRegistry(&builtInEventMapper{
+		name:        "failing",
+		detect:      func(event *Event) float64 { return 0.5 },
+		getLogEntry: func(record *Record) (*LogEntry, bool) { return nil, false },
+	})
+
+	logEntry, err := registry.MapLogEntry(&Record{})
+	assert.Nil(t, logEntry)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Event mapper failing (0.5) failed to map the record's event: Event isn't a valid failing event")
+	assert.Contains(t, err.Error(), "No event mapper could map the record's event, tried: failing (0.5)")
+}
+
+func TestEventSignatureScore(t *testing.T) {
+	signature := &eventSignature{
+		Required:      []string{"a"},
+		AnyOf:         []string{"b", "c"},
+		Values:        map[string][]string{"version": {"1.0", "2.0"}},
+		Optional:      []string{"d", "e"},
+		MaxConfidence: 0.8,
+	}
+
+	testCases := []struct {
+		event              string
+		expectedConfidence float64
+	}{
+		{`{"a":1,"b":1,"version":"2.0","d":1,"e":1}`, 0.8},
+		{`{"a":1,"c":1,"version":"1.0","d":1}`, 0.6},
+		{`{"a":1,"b":1,"version":"1.0"}`, 0.4},
+		{`{"a":null,"b":1,"version":"1.0"}`, 0},
+		{`{"a":1,"version":"1.0"}`, 0},
+		{`{"a":1,"b":1,"version":"3.0"}`, 0},
+		{`{"a":1,"b":1,"version":2}`, 0},
+		{`[]`, 0},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.event, func(t *testing.T) {
+			event := NewEvent(&Record{Event: json.RawMessage(testCase.event)})
+			assert.InDelta(t, testCase.expectedConfidence, signature.score(event), 1e-9)
+		})
+	}
+}
+
+func TestDefaultEventMapperRegistryDetection(t *testing.T) {
+	apiGatewayProxyRequestBytes, err := json.Marshal(getNewAPIGatewayProxyRequest())
+	require.Nil(t, err)
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(getNewAPIGatewayV2HTTPRequest())
+	require.Nil(t, err)
+
+	testCases := []struct {
+		event          string
+		expectedMapper string
+	}{
+		{string(apiGatewayProxyRequestBytes), "apigateway-v1"},
+		{string(apiGatewayV2HTTPRequestBytes), "apigateway-v2"},
+		{`{"Records":[{"messageId":"1","eventSource":"aws:sqs"}]}`, "event-invocation"},
+		{`{"type":"TOKEN","authorizationToken":"allow","methodArn":"` + testMethodArn + `"}`, "apigateway-authorizer"},
+		{testBedrockAgentEvent, "bedrock-agent"},
+		{`{"Records":[{"cf":{"config":{"eventType":"viewer-request"}}}]}`, "cloudfront"},
+		{`{"raw_path":"/","method":"GET"}`, "vpc-lattice"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.expectedMapper, func(t *testing.T) {
+			mapper := DefaultEventMapperRegistry.Detect(&Record{Event: json.RawMessage(testCase.event)})
+			require.NotNil(t, mapper)
+			assert.Equal(t, testCase.expectedMapper, mapper.Name())
+		})
+	}
+
+	assert.Nil(t, DefaultEventMapperRegistry.Detect(&Record{Event: json.RawMessage(`{"description":"test event"}`)}))
+}
@@ -0,0 +1,403 @@
+package firetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EventMappingFile is the format of a declarative event mapping file, which describes how to map events from event sources the extension
+// doesn't support out of the box, such as in-house event envelopes, using JSON paths. For example:
+//
+//	{
+//	  "mappings": [
+//	    {
+//	      "name": "acme-envelope",
+//	      "detect": [{"path": "$.envelope.kind", "equals": "http"}],
+//	      "request": {
+//	        "method": "$.envelope.request.verb",
+//	        "path": "$.envelope.request.path",
+//	        "headers": "$.envelope.request.headers",
+//	        "body": "$.envelope.request.payload",
+//	        "ip": "$.envelope.client.ip",
+//	        "time": "$.envelope.sentAt"
+//	      },
+//	      "response": {"statusCode": "$.status", "body": "$.payload"}
+//	    }
+//	  ]
+//	}
+//
+// JSON paths are made up of $ followed by .key, ['key'] and [index] selectors.
+type EventMappingFile struct {
+	Mappings []EventMapping `json:"mappings"`
+}
+
+// EventMapping describes how to detect & map the events of a single event source
+type EventMapping struct {
+	Name       string                  `json:"name"`
+	Confidence float64                 `json:"confidence"` // The confidence the mapping has in events it detects, from 0 to 1. Defaults to 1.
+	Detect     []EventMappingCondition `json:"detect"`     // Conditions the event must meet; the request's method & path must also be present
+	Request    EventMappingRequest     `json:"request"`
+	Response   *EventMappingResponse   `json:"response"` // Paths into the function's response. If omitted, the response is read as a RecordResponse.
+}
+
+// EventMappingCondition is a condition an event must meet to be detected by an EventMapping. If Equals is omitted, the value at the Path
+// must merely be present.
+type EventMappingCondition struct {
+	Path   string          `json:"path"`
+	Equals json.RawMessage `json:"equals"`
+}
+
+// EventMappingRequest holds the JSON paths of the values of a LogEntryRequest within the event. Method & Path are required. If Host is
+// given, the request's URI is https://{host}{path}, otherwise it is the path. If Resource isn't given, it is the path without its query
+// string. Time may be a number of UNIX seconds or milliseconds, or an RFC 3339 timestamp.
+type EventMappingRequest struct {
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	Host         string `json:"host"`
+	Resource     string `json:"resource"`
+	Headers      string `json:"headers"`
+	Body         string `json:"body"`
+	IP           string `json:"ip"`
+	Time         string `json:"time"`
+	HTTPProtocol string `json:"httpProtocol"`
+}
+
+// EventMappingResponse holds the JSON paths of the values of a LogEntryResponse within the function's response
+type EventMappingResponse struct {
+	StatusCode string `json:"statusCode"`
+	Headers    string `json:"headers"`
+	Body       string `json:"body"`
+}
+
+// declarativeEventMapper is an EventMapper for an EventMapping. The mapping's JSON paths are parsed once, when the mapper is created, and
+// the values of its detect conditions are unmarshalled once too.
+type declarativeEventMapper struct {
+	mapping        EventMapping
+	paths          map[string]jsonPath
+	expectedValues []interface{} // The values each of the mapping's detect conditions must equal, or nil if the condition has no Equals
+}
+
+// LoadEventMappingFile reads a declarative event mapping file and returns an EventMapper for each of its mappings
+func LoadEventMappingFile(filename string) ([]EventMapper, error) {
+	eventMappingFileBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to read event mapping file")
+	}
+	return ParseEventMappings(eventMappingFileBytes)
+}
+
+// ParseEventMappings parses the contents of a declarative event mapping file and returns an EventMapper for each of its mappings
+func ParseEventMappings(data []byte) ([]EventMapper, error) {
+	var eventMappingFile EventMappingFile
+	if err := json.Unmarshal(data, &eventMappingFile); err != nil {
+		return nil, errors.WithMessage(err, "Err unmarshalling event mapping file")
+	}
+
+	eventMappers := []EventMapper{}
+	for i, mapping := range eventMappingFile.Mappings {
+		if mapping.Name == "" {
+			return nil, errors.Errorf("Event mapping %d has no name", i)
+		}
+		if mapping.Request.Method == "" || mapping.Request.Path == "" {
+			return nil, errors.Errorf("Event mapping %s must have a request method & path", mapping.Name)
+		}
+		if mapping.Confidence == 0 {
+			mapping.Confidence = 1
+		}
+		if mapping.Confidence < 0 || mapping.Confidence > 1 {
+			return nil, errors.Errorf("Event mapping %s has confidence %g but it must be between 0 and 1", mapping.Name, mapping.Confidence)
+		}
+		eventMapper := &declarativeEventMapper{mapping: mapping, paths: map[string]jsonPath{}}
+		for _, path := range mapping.getPaths() {
+			parsedPath, err := parseJSONPath(path)
+			if err != nil {
+				return nil, errors.WithMessage(err, fmt.Sprintf("Event mapping %s has an invalid path", mapping.Name))
+			}
+			eventMapper.paths[path] = parsedPath
+		}
+		for _, condition := range mapping.Detect {
+			var expectedValue interface{}
+			if condition.Equals != nil {
+				if err := json.Unmarshal(condition.Equals, &expectedValue); err != nil {
+					return nil, errors.WithMessage(err, fmt.Sprintf("Event mapping %s has an invalid equals value", mapping.Name))
+				}
+			}
+			eventMapper.expectedValues = append(eventMapper.expectedValues, expectedValue)
+		}
+		eventMappers = append(eventMappers, eventMapper)
+	}
+
+	return eventMappers, nil
+}
+
+// getPaths returns every JSON path used by the mapping
+func (m *EventMapping) getPaths() []string {
+	paths := []string{
+		m.Request.Method, m.Request.Path, m.Request.Host, m.Request.Resource, m.Request.Headers, m.Request.Body, m.Request.IP,
+		m.Request.Time, m.Request.HTTPProtocol,
+	}
+	for _, condition := range m.Detect {
+		paths = append(paths, condition.Path)
+	}
+	if m.Response != nil {
+		paths = append(paths, m.Response.StatusCode, m.Response.Headers, m.Response.Body)
+	}
+	nonEmptyPaths := []string{}
+	for _, path := range paths {
+		if path != "" {
+			nonEmptyPaths = append(nonEmptyPaths, path)
+		}
+	}
+	return nonEmptyPaths
+}
+
+func (m *declarativeEventMapper) Name() string {
+	return m.mapping.Name
+}
+
+// path returns the parsed form of one of the mapping's JSON paths, or nil if the path is empty
+func (m *declarativeEventMapper) path(path string) jsonPath {
+	return m.paths[path]
+}
+
+func (m *declarativeEventMapper) Detect(event *Event) float64 {
+	value, err := event.Value()
+	if err != nil {
+		return 0
+	}
+	for i, condition := range m.mapping.Detect {
+		conditionValue, ok := getJSONPathValue(value, m.path(condition.Path))
+		if !ok {
+			return 0
+		}
+		if condition.Equals != nil && !reflect.DeepEqual(conditionValue, m.expectedValues[i]) {
+			return 0
+		}
+	}
+	if getJSONPathString(value, m.path(m.mapping.Request.Method)) == "" || getJSONPathString(value, m.path(m.mapping.Request.Path)) == "" {
+		return 0
+	}
+	return m.mapping.Confidence
+}
+
+func (m *declarativeEventMapper) Map(event *Event) (*LogEntry, error) {
+	value, err := event.Value()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Err unmarshalling event for event mapping "+m.mapping.Name)
+	}
+	request := m.mapping.Request
+
+	path := getJSONPathString(value, m.path(request.Path))
+	uri := path
+	if host := getJSONPathString(value, m.path(request.Host)); host != "" {
+		uri = "https://" + host + path
+	}
+	resource := getJSONPathString(value, m.path(request.Resource))
+	if resource == "" {
+		resource, _, _ = strings.Cut(path, "?")
+	}
+	requestTime, err := getJSONPathTime(value, m.path(request.Time))
+	if err != nil {
+		return nil, errors.WithMessage(err, "Err parsing request time for event mapping "+m.mapping.Name)
+	}
+
+	logEntry := event.Record.newLogEntry(
+		LogEntryRequest{
+			Body:         getJSONPathBody(value, m.path(request.Body)),
+			Headers:      getJSONPathHeaders(value, m.path(request.Headers)),
+			HTTPProtocol: LogEntryHTTPProtocol(getJSONPathString(value, m.path(request.HTTPProtocol))),
+			IP:           getJSONPathString(value, m.path(request.IP)),
+			Method:       LogEntryMethod(strings.ToUpper(getJSONPathString(value, m.path(request.Method)))),
+			URI:          uri,
+			Resource:     normaliseResource(resource),
+		},
+		requestTime,
+	)
+
+	if m.mapping.Response != nil {
+		var response interface{}
+		if err := json.Unmarshal(event.Record.RawResponse, &response); err == nil {
+			statusCode, _ := strconv.ParseInt(getJSONPathString(response, m.path(m.mapping.Response.StatusCode)), 10, 64)
+			logEntry.Response = LogEntryResponse{
+				Body:       getJSONPathBody(response, m.path(m.mapping.Response.Body)),
+				Headers:    getJSONPathHeaders(response, m.path(m.mapping.Response.Headers)),
+				StatusCode: statusCode,
+			}
+		}
+	}
+
+	return logEntry, nil
+}
+
+// jsonPathSelector selects a key of an object, or if key is nil an index of an array
+type jsonPathSelector struct {
+	key   *string
+	index int
+}
+
+// jsonPath is a parsed JSON path
+type jsonPath []jsonPathSelector
+
+// parseJSONPath parses a JSON path made up of $ followed by .key, ['key'] and [index] selectors
+func parseJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.Errorf("JSON path %s must start with $", path)
+	}
+	selectors := jsonPath{}
+	remainingPath := path[1:]
+	for remainingPath != "" {
+		switch {
+		case strings.HasPrefix(remainingPath, "['"):
+			end := strings.Index(remainingPath, "']")
+			if end < 0 {
+				return nil, errors.Errorf("JSON path %s has an unterminated ['key'] selector", path)
+			}
+			key := remainingPath[2:end]
+			selectors = append(selectors, jsonPathSelector{key: &key})
+			remainingPath = remainingPath[end+2:]
+		case strings.HasPrefix(remainingPath, "["):
+			end := strings.Index(remainingPath, "]")
+			if end < 0 {
+				return nil, errors.Errorf("JSON path %s has an unterminated [index] selector", path)
+			}
+			index, err := strconv.Atoi(remainingPath[1:end])
+			if err != nil || index < 0 {
+				return nil, errors.Errorf("JSON path %s has an invalid index %s", path, remainingPath[1:end])
+			}
+			selectors = append(selectors, jsonPathSelector{index: index})
+			remainingPath = remainingPath[end+1:]
+		case strings.HasPrefix(remainingPath, "."):
+			end := strings.IndexAny(remainingPath[1:], ".[")
+			if end < 0 {
+				end = len(remainingPath) - 1
+			}
+			key := remainingPath[1 : end+1]
+			if key == "" {
+				return nil, errors.Errorf("JSON path %s has an empty .key selector", path)
+			}
+			selectors = append(selectors, jsonPathSelector{key: &key})
+			remainingPath = remainingPath[end+1:]
+		default:
+			return nil, errors.Errorf("JSON path %s has an invalid selector at %s", path, remainingPath)
+		}
+	}
+	return selectors, nil
+}
+
+// getJSONPathValue returns the value at the JSON path within a value unmarshalled from JSON, and true, or nil and false if there is none.
+// A nil path has no value.
+func getJSONPathValue(value interface{}, path jsonPath) (interface{}, bool) {
+	if path == nil {
+		return nil, false
+	}
+	for _, selector := range path {
+		if selector.key != nil {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[*selector.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		array, ok := value.([]interface{})
+		if !ok || selector.index >= len(array) {
+			return nil, false
+		}
+		value = array[selector.index]
+	}
+	return value, true
+}
+
+// getJSONPathString returns the value at the JSON path as a string if it is a string, number or boolean, or otherwise the empty string
+func getJSONPathString(value interface{}, path jsonPath) string {
+	value, ok := getJSONPathValue(value, path)
+	if !ok {
+		return ""
+	}
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typedValue)
+	}
+	return ""
+}
+
+// getJSONPathBody returns the value at the JSON path as a body: strings are returned as they are, and any other value as JSON
+func getJSONPathBody(value interface{}, path jsonPath) string {
+	value, ok := getJSONPathValue(value, path)
+	if !ok || value == nil {
+		return ""
+	}
+	if body, ok := value.(string); ok {
+		return body
+	}
+	body, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(body)
+}
+
+// getJSONPathHeaders returns the object at the JSON path as normalised headers. Each header's value may be a string or a list of strings.
+func getJSONPathHeaders(value interface{}, path jsonPath) map[string][]string {
+	headers := map[string][]string{}
+	value, ok := getJSONPathValue(value, path)
+	if !ok {
+		return normaliseHeaders(nil, headers)
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return normaliseHeaders(nil, headers)
+	}
+	for headerName, headerValue := range object {
+		switch typedHeaderValue := headerValue.(type) {
+		case string:
+			headers[headerName] = append(headers[headerName], typedHeaderValue)
+		case []interface{}:
+			for _, headerValueElement := range typedHeaderValue {
+				if headerValueString, ok := headerValueElement.(string); ok {
+					headers[headerName] = append(headers[headerName], headerValueString)
+				}
+			}
+		}
+	}
+	return normaliseHeaders(nil, headers)
+}
+
+// getJSONPathTime returns the time at the JSON path in UNIX milliseconds, or 0 if there is none. Numbers below 1e12 are taken to be UNIX
+// seconds, as 1e12 milliseconds is in 2001.
+func getJSONPathTime(value interface{}, path jsonPath) (int64, error) {
+	value, ok := getJSONPathValue(value, path)
+	if !ok || value == nil {
+		return 0, nil
+	}
+	switch typedValue := value.(type) {
+	case float64:
+		if typedValue < 1e12 {
+			return int64(typedValue * 1000), nil
+		}
+		return int64(typedValue), nil
+	case string:
+		if number, err := strconv.ParseFloat(typedValue, 64); err == nil {
+			return getJSONPathTime(number, jsonPath{})
+		}
+		parsedTime, err := time.Parse(time.RFC3339Nano, typedValue)
+		if err != nil {
+			return 0, err
+		}
+		return parsedTime.UnixMilli(), nil
+	}
+	return 0, errors.Errorf("Time value %v is neither a number nor a string", value)
+}
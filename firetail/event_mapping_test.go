@@ -0,0 +1,200 @@
+package firetail
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEventMappingFile = `{
+	"mappings": [
+		{
+			"name": "acme-envelope",
+			"detect": [{"path": "$.envelope.kind", "equals": "http"}, {"path": "$.envelope.version"}],
+			"request": {
+				"method": "$.envelope.request.verb",
+				"path": "$.envelope.request.path",
+				"host": "$.envelope.request['x-host']",
+				"headers": "$.envelope.request.headers",
+				"body": "$.envelope.request.payload",
+				"ip": "$.envelope.clients[0].ip",
+				"time": "$.envelope.sentAt"
+			},
+			"response": {"statusCode": "$.status", "headers": "$.headers", "body": "$.payload"}
+		}
+	]
+}`
+
+const testEnvelopeEvent = `{
+	"envelope": {
+		"kind": "http",
+		"version": 3,
+		"sentAt": 1668685315,
+		"clients": [{"ip": "192.0.2.1"}],
+		"request": {
+			"verb": "post",
+			"path": "/orders?dryRun=true",
+			"x-host": "orders.internal",
+			"headers": {"Content-Type": "application/json", "Accept-Encoding": ["gzip", "br"]},
+			"payload": {"item": "widget"}
+		}
+	}
+}`
+
+func TestParseEventMappings(t *testing.T) {
+	eventMappers, err := ParseEventMappings([]byte(testEventMappingFile))
+	require.Nil(t, err)
+	require.Len(t, eventMappers, 1)
+	assert.Equal(t, "acme-envelope", eventMappers[0].Name())
+
+	testRecord := &Record{
+		Event:         json.RawMessage(testEnvelopeEvent),
+		RawResponse:   json.RawMessage(`{"status":201,"headers":{"Location":"/orders/1"},"payload":"created"}`),
+		ExecutionTime: 50,
+	}
+	assert.Equal(t, float64(1), eventMappers[0].Detect(NewEvent(testRecord)))
+
+	logEntry, err := eventMappers[0].Map(NewEvent(testRecord))
+	require.Nil(t, err)
+	assert.Equal(t, int64(1668685315000), logEntry.DateCreated)
+	assert.Equal(t, float64(50), logEntry.ExecutionTime)
+	assert.Equal(t, LogEntryRequest{
+		Body:     `{"item":"widget"}`,
+		Headers:  map[string][]string{"content-type": {"application/json"}, "accept-encoding": {"gzip", "br"}},
+		IP:       "192.0.2.1",
+		Method:   Post,
+		URI:      "https://orders.internal/orders?dryRun=true",
+		Resource: "/orders",
+	}, logEntry.Request)
+	assert.Equal(t, LogEntryResponse{
+		Body:       "created",
+		Headers:    map[string][]string{"location": {"/orders/1"}},
+		StatusCode: 201,
+	}, logEntry.Response)
+}
+
+func TestEventMappingDetect(t *testing.T) {
+	eventMappers, err := ParseEventMappings([]byte(testEventMappingFile))
+	require.Nil(t, err)
+
+	testCases := []struct {
+		name               string
+		event              string
+		expectedConfidence float64
+	}{
+		{"Matches", testEnvelopeEvent, 1},
+		{"WrongKind", `{"envelope":{"kind":"queue","version":3,"request":{"verb":"GET","path":"/"}}}`, 0},
+		{"MissingVersion", `{"envelope":{"kind":"http","request":{"verb":"GET","path":"/"}}}`, 0},
+		{"MissingMethod", `{"envelope":{"kind":"http","version":3,"request":{"path":"/"}}}`, 0},
+		{"NotJSON", `not json`, 0},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expectedConfidence, eventMappers[0].Detect(NewEvent(&Record{Event: json.RawMessage(testCase.event)})))
+		})
+	}
+}
+
+func TestEventMappingTakesPrecedenceInRegistry(t *testing.T) {
+	eventMappers, err := ParseEventMappings([]byte(`{"mappings":[{"name":"v2-lookalike","request":{"method":"$.requestContext.http.method","path":"$.rawPath"}}]}`))
+	require.Nil(t, err)
+	registry := NewEventMapperRegistry(append(getBuiltInEventMappers(), eventMappers...)...)
+
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(getNewAPIGatewayV2HTTPRequest())
+	require.Nil(t, err)
+
+	assert.Equal(t, "v2-lookalike", registry.Detect(&Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes)}).Name())
+}
+
+func TestParseEventMappingsInvalid(t *testing.T) {
+	testCases := []struct {
+		name          string
+		file          string
+		expectedError string
+	}{
+		{"NotJSON", `not json`, "Err unmarshalling event mapping file"},
+		{"NoName", `{"mappings":[{"request":{"method":"$.m","path":"$.p"}}]}`, "Event mapping 0 has no name"},
+		{"NoMethod", `{"mappings":[{"name":"a","request":{"path":"$.p"}}]}`, "Event mapping a must have a request method & path"},
+		{"BadConfidence", `{"mappings":[{"name":"a","confidence":2,"request":{"method":"$.m","path":"$.p"}}]}`, "Event mapping a has confidence 2 but it must be between 0 and 1"},
+		{"BadPath", `{"mappings":[{"name":"a","request":{"method":"m","path":"$.p"}}]}`, "Event mapping a has an invalid path: JSON path m must start with $"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			eventMappers, err := ParseEventMappings([]byte(testCase.file))
+			assert.Nil(t, eventMappers)
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), testCase.expectedError)
+		})
+	}
+}
+
+func TestLoadEventMappingFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mappings.json")
+	require.Nil(t, os.WriteFile(filename, []byte(testEventMappingFile), 0600))
+
+	eventMappers, err := LoadEventMappingFile(filename)
+	require.Nil(t, err)
+	assert.Len(t, eventMappers, 1)
+
+	_, err = LoadEventMappingFile(filepath.Join(t.TempDir(), "missing.json"))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Failed to read event mapping file")
+}
+
+func TestGetJSONPathValue(t *testing.T) {
+	var value interface{}
+	require.Nil(t, json.Unmarshal([]byte(`{"a":{"b-c":[{"d":1},{"d":"two"}]}}`), &value))
+
+	testCases := []struct {
+		path          string
+		expectedValue interface{}
+		expectedOk    bool
+	}{
+		{"$.a['b-c'][1].d", "two", true},
+		{"$.a['b-c'][0].d", float64(1), true},
+		{"$.a['b-c'][2].d", nil, false},
+		{"$.a.x", nil, false},
+		{"$.a['b-c'].d", nil, false},
+		{"", nil, false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			var path jsonPath
+			if testCase.path != "" {
+				var err error
+				path, err = parseJSONPath(testCase.path)
+				require.Nil(t, err)
+			}
+			value, ok := getJSONPathValue(value, path)
+			assert.Equal(t, testCase.expectedOk, ok)
+			assert.Equal(t, testCase.expectedValue, value)
+		})
+	}
+}
+
+func TestGetJSONPathTime(t *testing.T) {
+	path, err := parseJSONPath("$.t")
+	require.Nil(t, err)
+
+	testCases := []struct {
+		value        interface{}
+		expectedTime int64
+	}{
+		{float64(1668685315), 1668685315000},
+		{float64(1668685315222), 1668685315222},
+		{"1668685315.5", 1668685315500},
+		{"2022-11-17T11:41:55.222Z", 1668685315222},
+	}
+	for _, testCase := range testCases {
+		requestTime, err := getJSONPathTime(map[string]interface{}{"t": testCase.value}, path)
+		require.Nil(t, err)
+		assert.Equal(t, testCase.expectedTime, requestTime)
+	}
+
+	_, err = getJSONPathTime(map[string]interface{}{"t": "yesterday"}, path)
+	assert.NotNil(t, err)
+}
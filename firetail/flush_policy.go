@@ -0,0 +1,87 @@
+package firetail
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The modes of flushing records to Firetail relative to the invocations they were captured from
+const (
+	FlushPolicySync       = "sync"        // Wait for the invocation's record to be flushed before requesting the next event
+	FlushPolicyAsync      = "async"       // Wait for the invocation's record to be captured, then start a flush without waiting for it
+	FlushPolicyOnShutdown = "on-shutdown" // Only flush when the execution environment shuts down, otherwise relying on the batch limits
+)
+
+// FlushPolicy configures when records are flushed to Firetail relative to the invocations they were captured from. Lambda may freeze
+// the execution environment as soon as the extension requests the next event after an invocation, so records batched but not yet sent
+// may sit unsent until the environment is next thawed, or be lost if it is shut down.
+type FlushPolicy struct {
+	Mode    string        // One of FlushPolicySync, FlushPolicyAsync or FlushPolicyOnShutdown
+	Timeout time.Duration // The maximum time spent flushing an invocation's record once it has been captured
+}
+
+// DefaultFlushPolicy is the FlushPolicy used after each invocation
+var DefaultFlushPolicy = &FlushPolicy{Mode: FlushPolicySync, Timeout: time.Second}
+
+// LoadEnvVars configures the FlushPolicy from the FIRETAIL_FLUSH_POLICY & FIRETAIL_FLUSH_TIMEOUT env vars. FIRETAIL_FLUSH_TIMEOUT is parsed by
+// time.ParseDuration.
+func (p *FlushPolicy) LoadEnvVars() error {
+	if mode, isSet := os.LookupEnv("FIRETAIL_FLUSH_POLICY"); isSet {
+		switch mode {
+		case FlushPolicySync, FlushPolicyAsync, FlushPolicyOnShutdown:
+			p.Mode = mode
+		default:
+			return errors.Errorf("FIRETAIL_FLUSH_POLICY is %s but must be one of %s, %s or %s", mode,
+				FlushPolicySync, FlushPolicyAsync, FlushPolicyOnShutdown)
+		}
+	}
+	if timeoutStr, isSet := os.LookupEnv("FIRETAIL_FLUSH_TIMEOUT"); isSet {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_FLUSH_TIMEOUT invalid")
+		}
+		if timeout <= 0 {
+			return errors.Errorf("FIRETAIL_FLUSH_TIMEOUT is %s but must be > 0", timeoutStr)
+		}
+		p.Timeout = timeout
+	}
+	return nil
+}
+
+// FlushInvocation flushes the record of the invocation with the provided request ID according to the FlushPolicy's mode, returning once
+// it's safe to request the next event. awaitInvocation should block until the invocation's record has been passed to the batcher, and may
+// be nil if records can't be matched to invocations, in which case whatever has been received is flushed. flush should flush the batcher.
+// The invocation can take as long as the function's timeout to complete, so it's awaited until the invocation's deadline, if it's
+// non-zero, or the context is done; only the flush is bounded by the FlushPolicy's Timeout.
+func (p *FlushPolicy) FlushInvocation(ctx context.Context, requestID string, deadline time.Time,
+	awaitInvocation func(context.Context, string) error, flush func(context.Context) error) error {
+	if p.Mode == FlushPolicyOnShutdown {
+		return nil
+	}
+
+	if awaitInvocation != nil {
+		awaitCtx, cancel := ctx, func() {}
+		if !deadline.IsZero() {
+			awaitCtx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		err := awaitInvocation(awaitCtx, requestID)
+		cancel()
+		if err != nil {
+			return errors.WithMessage(err, "Err awaiting invocation "+requestID)
+		}
+	}
+
+	if p.Mode == FlushPolicyAsync {
+		go flush(context.Background())
+		return nil
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	if err := flush(timeoutCtx); err != nil {
+		return errors.WithMessage(err, "Err flushing invocation "+requestID)
+	}
+	return nil
+}
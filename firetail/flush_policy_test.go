@@ -0,0 +1,145 @@
+package firetail
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushInvocationSync(t *testing.T) {
+	awaitedRequestIDs := []string{}
+	flushes := 0
+	err := (&FlushPolicy{Mode: FlushPolicySync, Timeout: time.Second}).FlushInvocation(
+		context.Background(),
+		"test-request-id",
+		time.Time{},
+		func(ctx context.Context, requestID string) error {
+			awaitedRequestIDs = append(awaitedRequestIDs, requestID)
+			return nil
+		},
+		func(ctx context.Context) error {
+			flushes++
+			return nil
+		},
+	)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"test-request-id"}, awaitedRequestIDs)
+	assert.Equal(t, 1, flushes)
+}
+
+func TestFlushInvocationSyncTimeout(t *testing.T) {
+	err := (&FlushPolicy{Mode: FlushPolicySync, Timeout: 10 * time.Millisecond}).FlushInvocation(
+		context.Background(),
+		"test-request-id",
+		time.Time{},
+		nil,
+		func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	)
+	require.NotNil(t, err)
+	assert.Equal(t, "Err flushing invocation test-request-id: context deadline exceeded", err.Error())
+}
+
+func TestFlushInvocationAwaitDeadline(t *testing.T) {
+	flushes := 0
+	err := (&FlushPolicy{Mode: FlushPolicySync, Timeout: time.Second}).FlushInvocation(
+		context.Background(),
+		"test-request-id",
+		time.Now().Add(10*time.Millisecond),
+		func(ctx context.Context, requestID string) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		func(ctx context.Context) error {
+			flushes++
+			return nil
+		},
+	)
+	require.NotNil(t, err)
+	assert.Equal(t, "Err awaiting invocation test-request-id: context deadline exceeded", err.Error())
+	assert.Equal(t, 0, flushes)
+}
+
+func TestFlushInvocationAwaitIgnoresTimeout(t *testing.T) {
+	flushes := 0
+	err := (&FlushPolicy{Mode: FlushPolicySync, Timeout: 10 * time.Millisecond}).FlushInvocation(
+		context.Background(),
+		"test-request-id",
+		time.Now().Add(time.Second),
+		func(ctx context.Context, requestID string) error {
+			// Invocations can take longer than the flush timeout to complete
+			time.Sleep(50 * time.Millisecond)
+			return ctx.Err()
+		},
+		func(ctx context.Context) error {
+			flushes++
+			return ctx.Err()
+		},
+	)
+	require.Nil(t, err)
+	assert.Equal(t, 1, flushes)
+}
+
+func TestFlushInvocationAsync(t *testing.T) {
+	flushed := make(chan struct{})
+	unblockFlush := make(chan struct{})
+	err := (&FlushPolicy{Mode: FlushPolicyAsync, Timeout: time.Second}).FlushInvocation(
+		context.Background(),
+		"test-request-id",
+		time.Time{},
+		nil,
+		func(ctx context.Context) error {
+			<-unblockFlush
+			close(flushed)
+			return nil
+		},
+	)
+	// The flush is still blocked, so FlushInvocation must have returned without waiting for it
+	require.Nil(t, err)
+	close(unblockFlush)
+	select {
+	case <-flushed:
+	case <-time.After(time.Second):
+		t.Fatal("Async flush wasn't started")
+	}
+}
+
+func TestFlushInvocationOnShutdown(t *testing.T) {
+	err := (&FlushPolicy{Mode: FlushPolicyOnShutdown, Timeout: time.Second}).FlushInvocation(
+		context.Background(),
+		"test-request-id",
+		time.Time{},
+		func(ctx context.Context, requestID string) error {
+			t.Fatal("Invocation shouldn't be awaited")
+			return nil
+		},
+		func(ctx context.Context) error {
+			t.Fatal("Records shouldn't be flushed")
+			return nil
+		},
+	)
+	assert.Nil(t, err)
+}
+
+func TestFlushPolicyLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_FLUSH_POLICY", "async")
+	t.Setenv("FIRETAIL_FLUSH_TIMEOUT", "250ms")
+	flushPolicy := &FlushPolicy{}
+	require.Nil(t, flushPolicy.LoadEnvVars())
+	assert.Equal(t, &FlushPolicy{Mode: FlushPolicyAsync, Timeout: 250 * time.Millisecond}, flushPolicy)
+
+	t.Setenv("FIRETAIL_FLUSH_TIMEOUT", "0s")
+	err := (&FlushPolicy{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_FLUSH_TIMEOUT is 0s but must be > 0", err.Error())
+
+	t.Setenv("FIRETAIL_FLUSH_POLICY", "sometimes")
+	err = (&FlushPolicy{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_FLUSH_POLICY is sometimes but must be one of sync, async or on-shutdown", err.Error())
+}
@@ -0,0 +1,365 @@
+package firetail
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GraphQLDetection configures whether request bodies are inspected for GraphQL operations. GraphQL APIs are usually served from a single
+// route, so every operation has the same resource; the operations detected are summarised in the log entry's metadata instead.
+type GraphQLDetection struct {
+	Enabled bool
+}
+
+// DefaultGraphQLDetection is the GraphQLDetection used for all log entries. It is disabled until configured otherwise.
+var DefaultGraphQLDetection = &GraphQLDetection{}
+
+// LoadEnvVars configures the GraphQLDetection from the FIRETAIL_GRAPHQL_DETECTION env var
+func (g *GraphQLDetection) LoadEnvVars() error {
+	if enabledStr, isSet := os.LookupEnv("FIRETAIL_GRAPHQL_DETECTION"); isSet {
+		enabled, err := strconv.ParseBool(enabledStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_GRAPHQL_DETECTION invalid")
+		}
+		g.Enabled = enabled
+	}
+	return nil
+}
+
+// graphQLRequest is the body of a GraphQL request sent over HTTP. Automatic persisted queries may be sent without their query, in which
+// case only the hash of the query is given in its extensions.
+type graphQLRequest struct {
+	Query         *string `json:"query"`
+	OperationName string  `json:"operationName"`
+	Extensions    struct {
+		PersistedQuery *struct {
+			SHA256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// graphQLResponse is the envelope of a GraphQL response. Errors may be present regardless of the HTTP status code of the response.
+type graphQLResponse struct {
+	Errors []json.RawMessage `json:"errors"`
+}
+
+// getGraphQLMetadata returns a summary of the GraphQL operations in a log entry, or nil if its request body isn't a GraphQL request.
+// Request bodies may be a single GraphQL request, a batch of them in an array, or a bare query sent as application/graphql.
+func getGraphQLMetadata(logEntry *LogEntry) *LogEntryGraphQLMetadata {
+	requestBody := strings.TrimSpace(logEntry.Request.Body)
+	graphQLMetadata := &LogEntryGraphQLMetadata{Operations: []LogEntryGraphQLOperation{}}
+
+	var graphQLRequests []graphQLRequest
+	switch {
+	case strings.HasPrefix(requestBody, "["):
+		if err := json.Unmarshal([]byte(requestBody), &graphQLRequests); err != nil {
+			return nil
+		}
+		graphQLMetadata.Batched = true
+	case strings.HasPrefix(requestBody, "{"):
+		var graphQLRequest graphQLRequest
+		if err := json.Unmarshal([]byte(requestBody), &graphQLRequest); err != nil {
+			return nil
+		}
+		graphQLRequests = append(graphQLRequests, graphQLRequest)
+	case isGraphQLContentType(getHeaderValue(logEntry.Request.Headers, "content-type")):
+		graphQLRequests = append(graphQLRequests, graphQLRequest{Query: &requestBody})
+	default:
+		return nil
+	}
+
+	for _, graphQLRequest := range graphQLRequests {
+		operation, ok := graphQLRequest.getOperation()
+		if !ok {
+			return nil
+		}
+		graphQLMetadata.Operations = append(graphQLMetadata.Operations, operation)
+	}
+	if len(graphQLMetadata.Operations) == 0 {
+		return nil
+	}
+
+	if !logEntry.Response.IsBase64Encoded {
+		graphQLMetadata.ErrorCount = getGraphQLErrorCount(logEntry.Response.Body)
+		graphQLMetadata.HasErrors = graphQLMetadata.ErrorCount > 0
+	}
+
+	return graphQLMetadata
+}
+
+// isGraphQLContentType returns true if the provided Content-Type header value is application/graphql
+func isGraphQLContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "application/graphql")
+}
+
+// getOperation returns the operation a GraphQL request executes, and true, or false if the request has neither a query which parses
+// into at least one operation nor a persisted query hash. If the query has several operations, the one named by the request's operation
+// name is executed.
+func (r *graphQLRequest) getOperation() (LogEntryGraphQLOperation, bool) {
+	operation := LogEntryGraphQLOperation{Name: r.OperationName}
+	if r.Extensions.PersistedQuery != nil {
+		operation.PersistedQueryHash = r.Extensions.PersistedQuery.SHA256Hash
+	}
+	if r.Query == nil || *r.Query == "" {
+		return operation, operation.PersistedQueryHash != ""
+	}
+
+	documentOperations, ok := parseGraphQLOperations(*r.Query)
+	if !ok || len(documentOperations) == 0 {
+		return operation, false
+	}
+	documentOperation := documentOperations[0]
+	for _, candidateOperation := range documentOperations {
+		if r.OperationName != "" && candidateOperation.Name == r.OperationName {
+			documentOperation = candidateOperation
+			break
+		}
+	}
+	documentOperation.PersistedQueryHash = operation.PersistedQueryHash
+	return documentOperation, true
+}
+
+// getGraphQLErrorCount returns the number of errors in a GraphQL response body, or in all of the responses to a batch
+func getGraphQLErrorCount(responseBody string) int {
+	responseBody = strings.TrimSpace(responseBody)
+	var graphQLResponses []graphQLResponse
+	if strings.HasPrefix(responseBody, "[") {
+		if err := json.Unmarshal([]byte(responseBody), &graphQLResponses); err != nil {
+			return 0
+		}
+	} else {
+		var graphQLResponse graphQLResponse
+		if err := json.Unmarshal([]byte(responseBody), &graphQLResponse); err != nil {
+			return 0
+		}
+		graphQLResponses = append(graphQLResponses, graphQLResponse)
+	}
+	errorCount := 0
+	for _, graphQLResponse := range graphQLResponses {
+		errorCount += len(graphQLResponse.Errors)
+	}
+	return errorCount
+}
+
+// parseGraphQLOperations returns the type, name & top-level fields of each operation in a GraphQL document, and true, or false if the
+// document can't be parsed. Fragment definitions are skipped, as are fragment spreads in an operation's top-level selections.
+func parseGraphQLOperations(document string) ([]LogEntryGraphQLOperation, bool) {
+	tokens, ok := tokeniseGraphQL(document)
+	if !ok {
+		return nil, false
+	}
+
+	operations := []LogEntryGraphQLOperation{}
+	for i := 0; i < len(tokens); {
+		operation := LogEntryGraphQLOperation{}
+		switch tokens[i] {
+		case "{":
+			operation.Type = "query"
+		case "query", "mutation", "subscription":
+			operation.Type = tokens[i]
+			i++
+			if i < len(tokens) && isGraphQLName(tokens[i]) {
+				operation.Name = tokens[i]
+				i++
+			}
+			if i < len(tokens) && tokens[i] == "(" {
+				if i, ok = skipGraphQLBalanced(tokens, i, "(", ")"); !ok {
+					return nil, false
+				}
+			}
+			if i, ok = skipGraphQLDirectives(tokens, i); !ok {
+				return nil, false
+			}
+		case "fragment":
+			// fragment Name on Type Directives? SelectionSet
+			if i+3 >= len(tokens) {
+				return nil, false
+			}
+			if i, ok = skipGraphQLDirectives(tokens, i+4); !ok {
+				return nil, false
+			}
+			if i, ok = skipGraphQLBalanced(tokens, i, "{", "}"); !ok {
+				return nil, false
+			}
+			continue
+		default:
+			return nil, false
+		}
+
+		if operation.Fields, i, ok = parseGraphQLSelectionSet(tokens, i); !ok {
+			return nil, false
+		}
+		operations = append(operations, operation)
+	}
+
+	return operations, true
+}
+
+// parseGraphQLSelectionSet returns the names of the fields selected by the selection set starting at tokens[i], and the index of the
+// token after it
+func parseGraphQLSelectionSet(tokens []string, i int) ([]string, int, bool) {
+	if i >= len(tokens) || tokens[i] != "{" {
+		return nil, i, false
+	}
+	i++
+
+	fields := []string{}
+	var ok bool
+	for i < len(tokens) && tokens[i] != "}" {
+		if tokens[i] == "..." {
+			i++
+			if i < len(tokens) && tokens[i] == "on" {
+				i += 2
+			} else if i < len(tokens) && isGraphQLName(tokens[i]) {
+				i++
+			}
+		} else {
+			if !isGraphQLName(tokens[i]) {
+				return nil, i, false
+			}
+			field := tokens[i]
+			i++
+			// An alias is followed by a colon & the name of the field it aliases
+			if i+1 < len(tokens) && tokens[i] == ":" {
+				field = tokens[i+1]
+				i += 2
+			}
+			fields = append(fields, field)
+			if i < len(tokens) && tokens[i] == "(" {
+				if i, ok = skipGraphQLBalanced(tokens, i, "(", ")"); !ok {
+					return nil, i, false
+				}
+			}
+		}
+		if i, ok = skipGraphQLDirectives(tokens, i); !ok {
+			return nil, i, false
+		}
+		if i < len(tokens) && tokens[i] == "{" {
+			if i, ok = skipGraphQLBalanced(tokens, i, "{", "}"); !ok {
+				return nil, i, false
+			}
+		}
+	}
+	if i >= len(tokens) {
+		return nil, i, false
+	}
+
+	return fields, i + 1, true
+}
+
+// skipGraphQLDirectives returns the index of the first token after any directives starting at tokens[i]
+func skipGraphQLDirectives(tokens []string, i int) (int, bool) {
+	var ok bool
+	for i+1 < len(tokens) && tokens[i] == "@" {
+		i += 2
+		if i < len(tokens) && tokens[i] == "(" {
+			if i, ok = skipGraphQLBalanced(tokens, i, "(", ")"); !ok {
+				return i, false
+			}
+		}
+	}
+	return i, true
+}
+
+// skipGraphQLBalanced returns the index of the token after the close token which balances the open token at tokens[i]
+func skipGraphQLBalanced(tokens []string, i int, open, close string) (int, bool) {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i] {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		}
+	}
+	return i, false
+}
+
+// isGraphQLName returns true if the token is a GraphQL name
+func isGraphQLName(token string) bool {
+	if token == "" {
+		return false
+	}
+	for i, char := range token {
+		if !(char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (i > 0 && char >= '0' && char <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokeniseGraphQL splits a GraphQL document into its names, punctuators & values, ignoring whitespace, commas & comments. Strings are
+// replaced with a single placeholder token, as their contents are never needed.
+func tokeniseGraphQL(document string) ([]string, bool) {
+	tokens := []string{}
+	for i := 0; i < len(document); {
+		char := document[i]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == ',':
+			i++
+		case char == '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(document[i:], `"""`):
+			end := strings.Index(document[i+3:], `"""`)
+			for end >= 0 && strings.HasSuffix(document[:i+3+end], `\`) {
+				nextEnd := strings.Index(document[i+3+end+3:], `"""`)
+				if nextEnd < 0 {
+					end = -1
+					break
+				}
+				end += 3 + nextEnd
+			}
+			if end < 0 {
+				return nil, false
+			}
+			tokens = append(tokens, `""`)
+			i += 3 + end + 3
+		case char == '"':
+			i++
+			for i < len(document) && document[i] != '"' {
+				if document[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(document) {
+				return nil, false
+			}
+			tokens = append(tokens, `""`)
+			i++
+		case strings.HasPrefix(document[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case strings.ContainsRune("!$&():=@[]{}|", rune(char)):
+			tokens = append(tokens, string(char))
+			i++
+		default:
+			start := i
+			for i < len(document) && !strings.ContainsRune(" \t\n\r,#\"!$&():=@[]{}|.", rune(document[i])) {
+				i++
+			}
+			// Numbers may contain a decimal point
+			for i < len(document) && document[i] == '.' && !strings.HasPrefix(document[i:], "...") && start < i {
+				i++
+				for i < len(document) && !strings.ContainsRune(" \t\n\r,#\"!$&():=@[]{}|.", rune(document[i])) {
+					i++
+				}
+			}
+			if start == i {
+				return nil, false
+			}
+			tokens = append(tokens, document[start:i])
+		}
+	}
+	return tokens, true
+}
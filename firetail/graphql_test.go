@@ -0,0 +1,140 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getGraphQLLogEntry(requestBody, contentType, responseBody string) *LogEntry {
+	return &LogEntry{
+		Request:  LogEntryRequest{Body: requestBody, Headers: map[string][]string{"content-type": {contentType}}, Method: Post, Resource: "/graphql"},
+		Response: LogEntryResponse{Body: responseBody, StatusCode: 200},
+	}
+}
+
+func TestGetGraphQLMetadata(t *testing.T) {
+	testCases := []struct {
+		name             string
+		requestBody      string
+		contentType      string
+		responseBody     string
+		expectedMetadata *LogEntryGraphQLMetadata
+	}{
+		{
+			"NamedQuery",
+			`{"query":"query GetPet($id: ID!) { pet(id: $id) { name owner { name } } __typename }","operationName":"GetPet","variables":{"id":"1"}}`,
+			"application/json",
+			`{"data":{"pet":{"name":"Rex"}}}`,
+			&LogEntryGraphQLMetadata{Operations: []LogEntryGraphQLOperation{{Name: "GetPet", Type: "query", Fields: []string{"pet", "__typename"}}}},
+		},
+		{
+			"AnonymousQueryShorthand",
+			`{"query":"{ pets { name } }"}`,
+			"application/json",
+			`{"data":{"pets":[]}}`,
+			&LogEntryGraphQLMetadata{Operations: []LogEntryGraphQLOperation{{Type: "query", Fields: []string{"pets"}}}},
+		},
+		{
+			"SelectsOperationByName",
+			`{"query":"query A { a } mutation B($input: PetInput = {name: \"}\"}) @audit(reason: \"test\") { renamed: updatePet(input: $input) { id } ...Extra ... on Mutation { other } } fragment Extra on Mutation { extra }","operationName":"B"}`,
+			"application/json",
+			`{"data":null,"errors":[{"message":"Not allowed"},{"message":"Also not allowed"}]}`,
+			&LogEntryGraphQLMetadata{
+				Operations: []LogEntryGraphQLOperation{{Name: "B", Type: "mutation", Fields: []string{"updatePet"}}},
+				HasErrors:  true,
+				ErrorCount: 2,
+			},
+		},
+		{
+			"PersistedQueryWithoutQuery",
+			`{"operationName":"GetPets","extensions":{"persistedQuery":{"version":1,"sha256Hash":"ecf4edb46db40b5132295c0291d62fb65d6759a9eedfa4d5d612dd5ec54a6b38"}}}`,
+			"application/json",
+			`{"errors":[{"message":"PersistedQueryNotFound"}]}`,
+			&LogEntryGraphQLMetadata{
+				Operations: []LogEntryGraphQLOperation{{Name: "GetPets", PersistedQueryHash: "ecf4edb46db40b5132295c0291d62fb65d6759a9eedfa4d5d612dd5ec54a6b38"}},
+				HasErrors:  true,
+				ErrorCount: 1,
+			},
+		},
+		{
+			"Batch",
+			`[{"query":"query A { a }"},{"query":"# comment\nsubscription OnPet { petAdded { id } }"}]`,
+			"application/json",
+			`[{"data":{"a":1}},{"errors":[{"message":"Subscriptions not supported"}]}]`,
+			&LogEntryGraphQLMetadata{
+				Operations: []LogEntryGraphQLOperation{{Name: "A", Type: "query", Fields: []string{"a"}}, {Name: "OnPet", Type: "subscription", Fields: []string{"petAdded"}}},
+				Batched:    true,
+				HasErrors:  true,
+				ErrorCount: 1,
+			},
+		},
+		{
+			"ApplicationGraphQL",
+			`mutation { deletePet(id: 1.5e3) }`,
+			"application/graphql; charset=utf-8",
+			`{"data":{"deletePet":true}}`,
+			&LogEntryGraphQLMetadata{Operations: []LogEntryGraphQLOperation{{Type: "mutation", Fields: []string{"deletePet"}}}},
+		},
+		{"NotGraphQLQueryField", `{"query":"red shoes"}`, "application/json", `{"results":[]}`, nil},
+		{"NotGraphQLObject", `{"name":"Rex"}`, "application/json", `{}`, nil},
+		{"UnterminatedSelectionSet", `{"query":"query { pets { name }"}`, "application/json", `{}`, nil},
+		{"EmptyBody", ``, "", ``, nil},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expectedMetadata, getGraphQLMetadata(getGraphQLLogEntry(testCase.requestBody, testCase.contentType, testCase.responseBody)))
+		})
+	}
+}
+
+func TestGetGraphQLMetadataIgnoresBinaryResponse(t *testing.T) {
+	logEntry := getGraphQLLogEntry(`{"query":"{ a }"}`, "application/json", "eyJlcnJvcnMiOlt7fV19")
+	logEntry.Response.IsBase64Encoded = true
+
+	graphQLMetadata := getGraphQLMetadata(logEntry)
+	require.NotNil(t, graphQLMetadata)
+	assert.False(t, graphQLMetadata.HasErrors)
+}
+
+func TestGetLogEntryGraphQLDetection(t *testing.T) {
+	defaultGraphQLDetection := *DefaultGraphQLDetection
+	defer func() { *DefaultGraphQLDetection = defaultGraphQLDetection }()
+
+	apiGatewayV2HTTPRequest := getNewAPIGatewayV2HTTPRequest()
+	apiGatewayV2HTTPRequest.Body = `{"query":"query GetPets { pets { name } }"}`
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(apiGatewayV2HTTPRequest)
+	require.Nil(t, err)
+	testRecord := Record{
+		Event:    json.RawMessage(apiGatewayV2HTTPRequestBytes),
+		Response: RecordResponse{StatusCode: 200, Body: `{"errors":[{"message":"Unauthorised"}]}`},
+	}
+
+	DefaultGraphQLDetection.Enabled = false
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	assert.Nil(t, logEntry.Metadata.GraphQL)
+
+	DefaultGraphQLDetection.Enabled = true
+	logEntry, err = testRecord.getLogEntry()
+	require.Nil(t, err)
+	assert.Equal(t, &LogEntryGraphQLMetadata{
+		Operations: []LogEntryGraphQLOperation{{Name: "GetPets", Type: "query", Fields: []string{"pets"}}},
+		HasErrors:  true,
+		ErrorCount: 1,
+	}, logEntry.Metadata.GraphQL)
+}
+
+func TestGraphQLDetectionLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_GRAPHQL_DETECTION", "true")
+	graphQLDetection := &GraphQLDetection{}
+	require.Nil(t, graphQLDetection.LoadEnvVars())
+	assert.True(t, graphQLDetection.Enabled)
+
+	t.Setenv("FIRETAIL_GRAPHQL_DETECTION", "sometimes")
+	err := graphQLDetection.LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "FIRETAIL_GRAPHQL_DETECTION invalid")
+}
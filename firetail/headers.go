@@ -0,0 +1,108 @@
+package firetail
+
+import "strings"
+
+// listValuedHeaders are the (lower-cased) names of headers whose values are comma-separated lists according to the HTTP spec (RFC 9110)
+// and the de facto standards for proxy & CORS headers. Any other header may legitimately contain commas in its value (e.g. Date,
+// User-Agent, Cookie, Set-Cookie, Authorization), so their values are never split.
+var listValuedHeaders = map[string]bool{
+	"accept":                         true,
+	"accept-charset":                 true,
+	"accept-encoding":                true,
+	"accept-language":                true,
+	"accept-ranges":                  true,
+	"access-control-allow-headers":   true,
+	"access-control-allow-methods":   true,
+	"access-control-expose-headers":  true,
+	"access-control-request-headers": true,
+	"allow":                          true,
+	"cache-control":                  true,
+	"connection":                     true,
+	"content-encoding":               true,
+	"content-language":               true,
+	"expect":                         true,
+	"forwarded":                      true,
+	"if-match":                       true,
+	"if-none-match":                  true,
+	"pragma":                         true,
+	"te":                             true,
+	"trailer":                        true,
+	"transfer-encoding":              true,
+	"upgrade":                        true,
+	"vary":                           true,
+	"via":                            true,
+	"x-forwarded-for":                true,
+	"x-forwarded-port":               true,
+	"x-forwarded-proto":              true,
+}
+
+// normaliseHeaderName returns the lower-cased form of a header name, which is how HTTP/2 & API Gateway v2 present them
+func normaliseHeaderName(headerName string) string {
+	return strings.ToLower(strings.TrimSpace(headerName))
+}
+
+// getHeaderValue returns the first value of a header from a map of normalised headers, or the empty string if it isn't present
+func getHeaderValue(headers map[string][]string, headerName string) string {
+	if headerValues := headers[normaliseHeaderName(headerName)]; len(headerValues) > 0 {
+		return headerValues[0]
+	}
+	return ""
+}
+
+// normaliseHeaders merges single and multi-value headers into a single map of lower-cased header names to values. If a header is present
+// in both, only the values from the multi-value headers are used, which is also how API Gateway merges them. The values of list-valued
+// headers are split into their elements; all other header values are left intact. Every event source's headers should pass through here
+// so that the headers of every log entry are consistent.
+func normaliseHeaders(headers map[string]string, multiValueHeaders map[string][]string) map[string][]string {
+	normalisedHeaders := map[string][]string{}
+	multiValueHeaderNames := map[string]bool{}
+	for headerName, headerValues := range multiValueHeaders {
+		headerName = normaliseHeaderName(headerName)
+		multiValueHeaderNames[headerName] = true
+		for _, headerValue := range headerValues {
+			normalisedHeaders[headerName] = append(normalisedHeaders[headerName], splitHeaderValue(headerName, headerValue)...)
+		}
+	}
+	for headerName, headerValue := range headers {
+		headerName = normaliseHeaderName(headerName)
+		if multiValueHeaderNames[headerName] {
+			continue
+		}
+		normalisedHeaders[headerName] = append(normalisedHeaders[headerName], splitHeaderValue(headerName, headerValue)...)
+	}
+	return normalisedHeaders
+}
+
+// splitHeaderValue splits the value of a list-valued header into its elements, ignoring commas within quoted strings and trimming the
+// whitespace around each element. Empty elements are dropped. The values of all other headers are returned intact, with only their
+// surrounding whitespace trimmed.
+func splitHeaderValue(headerName, headerValue string) []string {
+	if !listValuedHeaders[normaliseHeaderName(headerName)] {
+		return []string{strings.TrimSpace(headerValue)}
+	}
+
+	elements := []string{}
+	inQuotes, escaped := false, false
+	elementStart := 0
+	appendElement := func(element string) {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	for i, char := range headerValue {
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\' && inQuotes:
+			escaped = true
+		case char == '"':
+			inQuotes = !inQuotes
+		case char == ',' && !inQuotes:
+			appendElement(headerValue[elementStart:i])
+			elementStart = i + 1
+		}
+	}
+	appendElement(headerValue[elementStart:])
+
+	return elements
+}
@@ -0,0 +1,57 @@
+package firetail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitHeaderValue(t *testing.T) {
+	testCases := []struct {
+		headerName     string
+		headerValue    string
+		expectedValues []string
+	}{
+		{"Accept-Encoding", "gzip, deflate, br", []string{"gzip", "deflate", "br"}},
+		{"accept", `text/html, application/json;q=0.9, text/plain;foo="a,b"`, []string{"text/html", "application/json;q=0.9", `text/plain;foo="a,b"`}},
+		{"x-forwarded-for", "37.228.214.117, 10.0.0.1,,", []string{"37.228.214.117", "10.0.0.1"}},
+		{"if-none-match", `"abc\",def", W/"xyz"`, []string{`"abc\",def"`, `W/"xyz"`}},
+		{"date", "Wed, 21 Oct 2015 07:28:00 GMT", []string{"Wed, 21 Oct 2015 07:28:00 GMT"}},
+		{"user-agent", "Mozilla/5.0 (KHTML, like Gecko)", []string{"Mozilla/5.0 (KHTML, like Gecko)"}},
+		{"cookie", "a=1, b=2; c=3", []string{"a=1, b=2; c=3"}},
+		{"Set-Cookie", "id=a3fWa; Expires=Thu, 21 Oct 2021 07:28:00 GMT", []string{"id=a3fWa; Expires=Thu, 21 Oct 2021 07:28:00 GMT"}},
+		{"authorization", " Bearer abc,def ", []string{"Bearer abc,def"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.headerName, func(t *testing.T) {
+			assert.Equal(t, testCase.expectedValues, splitHeaderValue(testCase.headerName, testCase.headerValue))
+		})
+	}
+}
+
+func TestNormaliseHeaders(t *testing.T) {
+	normalisedHeaders := normaliseHeaders(
+		map[string]string{
+			"Content-Type": "application/json",
+			"Vary":         "Origin",
+			"Date":         "Wed, 21 Oct 2015 07:28:00 GMT",
+		},
+		map[string][]string{
+			"vary":            {"Accept, Accept-Encoding"},
+			"X-Forwarded-For": {"1.1.1.1, 2.2.2.2", "3.3.3.3"},
+		},
+	)
+	assert.Equal(t,
+		map[string][]string{
+			"content-type":    {"application/json"},
+			"vary":            {"Accept", "Accept-Encoding"},
+			"date":            {"Wed, 21 Oct 2015 07:28:00 GMT"},
+			"x-forwarded-for": {"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+		},
+		normalisedHeaders,
+	)
+}
+
+func TestNormaliseHeadersEmpty(t *testing.T) {
+	assert.Equal(t, map[string][]string{}, normaliseHeaders(nil, nil))
+}
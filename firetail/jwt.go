@@ -0,0 +1,149 @@
+package firetail
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The risks flagged on bearer JWTs
+const (
+	jwtRiskAlgNone         = "alg-none"         // The token isn't signed
+	jwtRiskExpiredAccepted = "expired-accepted" // The token had expired when the request was made, but the request was still successful
+	jwtRiskLongLifetime    = "long-lifetime"    // The token is valid for longer than the JWTInspection's MaxLifetime
+	jwtRiskNoExpiry        = "no-expiry"        // The token never expires
+)
+
+// JWTInspection configures the inspection of JWTs sent as bearer tokens in requests' Authorization headers
+type JWTInspection struct {
+	MaxLifetime time.Duration // Tokens valid for longer than this are flagged as having a long lifetime
+}
+
+// DefaultJWTInspection is the JWTInspection used for all log entries
+var DefaultJWTInspection = &JWTInspection{MaxLifetime: 24 * time.Hour}
+
+// LoadEnvVars configures the JWTInspection from the FIRETAIL_JWT_MAX_LIFETIME env var, which is parsed by time.ParseDuration
+func (j *JWTInspection) LoadEnvVars() error {
+	if maxLifetimeStr, isSet := os.LookupEnv("FIRETAIL_JWT_MAX_LIFETIME"); isSet {
+		maxLifetime, err := time.ParseDuration(maxLifetimeStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_JWT_MAX_LIFETIME invalid")
+		}
+		if maxLifetime <= 0 {
+			return errors.Errorf("FIRETAIL_JWT_MAX_LIFETIME is %s but must be > 0", maxLifetimeStr)
+		}
+		j.MaxLifetime = maxLifetime
+	}
+	return nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// inspectBearerJWT finds the first JWT sent as a bearer token in the log entry's Authorization headers, summarises its header & claims
+// in the log entry's metadata, and redacts it from the headers. The token's signature isn't verified, so its claims are only what the
+// caller presented and aren't used to identify the caller.
+func (j *JWTInspection) inspectBearerJWT(logEntry *LogEntry) {
+	for i, authorizationHeader := range logEntry.Request.Headers["authorization"] {
+		scheme, token, found := strings.Cut(strings.TrimSpace(authorizationHeader), " ")
+		if !found || !strings.EqualFold(scheme, "bearer") {
+			continue
+		}
+		jwtMetadata, ok := j.decodeJWT(strings.TrimSpace(token), logEntry)
+		if !ok {
+			continue
+		}
+		logEntry.Request.Headers["authorization"][i] = maskAuthorizationToken(authorizationHeader)
+		logEntry.Metadata.JWT = jwtMetadata
+		return
+	}
+}
+
+// decodeJWT decodes the header & claims of a JWT without verifying its signature, and flags any risks with the token given the request
+// it was sent with. It returns false if the token isn't a JWT.
+func (j *JWTInspection) decodeJWT(token string, logEntry *LogEntry) (*LogEntryJWTMetadata, bool) {
+	tokenParts := strings.Split(token, ".")
+	if len(tokenParts) != 3 {
+		return nil, false
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(tokenParts[0], "="))
+	if err != nil {
+		return nil, false
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(tokenParts[1], "="))
+	if err != nil {
+		return nil, false
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil || header.Algorithm == "" {
+		return nil, false
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(claimsBytes, &claims); err != nil {
+		return nil, false
+	}
+
+	jwtMetadata := &LogEntryJWTMetadata{
+		Algorithm: header.Algorithm,
+		KeyID:     header.KeyID,
+		Issuer:    getStringClaim(claims, "iss"),
+		Subject:   getStringClaim(claims, "sub"),
+		Audience:  getAudienceFromClaims(claims),
+		ExpiresAt: getNumericDateClaim(claims, "exp"),
+		IssuedAt:  getNumericDateClaim(claims, "iat"),
+		Scopes:    getScopesFromClaims(claims),
+		Risks:     []string{},
+	}
+
+	if strings.EqualFold(header.Algorithm, "none") {
+		jwtMetadata.Risks = append(jwtMetadata.Risks, jwtRiskAlgNone)
+	}
+	if jwtMetadata.ExpiresAt == 0 {
+		jwtMetadata.Risks = append(jwtMetadata.Risks, jwtRiskNoExpiry)
+	} else {
+		requestSucceeded := logEntry.Response.StatusCode > 0 && logEntry.Response.StatusCode < 400
+		if logEntry.DateCreated > 0 && jwtMetadata.ExpiresAt*1000 < logEntry.DateCreated && requestSucceeded {
+			jwtMetadata.Risks = append(jwtMetadata.Risks, jwtRiskExpiredAccepted)
+		}
+		validFrom := jwtMetadata.IssuedAt
+		if notBefore := getNumericDateClaim(claims, "nbf"); notBefore > validFrom {
+			validFrom = notBefore
+		}
+		if validFrom > 0 && time.Duration(jwtMetadata.ExpiresAt-validFrom)*time.Second > j.MaxLifetime {
+			jwtMetadata.Risks = append(jwtMetadata.Risks, jwtRiskLongLifetime)
+		}
+	}
+
+	return jwtMetadata, true
+}
+
+// getAudienceFromClaims returns the aud claim, which may be a single string or a list of strings
+func getAudienceFromClaims(claims map[string]interface{}) []string {
+	switch audience := claims["aud"].(type) {
+	case string:
+		return []string{audience}
+	case []interface{}:
+		audiences := []string{}
+		for _, audienceElement := range audience {
+			if audienceString, ok := audienceElement.(string); ok {
+				audiences = append(audiences, audienceString)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+// getNumericDateClaim returns the value of a NumericDate claim in UNIX seconds, or 0 if it isn't present or isn't a number
+func getNumericDateClaim(claims map[string]interface{}, claimName string) int64 {
+	if numericDate, ok := claims[claimName].(float64); ok {
+		return int64(numericDate)
+	}
+	return 0
+}
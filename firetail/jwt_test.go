@@ -0,0 +1,134 @@
+package firetail
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestJWT(t *testing.T, header, claims map[string]interface{}) string {
+	headerBytes, err := json.Marshal(header)
+	require.Nil(t, err)
+	claimsBytes, err := json.Marshal(claims)
+	require.Nil(t, err)
+	return base64.RawURLEncoding.EncodeToString(headerBytes) + "." + base64.RawURLEncoding.EncodeToString(claimsBytes) + ".c2lnbmF0dXJl"
+}
+
+func getJWTLogEntry(authorizationHeader string, statusCode int64) *LogEntry {
+	return &LogEntry{
+		DateCreated: 1668685315222,
+		Request:     LogEntryRequest{Headers: map[string][]string{"authorization": {authorizationHeader}}},
+		Response:    LogEntryResponse{StatusCode: statusCode},
+	}
+}
+
+func TestInspectBearerJWT(t *testing.T) {
+	token := getTestJWT(t,
+		map[string]interface{}{"alg": "RS256", "kid": "key-1", "typ": "JWT"},
+		map[string]interface{}{
+			"iss":   "https://auth.example.com/",
+			"sub":   "alice",
+			"aud":   []string{"orders", "pets"},
+			"iat":   1668685000,
+			"exp":   1668688600,
+			"scope": "orders:read pets:read",
+		},
+	)
+	logEntry := getJWTLogEntry("Bearer "+token, 200)
+
+	(&JWTInspection{MaxLifetime: 24 * time.Hour}).inspectBearerJWT(logEntry)
+
+	assert.Equal(t, []string{"Bearer " + maskedValue}, logEntry.Request.Headers["authorization"])
+	assert.Equal(t, &LogEntryJWTMetadata{
+		Algorithm: "RS256",
+		KeyID:     "key-1",
+		Issuer:    "https://auth.example.com/",
+		Subject:   "alice",
+		Audience:  []string{"orders", "pets"},
+		ExpiresAt: 1668688600,
+		IssuedAt:  1668685000,
+		Scopes:    []string{"orders:read", "pets:read"},
+		Risks:     []string{},
+	}, logEntry.Metadata.JWT)
+}
+
+func TestInspectBearerJWTRisks(t *testing.T) {
+	testCases := []struct {
+		name          string
+		header        map[string]interface{}
+		claims        map[string]interface{}
+		statusCode    int64
+		expectedRisks []string
+	}{
+		{"AlgNone", map[string]interface{}{"alg": "none"}, map[string]interface{}{"exp": 1668688600}, 200, []string{"alg-none"}},
+		{"ExpiredAccepted", map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"exp": 1668685000}, 200, []string{"expired-accepted"}},
+		{"ExpiredRejected", map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"exp": 1668685000}, 401, []string{}},
+		{"LongLifetime", map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"iat": 1668685000, "exp": 1668685000 + 30*24*60*60}, 200, []string{"long-lifetime"}},
+		{"NotBeforeShortensLifetime", map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"iat": 1600000000, "nbf": 1668685000, "exp": 1668688600}, 200, []string{}},
+		{"NoExpiry", map[string]interface{}{"alg": "None"}, map[string]interface{}{"sub": "alice"}, 200, []string{"alg-none", "no-expiry"}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			logEntry := getJWTLogEntry("Bearer "+getTestJWT(t, testCase.header, testCase.claims), testCase.statusCode)
+			(&JWTInspection{MaxLifetime: 24 * time.Hour}).inspectBearerJWT(logEntry)
+			require.NotNil(t, logEntry.Metadata.JWT)
+			assert.Equal(t, testCase.expectedRisks, logEntry.Metadata.JWT.Risks)
+		})
+	}
+}
+
+func TestInspectBearerJWTNotJWT(t *testing.T) {
+	for _, authorizationHeader := range []string{"Bearer opaque-token", "Basic dXNlcjpwYXNz", "Bearer a.b.c", "Bearer e30.e30.c2ln"} {
+		t.Run(authorizationHeader, func(t *testing.T) {
+			logEntry := getJWTLogEntry(authorizationHeader, 200)
+			(&JWTInspection{MaxLifetime: 24 * time.Hour}).inspectBearerJWT(logEntry)
+			assert.Nil(t, logEntry.Metadata.JWT)
+			assert.Equal(t, []string{authorizationHeader}, logEntry.Request.Headers["authorization"])
+		})
+	}
+}
+
+func TestGetLogEntryInspectsBearerJWT(t *testing.T) {
+	defaultIdentifierHashing := *DefaultIdentifierHashing
+	defer func() { *DefaultIdentifierHashing = defaultIdentifierHashing }()
+	DefaultIdentifierHashing.Algorithm = IdentifierHashingSHA256
+
+	token := getTestJWT(t, map[string]interface{}{"alg": "RS256"}, map[string]interface{}{"sub": "alice", "exp": 1668688600})
+	apiGatewayV2HTTPRequest := getNewAPIGatewayV2HTTPRequest()
+	apiGatewayV2HTTPRequest.Headers["Authorization"] = "Bearer " + token
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(apiGatewayV2HTTPRequest)
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes), Response: RecordResponse{StatusCode: 200}}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	require.NotNil(t, logEntry.Metadata.JWT)
+	assert.Equal(t, "sha256:2bd806c97f0e00af1a1fc3328fa763a9269723c8db8fac4f93af71db186d6e90", logEntry.Metadata.JWT.Subject)
+	assert.Equal(t, []string{"Bearer " + maskedValue}, logEntry.Request.Headers["authorization"])
+
+	logEntryBytes, err := json.Marshal(logEntry)
+	require.Nil(t, err)
+	assert.NotContains(t, string(logEntryBytes), token)
+}
+
+func TestJWTInspectionLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_JWT_MAX_LIFETIME", "1h30m")
+	jwtInspection := &JWTInspection{}
+	require.Nil(t, jwtInspection.LoadEnvVars())
+	assert.Equal(t, 90*time.Minute, jwtInspection.MaxLifetime)
+
+	t.Setenv("FIRETAIL_JWT_MAX_LIFETIME", "a while")
+	err := jwtInspection.LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "FIRETAIL_JWT_MAX_LIFETIME invalid")
+
+	t.Setenv("FIRETAIL_JWT_MAX_LIFETIME", "-1h")
+	err = jwtInspection.LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_JWT_MAX_LIFETIME is -1h but must be > 0", err.Error())
+}
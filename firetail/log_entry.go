@@ -1,4 +1,6 @@
-// This file was generated from JSON Schema using quicktype, do not modify it directly.
+// This file was originally generated from the 1.0.0-alpha JSON Schema using quicktype. The fields added since are versioned by
+// LogEntryVersion & described in the README's Log Entry Schema section, so any change to the wire format must bump the version & update
+// the README.
 // To parse and unparse this JSON data, add this code to your project and do:
 //
 //    logEntry, err := UnmarshalLogEntry(bytes)
@@ -20,6 +22,7 @@ func (r *LogEntry) Marshal() ([]byte, error) {
 
 // All the information required to make a logging entry in Firetail
 type LogEntry struct {
+	ID            string           `json:"id,omitempty"`  // A stable ID for the record the log entry was created from, which Firetail can deduplicate log entries by
 	DateCreated   int64            `json:"dateCreated"`   // The time the request was logged in UNIX milliseconds
 	ExecutionTime float64          `json:"executionTime"` // The time elapsed during the execution required to respond to the request, in milliseconds
 	Request       LogEntryRequest  `json:"request"`
@@ -29,19 +32,25 @@ type LogEntry struct {
 }
 
 type LogEntryRequest struct {
-	Body         string               `json:"body"`         // The request body, stringified
-	Headers      map[string][]string  `json:"headers"`      // The request headers
-	HTTPProtocol LogEntryHTTPProtocol `json:"httpProtocol"` // The HTTP protocol used in the request
-	IP           string               `json:"ip"`           // The source IP of the request
-	Method       LogEntryMethod       `json:"method"`       // The request method. Src for allowed values can be found here: <a; href='https://www.iana.org/assignments/http-methods/http-methods.xhtml#methods'>https://www.iana.org/assignments/http-methods/http-methods.xhtml#methods</a>.
-	URI          string               `json:"uri"`          // The URI the request was made to
-	Resource     string               `json:"resource"`     // The resource path that the request matched up to in the OpenAPI spec
+	Body            string               `json:"body"`                      // The request body, stringified
+	Headers         map[string][]string  `json:"headers"`                   // The request headers
+	HTTPProtocol    LogEntryHTTPProtocol `json:"httpProtocol"`              // The HTTP protocol used in the request
+	IP              string               `json:"ip"`                        // The source IP of the request
+	Method          LogEntryMethod       `json:"method"`                    // The request method. Src for allowed values can be found here: <a; href='https://www.iana.org/assignments/http-methods/http-methods.xhtml#methods'>https://www.iana.org/assignments/http-methods/http-methods.xhtml#methods</a>.
+	URI             string               `json:"uri"`                       // The URI the request was made to
+	Resource        string               `json:"resource"`                  // The resource path that the request matched up to in the OpenAPI spec
+	IsBase64Encoded bool                 `json:"isBase64Encoded,omitempty"` // Whether the request body is binary data which has been base64 encoded
+	Truncated       bool                 `json:"truncated,omitempty"`       // Whether the body was truncated to fit the log entry within the max log entry size
+	BodySize        int                  `json:"bodySize,omitempty"`        // The size of the body in bytes before it was truncated
 }
 
 type LogEntryResponse struct {
-	Body       string              `json:"body"`    // The response body, stringified
-	Headers    map[string][]string `json:"headers"` // The response headers
-	StatusCode int64               `json:"statusCode"`
+	Body            string              `json:"body"`    // The response body, stringified
+	Headers         map[string][]string `json:"headers"` // The response headers
+	StatusCode      int64               `json:"statusCode"`
+	IsBase64Encoded bool                `json:"isBase64Encoded,omitempty"` // Whether the response body is binary data which has been base64 encoded
+	Truncated       bool                `json:"truncated,omitempty"`       // Whether the body was truncated to fit the log entry within the max log entry size
+	BodySize        int                 `json:"bodySize,omitempty"`        // The size of the body in bytes before it was truncated
 }
 
 // The HTTP protocol used in the request
@@ -105,9 +114,129 @@ const (
 type LogEntryVersion string
 
 type LogEntryMetadata struct {
-	Source string `json:"source"`
+	Source         string                          `json:"source"`
+	Caller         *LogEntryCallerMetadata         `json:"caller,omitempty"`         // The identity of the authenticated caller, if the event source states it
+	Consumer       *LogEntryConsumerMetadata       `json:"consumer,omitempty"`       // The API key & client certificate presented, if any
+	GraphQL        *LogEntryGraphQLMetadata        `json:"graphql,omitempty"`        // The GraphQL operations requested, if GraphQL detection is enabled
+	JWT            *LogEntryJWTMetadata            `json:"jwt,omitempty"`            // The unverified header & claims of the JWT sent as a bearer token, if any
+	VPCLattice     *LogEntryVPCLatticeMetadata     `json:"vpcLattice,omitempty"`     // Details of the VPC Lattice service & caller, if the request came via VPC Lattice
+	CloudFront     *LogEntryCloudFrontMetadata     `json:"cloudFront,omitempty"`     // Details of the CloudFront distribution & trigger, if the function is a Lambda@Edge function
+	S3ObjectLambda *LogEntryS3ObjectLambdaMetadata `json:"s3ObjectLambda,omitempty"` // Details of the access point & caller, if the function is an S3 Object Lambda
+	Event          *LogEntryEventMetadata          `json:"event,omitempty"`          // A summary of the event, if the function was invoked by a non-HTTP event source
+	Authorizer     *LogEntryAuthorizerMetadata     `json:"authorizer,omitempty"`     // The authorization decision, if the function is an API Gateway Lambda authorizer
+	BedrockAgent   *LogEntryBedrockAgentMetadata   `json:"bedrockAgent,omitempty"`   // Details of the agent & session, if the function is a Bedrock Agent action group
+}
+
+type LogEntryBedrockAgentMetadata struct {
+	AgentID      string `json:"agentId"`
+	AgentName    string `json:"agentName,omitempty"`
+	AgentAlias   string `json:"agentAlias,omitempty"`
+	AgentVersion string `json:"agentVersion,omitempty"`
+	SessionID    string `json:"sessionId,omitempty"`
+	ActionGroup  string `json:"actionGroup,omitempty"`
+}
+
+type LogEntryAuthorizerMetadata struct {
+	Type               string   `json:"type"`                         // TOKEN or REQUEST
+	MethodArn          string   `json:"methodArn"`                    // The method ARN, or route ARN for HTTP APIs, of the request being authorized
+	AuthorizationToken string   `json:"authorizationToken,omitempty"` // The masked authorization token of a TOKEN authorizer
+	PrincipalID        string   `json:"principalId,omitempty"`        // The principal ID the authorizer identified the caller as, hashed like the caller's identifiers
+	Effect             string   `json:"effect,omitempty"`             // Allow or Deny
+	ContextKeys        []string `json:"contextKeys"`                  // The keys of the context the authorizer passes on to the integration; their values aren't logged
+}
+
+type LogEntryCallerMetadata struct {
+	Type         string   `json:"type"`                   // How the caller was authenticated: cognito, jwt, iam, lambda or apiKey
+	Subject      string   `json:"subject,omitempty"`      // The subject of the caller's token, or the principal ID given by a Lambda authorizer
+	Issuer       string   `json:"issuer,omitempty"`       // The issuer of the caller's token
+	Scopes       []string `json:"scopes,omitempty"`       // The scopes granted to the caller's token
+	APIKeyID     string   `json:"apiKeyId,omitempty"`     // The ID of the API Gateway API key used by the caller
+	IAMPrincipal string   `json:"iamPrincipal,omitempty"` // The ARN of the caller's IAM principal
+	AccountID    string   `json:"accountId,omitempty"`    // The AWS account ID of the caller's IAM principal
+}
+
+type LogEntryConsumerMetadata struct {
+	APIKeyID   string                      `json:"apiKeyId,omitempty"`   // The ID of the API Gateway API key presented by the consumer
+	ClientCert *LogEntryClientCertMetadata `json:"clientCert,omitempty"` // The client certificate presented by the consumer, if mutual TLS is enabled
+}
+
+type LogEntryClientCertMetadata struct {
+	SubjectDN    string `json:"subjectDN,omitempty"`    // The distinguished name of the certificate's subject
+	IssuerDN     string `json:"issuerDN,omitempty"`     // The distinguished name of the certificate's issuer
+	SerialNumber string `json:"serialNumber,omitempty"` // The serial number of the certificate
+	NotBefore    int64  `json:"notBefore,omitempty"`    // The time the certificate is valid from in UNIX milliseconds
+	NotAfter     int64  `json:"notAfter,omitempty"`     // The time the certificate expires in UNIX milliseconds
+	ExpiresSoon  bool   `json:"expiresSoon"`            // Whether the certificate expires within the configured warning period of the request
+}
+
+type LogEntryJWTMetadata struct {
+	Algorithm string   `json:"algorithm"`           // The alg of the token's header
+	KeyID     string   `json:"keyId,omitempty"`     // The kid of the token's header
+	Issuer    string   `json:"issuer,omitempty"`    // The iss claim
+	Subject   string   `json:"subject,omitempty"`   // The sub claim
+	Audience  []string `json:"audience,omitempty"`  // The aud claim
+	ExpiresAt int64    `json:"expiresAt,omitempty"` // The exp claim in UNIX seconds
+	IssuedAt  int64    `json:"issuedAt,omitempty"`  // The iat claim in UNIX seconds
+	Scopes    []string `json:"scopes,omitempty"`    // The scope or scp claim
+	Risks     []string `json:"risks"`               // Risks found with the token: alg-none, expired-accepted, long-lifetime or no-expiry
+}
+
+type LogEntryGraphQLMetadata struct {
+	Operations []LogEntryGraphQLOperation `json:"operations"`
+	Batched    bool                       `json:"batched,omitempty"`    // Whether the operations were sent as a batch in an array
+	HasErrors  bool                       `json:"hasErrors"`            // Whether the GraphQL response has errors, regardless of its status code
+	ErrorCount int                        `json:"errorCount,omitempty"` // The number of errors in the GraphQL response, or all the responses to a batch
+}
+
+type LogEntryGraphQLOperation struct {
+	Name               string   `json:"name,omitempty"`
+	Type               string   `json:"type,omitempty"`               // query, mutation or subscription; unknown for persisted queries sent without their query
+	Fields             []string `json:"fields,omitempty"`             // The top-level fields selected by the operation
+	PersistedQueryHash string   `json:"persistedQueryHash,omitempty"` // The SHA-256 hash of an automatic persisted query
+}
+
+type LogEntryEventMetadata struct {
+	Source      string   `json:"source"`               // The event source, e.g. aws:sqs, aws:sns, aws:events, aws:kinesis, aws:dynamodb or aws:s3
+	SourceARN   string   `json:"sourceArn,omitempty"`  // The ARN of the queue, topic, stream, table, bucket or resource the event came from
+	DetailType  string   `json:"detailType,omitempty"` // The detail-type of an EventBridge event
+	RecordCount int      `json:"recordCount"`
+	MessageIDs  []string `json:"messageIds"` // The IDs of the messages in the event; for streams, their sequence numbers
+	// ReportsBatchItemFailures is true if the function returned a batch response, in which case FailedMessageIDs are the IDs of the
+	// messages (or sequence numbers of the stream records) it reported as failed, which Lambda will retry
+	ReportsBatchItemFailures bool           `json:"reportsBatchItemFailures,omitempty"`
+	FailedMessageIDs         []string       `json:"failedMessageIds,omitempty"`
+	ReceiveCounts            map[string]int `json:"receiveCounts,omitempty"` // The number of times each SQS message has been received
+}
+
+type LogEntryVPCLatticeMetadata struct {
+	ServiceNetworkArn string `json:"serviceNetworkArn,omitempty"`
+	ServiceArn        string `json:"serviceArn,omitempty"`
+	TargetGroupArn    string `json:"targetGroupArn,omitempty"`
+	Region            string `json:"region,omitempty"`
+	IdentityType      string `json:"identityType,omitempty"`   // The type of authentication used by the caller, e.g. AWS_IAM
+	Principal         string `json:"principal,omitempty"`      // The ARN of the authenticated IAM principal which made the request
+	PrincipalOrgID    string `json:"principalOrgId,omitempty"` // The ID of the AWS organization of the authenticated principal
+	SessionName       string `json:"sessionName,omitempty"`
+	SourceVpcArn      string `json:"sourceVpcArn,omitempty"` // The ARN of the VPC the request originated from, or its ID for V1 events
+}
+
+type LogEntryCloudFrontMetadata struct {
+	DistributionID         string `json:"distributionId,omitempty"`
+	DistributionDomainName string `json:"distributionDomainName,omitempty"`
+	EventType              string `json:"eventType,omitempty"` // The trigger which invoked the function: viewer-request, origin-request, origin-response or viewer-response
+	RequestID              string `json:"requestId,omitempty"`
+}
+
+type LogEntryS3ObjectLambdaMetadata struct {
+	AccessPointArn           string `json:"accessPointArn,omitempty"`           // The ARN of the Object Lambda Access Point the request was made to
+	SupportingAccessPointArn string `json:"supportingAccessPointArn,omitempty"` // The ARN of the access point the original object is fetched from
+	RequestID                string `json:"requestId,omitempty"`
+	UserIdentityType         string `json:"userIdentityType,omitempty"`
+	PrincipalID              string `json:"principalId,omitempty"`
+	UserArn                  string `json:"userArn,omitempty"`
 }
 
 const (
 	The100Alpha LogEntryVersion = "1.0.0-alpha"
+	The110Alpha LogEntryVersion = "1.1.0-alpha" // Adds the id field, the body encoding & truncation fields, and the optional metadata
 )
@@ -30,7 +30,7 @@ func TestEncodeAndDecodeLogEntry(t *testing.T) {
 			},
 			StatusCode: 200,
 		},
-		Version: The100Alpha,
+		Version: The110Alpha,
 		Metadata: LogEntryMetadata{
 			Source: "lambda-extension",
 		},
@@ -0,0 +1,84 @@
+package firetail
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// LogEntryTruncation configures the maximum size of a serialised log entry. Log entries larger than this have their request & response
+// bodies truncated, largest first, and marked as truncated, rather than failing the whole batch they're sent in.
+type LogEntryTruncation struct {
+	MaxBytes int
+}
+
+// DefaultLogEntryTruncation is the LogEntryTruncation used for all log entries
+var DefaultLogEntryTruncation = &LogEntryTruncation{MaxBytes: 512 * 1024}
+
+// LoadEnvVars configures the LogEntryTruncation from the FIRETAIL_MAX_LOG_ENTRY_BYTES env var
+func (t *LogEntryTruncation) LoadEnvVars() error {
+	if maxBytesStr, isSet := os.LookupEnv("FIRETAIL_MAX_LOG_ENTRY_BYTES"); isSet {
+		maxBytes, err := strconv.Atoi(maxBytesStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_MAX_LOG_ENTRY_BYTES invalid")
+		}
+		if maxBytes < 1024 {
+			return errors.Errorf("FIRETAIL_MAX_LOG_ENTRY_BYTES is %d but must be >= 1024", maxBytes)
+		}
+		t.MaxBytes = maxBytes
+	}
+	return nil
+}
+
+// marshalLogEntry returns the log entry marshalled to JSON, with its bodies truncated if necessary to fit within the MaxBytes. It returns
+// an err if the log entry is still too large once both of its bodies have been truncated entirely.
+func (t *LogEntryTruncation) marshalLogEntry(logEntry *LogEntry) ([]byte, error) {
+	for {
+		logEntryBytes, err := json.Marshal(logEntry)
+		if err != nil {
+			return nil, err
+		}
+		excessBytes := len(logEntryBytes) - t.MaxBytes
+		if excessBytes <= 0 {
+			return logEntryBytes, nil
+		}
+
+		// Removing a byte from a body removes at least one byte from its JSON string, so truncating the larger body by the excess bytes
+		// makes progress, though the truncation markers themselves may need another pass
+		body, bodySize, truncated := &logEntry.Request.Body, &logEntry.Request.BodySize, &logEntry.Request.Truncated
+		isBase64Encoded := logEntry.Request.IsBase64Encoded
+		if len(logEntry.Response.Body) > len(logEntry.Request.Body) {
+			body, bodySize, truncated = &logEntry.Response.Body, &logEntry.Response.BodySize, &logEntry.Response.Truncated
+			isBase64Encoded = logEntry.Response.IsBase64Encoded
+		}
+		if len(*body) == 0 {
+			return nil, errors.Errorf("Log entry is %d bytes with its bodies truncated, which exceeds the max of %d", len(logEntryBytes), t.MaxBytes)
+		}
+		if !*truncated {
+			*truncated = true
+			*bodySize = len(*body)
+		}
+		*body = truncateBody(*body, len(*body)-excessBytes, isBase64Encoded)
+	}
+}
+
+// truncateBody returns the body truncated to at most maxLength bytes. Text bodies are truncated at the start of a UTF-8 character, and
+// base64 encoded bodies are truncated to a multiple of 4 characters so that what remains can still be decoded.
+func truncateBody(body string, maxLength int, isBase64Encoded bool) string {
+	if maxLength <= 0 {
+		return ""
+	}
+	if maxLength >= len(body) {
+		return body
+	}
+	if isBase64Encoded {
+		return body[:maxLength-maxLength%4]
+	}
+	for maxLength > 0 && !utf8.RuneStart(body[maxLength]) {
+		maxLength--
+	}
+	return body[:maxLength]
+}
@@ -0,0 +1,238 @@
+package firetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalLogEntryWithinMaxBytes(t *testing.T) {
+	logEntry := &LogEntry{Request: LogEntryRequest{Body: "hello"}, Response: LogEntryResponse{Body: "world"}}
+	expectedBytes, err := json.Marshal(logEntry)
+	require.Nil(t, err)
+
+	logEntryBytes, err := (&LogEntryTruncation{MaxBytes: 1024}).marshalLogEntry(logEntry)
+	require.Nil(t, err)
+	assert.Equal(t, string(expectedBytes), string(logEntryBytes))
+	assert.False(t, logEntry.Request.Truncated)
+	assert.False(t, logEntry.Response.Truncated)
+}
+
+func TestMarshalLogEntryTruncatesLargestBody(t *testing.T) {
+	logEntry := &LogEntry{
+		Request:  LogEntryRequest{Body: strings.Repeat("a", 100)},
+		Response: LogEntryResponse{Body: strings.Repeat("b", 4000)},
+	}
+
+	logEntryBytes, err := (&LogEntryTruncation{MaxBytes: 1024}).marshalLogEntry(logEntry)
+	require.Nil(t, err)
+	assert.LessOrEqual(t, len(logEntryBytes), 1024)
+
+	var unmarshalledLogEntry LogEntry
+	require.Nil(t, json.Unmarshal(logEntryBytes, &unmarshalledLogEntry))
+	assert.Equal(t, strings.Repeat("a", 100), unmarshalledLogEntry.Request.Body)
+	assert.False(t, unmarshalledLogEntry.Request.Truncated)
+	assert.True(t, unmarshalledLogEntry.Response.Truncated)
+	assert.Equal(t, 4000, unmarshalledLogEntry.Response.BodySize)
+	assert.True(t, strings.HasPrefix(strings.Repeat("b", 4000), unmarshalledLogEntry.Response.Body))
+	assert.Greater(t, len(unmarshalledLogEntry.Response.Body), 0)
+}
+
+func TestMarshalLogEntryTruncatesBase64RequestBody(t *testing.T) {
+	logEntry := &LogEntry{Request: LogEntryRequest{Body: strings.Repeat("QUJD", 1000), IsBase64Encoded: true}}
+
+	logEntryBytes, err := (&LogEntryTruncation{MaxBytes: 1024}).marshalLogEntry(logEntry)
+	require.Nil(t, err)
+
+	var unmarshalledLogEntry LogEntry
+	require.Nil(t, json.Unmarshal(logEntryBytes, &unmarshalledLogEntry))
+	assert.True(t, unmarshalledLogEntry.Request.Truncated)
+	assert.Equal(t, 0, len(unmarshalledLogEntry.Request.Body)%4)
+}
+
+func TestMarshalLogEntryTruncatesBothBodies(t *testing.T) {
+	logEntry := &LogEntry{
+		Request:  LogEntryRequest{Body: strings.Repeat("a", 3000)},
+		Response: LogEntryResponse{Body: strings.Repeat("b", 3000)},
+	}
+
+	logEntryBytes, err := (&LogEntryTruncation{MaxBytes: 1024}).marshalLogEntry(logEntry)
+	require.Nil(t, err)
+	assert.LessOrEqual(t, len(logEntryBytes), 1024)
+	assert.True(t, logEntry.Request.Truncated)
+	assert.Equal(t, 3000, logEntry.Request.BodySize)
+	assert.True(t, logEntry.Response.Truncated)
+	assert.Equal(t, 3000, logEntry.Response.BodySize)
+}
+
+func TestMarshalLogEntryTooLargeWithoutBodies(t *testing.T) {
+	logEntry := &LogEntry{Request: LogEntryRequest{
+		Body:    "hello",
+		Headers: map[string][]string{"x-large": {strings.Repeat("a", 2000)}},
+	}}
+
+	_, err := (&LogEntryTruncation{MaxBytes: 1024}).marshalLogEntry(logEntry)
+	require.NotNil(t, err)
+	assert.Regexp(t, "^Log entry is [0-9]+ bytes with its bodies truncated, which exceeds the max of 1024$", err.Error())
+}
+
+func TestTruncateBody(t *testing.T) {
+	assert.Equal(t, "hello", truncateBody("hello", 10, false))
+	assert.Equal(t, "hel", truncateBody("hello", 3, false))
+	assert.Equal(t, "", truncateBody("hello", -3, false))
+	// "é" is 2 bytes, so it's dropped rather than split
+	assert.Equal(t, "h", truncateBody("hé", 2, false))
+	assert.Equal(t, "aGVs", truncateBody("aGVsbG8=", 7, true))
+}
+
+// limitRequestsToLogEntries sets the DefaultBatchLimits' MaxBytes so that each request can fit the provided number of the test record's
+// log entries, until the test completes
+func limitRequestsToLogEntries(t *testing.T, testRecord Record, logEntries int) {
+	defaultBatchLimits := *DefaultBatchLimits
+	t.Cleanup(func() { *DefaultBatchLimits = defaultBatchLimits })
+
+	testLogEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	testLogEntry.ID = testRecord.ID()
+	testLogEntryBytes, err := json.Marshal(testLogEntry)
+	require.Nil(t, err)
+	DefaultBatchLimits.MaxBytes = logEntries*(len(testLogEntryBytes)+1) + 1
+}
+
+func TestSendRecordsToSaaSSplitsRequests(t *testing.T) {
+	requestBodies := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		requestBodies = append(requestBodies, string(body))
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	// Each request can fit two log entries
+	testRecord := getValidRecord(t)
+	limitRequestsToLogEntries(t, testRecord, 2)
+	result, err := SendRecordsToSaaS([]Record{testRecord, testRecord, testRecord, testRecord, testRecord}, testServer.URL, "")
+	require.Nil(t, err)
+	assert.Equal(t, 5, result.Sent)
+	require.Len(t, requestBodies, 3)
+	assert.Equal(t, 2, strings.Count(requestBodies[0], "\n"))
+	assert.Equal(t, 2, strings.Count(requestBodies[1], "\n"))
+	assert.Equal(t, 1, strings.Count(requestBodies[2], "\n"))
+}
+
+func TestSendRecordsToSaaSDropsPermanentlyFailedRequest(t *testing.T) {
+	requests := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	testRecord := getValidRecord(t)
+	limitRequestsToLogEntries(t, testRecord, 2)
+	result, err := SendRecordsToSaaS([]Record{testRecord, testRecord, testRecord, testRecord, testRecord}, testServer.URL, "")
+
+	// Only the records in the second request are dropped, and the records which were delivered aren't dead-lettered with them
+	require.Nil(t, err)
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, 3, result.Sent)
+	require.Len(t, result.RecordErrs, 2)
+	assert.False(t, isRetriable(result.RecordErrs[0]))
+	assert.Equal(t, "Err sending record "+testRecord.ID()+", err: Got 400 response from firetail api", result.RecordErrs[0].Error())
+}
+
+func TestSendRecordsToSaaSSplitsTooLargeRequest(t *testing.T) {
+	requestBodies := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		requestBodies = append(requestBodies, string(body))
+		// Firetail only accepts requests with a single log entry
+		if strings.Count(string(body), "\n") > 1 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	testRecord := getValidRecord(t)
+	limitRequestsToLogEntries(t, testRecord, 2)
+	result, err := SendRecordsToSaaS([]Record{testRecord, testRecord, testRecord}, testServer.URL, "")
+
+	require.Nil(t, err)
+	assert.Equal(t, 3, result.Sent)
+	assert.Empty(t, result.RecordErrs)
+	// The first request of two log entries is split in half, and the second request has a single log entry
+	require.Len(t, requestBodies, 4)
+	assert.Equal(t, 2, strings.Count(requestBodies[0], "\n"))
+	assert.Equal(t, 1, strings.Count(requestBodies[1], "\n"))
+	assert.Equal(t, 1, strings.Count(requestBodies[2], "\n"))
+	assert.Equal(t, 1, strings.Count(requestBodies[3], "\n"))
+}
+
+func TestSendRecordsToSaaSAuthErrorAfterDelivery(t *testing.T) {
+	requests := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	testRecord := getValidRecord(t)
+	limitRequestsToLogEntries(t, testRecord, 2)
+	result, err := SendRecordsToSaaS([]Record{testRecord, testRecord, testRecord, testRecord, testRecord}, testServer.URL, "")
+
+	// No further requests are made once the API token is rejected, so the records in the remaining requests are dropped
+	require.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 2, result.Sent)
+	assert.Len(t, result.RecordErrs, 3)
+	assert.True(t, sendingDisabled(testServer.URL, ""))
+}
+
+func TestSendRecordsToSaaSNoRequestDelivered(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer testServer.Close()
+
+	testRecord := getValidRecord(t)
+	limitRequestsToLogEntries(t, testRecord, 2)
+	result, err := SendRecordsToSaaS([]Record{testRecord, testRecord, testRecord}, testServer.URL, "")
+
+	// If none of the requests were delivered, the batch fails as a whole
+	require.NotNil(t, err)
+	assert.False(t, isRetriable(err))
+	assert.Equal(t, "Got 400 response from firetail api", err.Error())
+	assert.Equal(t, 0, result.Sent)
+	assert.Empty(t, result.RecordErrs)
+}
+
+func TestLogEntryTruncationLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_MAX_LOG_ENTRY_BYTES", "65536")
+	logEntryTruncation := &LogEntryTruncation{}
+	require.Nil(t, logEntryTruncation.LoadEnvVars())
+	assert.Equal(t, &LogEntryTruncation{MaxBytes: 65536}, logEntryTruncation)
+
+	t.Setenv("FIRETAIL_MAX_LOG_ENTRY_BYTES", "100")
+	err := (&LogEntryTruncation{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_MAX_LOG_ENTRY_BYTES is 100 but must be >= 1024", err.Error())
+}
@@ -0,0 +1,111 @@
+package firetail
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuthError is returned when the Firetail API responds with a 401 or 403, which means the API token is missing, invalid, or not valid for
+// the API URL. Once it has been returned for an API URL & token, sending to it is disabled & SendingDisabledError is returned instead.
+type AuthError struct {
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("Got %d response from firetail api, check FIRETAIL_API_TOKEN is a valid API token for FIRETAIL_API_URL", e.StatusCode)
+}
+
+// SendingDisabledError is returned instead of making a request to the Firetail API after it has responded to a request to the same API
+// URL with the same API token with a 401 or 403
+type SendingDisabledError struct{}
+
+func (e *SendingDisabledError) Error() string {
+	return "Sending to firetail api is disabled as it rejected the API token"
+}
+
+// RequestTooLargeError is returned when the Firetail API responds with a 413
+type RequestTooLargeError struct {
+	RequestBytes int
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("Got 413 response from firetail api for a %d byte request, FIRETAIL_MAX_BATCH_BYTES may need lowering", e.RequestBytes)
+}
+
+// RateLimitedError is returned when the Firetail API responds with a 429
+type RateLimitedError struct{}
+
+func (e *RateLimitedError) Error() string {
+	return "Got 429 response from firetail api"
+}
+
+// ServerError is returned when the Firetail API responds with a 5xx
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("Got %d response from firetail api", e.StatusCode)
+}
+
+// RejectedLogEntryError is returned for each log entry in a request which the Firetail API reported it rejected, while accepting the rest
+type RejectedLogEntryError struct {
+	Line   int // The line of the log entry in the request, from 1
+	Reason string
+}
+
+func (e *RejectedLogEntryError) Error() string {
+	return fmt.Sprintf("Firetail api rejected log entry on line %d of request, reason: %s", e.Line, e.Reason)
+}
+
+// loggingAPIResponse is the body of a response from the Firetail logging API. If some of the log entries in a request were rejected, the
+// API may respond with a 2xx & list them by their line in the request, from 1, in which case the rest of the log entries were accepted.
+type loggingAPIResponse struct {
+	Message  string `json:"message"`
+	Rejected []struct {
+		Line   int    `json:"line"`
+		Reason string `json:"reason"`
+	} `json:"rejected"`
+}
+
+// getStatusCodeError returns the error for a response from the Firetail API to a request of the provided size, or nil if its status code
+// isn't an error. Rate limiting & server errors are retriable, but all other errors are permanent.
+func getStatusCodeError(resp *http.Response, requestBytes int) error {
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &PermanentError{&AuthError{StatusCode: resp.StatusCode}}
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return &PermanentError{&RequestTooLargeError{RequestBytes: requestBytes}}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &RetriableError{Err: &RateLimitedError{}, RetryAfter: retryAfter}
+	case resp.StatusCode >= 500:
+		return &RetriableError{Err: &ServerError{StatusCode: resp.StatusCode}, RetryAfter: retryAfter}
+	case resp.StatusCode >= 400:
+		return &PermanentError{fmt.Errorf("Got %d response from firetail api", resp.StatusCode)}
+	}
+	return nil
+}
+
+// disabledSenders holds the API URL & token pairs which the Firetail API has responded to with a 401 or 403
+var disabledSenders = sync.Map{}
+
+type sender struct {
+	apiUrl, apiKey string
+}
+
+// disableSending disables sending to the API URL with the API token, logging why the first time it's disabled
+func disableSending(apiUrl, apiKey string, authErr *AuthError) {
+	if _, alreadyDisabled := disabledSenders.LoadOrStore(sender{apiUrl, apiKey}, struct{}{}); !alreadyDisabled {
+		log.Printf("Disabling sending records to %s as %s. Records will be dropped until the extension is restarted.", apiUrl, authErr.Error())
+	}
+}
+
+// sendingDisabled returns true if sending to the API URL with the API token has been disabled
+func sendingDisabled(apiUrl, apiKey string) bool {
+	_, disabled := disabledSenders.Load(sender{apiUrl, apiKey})
+	return disabled
+}
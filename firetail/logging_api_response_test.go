@@ -0,0 +1,112 @@
+package firetail
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getStatusCodeTestServer(statusCode int, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(statusCode)
+	}))
+}
+
+func TestSendRecordsToSaaSStatusCodeErrors(t *testing.T) {
+	for _, testCase := range []struct {
+		statusCode    int
+		isRetriable   bool
+		expectedErr   error
+		expectedRetry time.Duration
+	}{
+		{http.StatusRequestEntityTooLarge, false, &RequestTooLargeError{}, 0},
+		{http.StatusTooManyRequests, true, &RateLimitedError{}, 3 * time.Second},
+		{http.StatusInternalServerError, true, &ServerError{}, 3 * time.Second},
+		{http.StatusBadGateway, true, &ServerError{}, 3 * time.Second},
+		{http.StatusBadRequest, false, nil, 0},
+	} {
+		t.Run(fmt.Sprint(testCase.statusCode), func(t *testing.T) {
+			requests := 0
+			testServer := getStatusCodeTestServer(testCase.statusCode, &requests)
+			defer testServer.Close()
+
+			_, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "")
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprintf("Got %d response from firetail api", testCase.statusCode))
+			assert.Equal(t, testCase.isRetriable, isRetriable(err))
+			assert.Equal(t, testCase.expectedRetry, getRetryAfter(err))
+			switch testCase.expectedErr.(type) {
+			case *RequestTooLargeError:
+				var requestTooLargeErr *RequestTooLargeError
+				require.True(t, errors.As(err, &requestTooLargeErr))
+				assert.Greater(t, requestTooLargeErr.RequestBytes, 0)
+			case *RateLimitedError:
+				var rateLimitedErr *RateLimitedError
+				assert.True(t, errors.As(err, &rateLimitedErr))
+			case *ServerError:
+				var serverErr *ServerError
+				require.True(t, errors.As(err, &serverErr))
+				assert.Equal(t, testCase.statusCode, serverErr.StatusCode)
+			}
+		})
+	}
+}
+
+func TestSendRecordsToSaaSAuthErrorDisablesSending(t *testing.T) {
+	for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		t.Run(fmt.Sprint(statusCode), func(t *testing.T) {
+			requests := 0
+			testServer := getStatusCodeTestServer(statusCode, &requests)
+			defer testServer.Close()
+
+			result, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "invalid-token")
+			assert.Equal(t, 0, result.Sent)
+			require.NotNil(t, err)
+			assert.False(t, isRetriable(err))
+			var authErr *AuthError
+			require.True(t, errors.As(err, &authErr))
+			assert.Equal(t, statusCode, authErr.StatusCode)
+			assert.Contains(t, err.Error(), "check FIRETAIL_API_TOKEN is a valid API token for FIRETAIL_API_URL")
+
+			// No further requests are made with the same token
+			result, err = SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "invalid-token")
+			assert.Equal(t, 0, result.Sent)
+			require.NotNil(t, err)
+			assert.False(t, isRetriable(err))
+			var sendingDisabledErr *SendingDisabledError
+			assert.True(t, errors.As(err, &sendingDisabledErr))
+			assert.Equal(t, 1, requests)
+
+			// A different token can still be used
+			_, err = SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "other-token")
+			require.NotNil(t, err)
+			assert.True(t, errors.As(err, &authErr))
+			assert.Equal(t, 2, requests)
+		})
+	}
+}
+
+func TestSendRecordsToSaaSPartialSuccess(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"message":"partial success","rejected":[{"line":2,"reason":"invalid resource"},{"line":2,"reason":"invalid resource"},{"line":7}]}`)
+	}))
+	defer testServer.Close()
+
+	result, err := SendRecordsToSaaS([]Record{getValidRecord(t), getValidRecord(t), getValidRecord(t)}, testServer.URL, "")
+	assert.Equal(t, 2, result.Sent)
+	assert.Nil(t, err)
+	require.Len(t, result.RecordErrs, 1)
+	assert.False(t, isRetriable(result.RecordErrs[0]))
+	var rejectedErr *RejectedLogEntryError
+	require.True(t, errors.As(result.RecordErrs[0], &rejectedErr))
+	assert.Equal(t, &RejectedLogEntryError{Line: 2, Reason: "invalid resource"}, rejectedErr)
+	assert.Contains(t, result.RecordErr().Error(), "1 error occurred")
+}
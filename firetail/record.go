@@ -1,11 +1,13 @@
 package firetail
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/hashicorp/go-multierror"
 )
 
 func UnmarshalRecord(data []byte) (Record, error) {
@@ -22,61 +24,167 @@ func (r *Record) Marshal() ([]byte, error) {
 type Record struct {
 	Event         json.RawMessage `json:"event"`
 	Response      RecordResponse  `json:"response"`
+	RawResponse   json.RawMessage `json:"raw_response,omitempty"` // The response exactly as the function returned it, as not every event source's response is a RecordResponse
 	ExecutionTime float64         `json:"execution_time"`
+	CapturedAt    int64           `json:"captured_at,omitempty"` // The time the event was captured by the extension in UNIX milliseconds
+	RequestID     string          `json:"request_id,omitempty"`  // The Lambda request ID of the invocation the record was captured from, if known
+}
+
+// ID returns a stable ID for the record, which is the same each time the record is sent to Firetail so that Firetail can deduplicate it.
+// It's the record's Lambda request ID, if known, followed by a hash of the record's content. Records without a request ID, such as those
+// received from the Lambda Logs API, have the time they were captured in its place, so that identical requests made at different times
+// aren't deduplicated as if they were the same request.
+func (r *Record) ID() string {
+	recordBytes, err := json.Marshal(r)
+	if err != nil {
+		// A Record only fails to marshal if its Event or RawResponse is invalid JSON, so its fields are hashed as they are instead
+		recordBytes = append(append([]byte(r.RequestID+strconv.FormatInt(r.CapturedAt, 10)), r.Event...), r.RawResponse...)
+	}
+	contentHash := sha256.Sum256(recordBytes)
+	switch {
+	case r.RequestID != "":
+		return r.RequestID + ":" + hex.EncodeToString(contentHash[:16])
+	case r.CapturedAt != 0:
+		return strconv.FormatInt(r.CapturedAt, 10) + ":" + hex.EncodeToString(contentHash[:16])
+	}
+	return hex.EncodeToString(contentHash[:16])
 }
 
 // RecordResponse represents the response contained within a Firetail log Record
 type RecordResponse struct {
-	StatusCode int64             `json:"statusCode"`
-	Body       string            `json:"body"`
-	Headers    map[string]string `json:"headers"`
+	StatusCode        int64               `json:"statusCode"`
+	Body              string              `json:"body"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"` // Headers with multiple values, which take precedence over Headers
+	Cookies           []string            `json:"cookies,omitempty"`           // Cookies set by API Gateway v2 responses, each of which becomes a Set-Cookie header
+	IsBase64Encoded   bool                `json:"isBase64Encoded,omitempty"`   // Whether the Body is base64 encoded
 }
 
-// getLogEntryRequest returns the value for the request field of a Firetail SaaS LogEntry based upon the value of the firetail Record's Event value,
-// which is the event that invoked the lambda function, and the request time stated by that event value in UNIX milliseconds. The current implementation
-// supports APIGatewayProxyRequests and APIGatewayV2HTTPRequests.
-func (r *Record) getLogEntryRequest() (*LogEntryRequest, int64, error) {
-	var err error
+// getLogEntry returns a Firetail SaaS LogEntry for the firetail Record, mapped by the EventMapper in the DefaultEventMapperRegistry that
+// is most confident it can map the Record's Event value. Not every event source states when the function was invoked, so if the event
+// didn't provide a request time the time the Record was captured is used instead. Any JWT bearer token in the request is inspected &
+// redacted, the identifiers of the caller are hashed according to the DefaultIdentifierHashing, the identifiers of the consumer and any
+// x-api-key header are hashed according to the DefaultConsumerIdentification, and if the DefaultGraphQLDetection is enabled any GraphQL
+// operations in the request are summarised.
+func (r *Record) getLogEntry() (*LogEntry, error) {
+	logEntry, err := DefaultEventMapperRegistry.MapLogEntry(r)
+	if err != nil {
+		return nil, err
+	}
+	if logEntry.DateCreated == 0 {
+		logEntry.DateCreated = r.CapturedAt
+	}
+	DefaultJWTInspection.inspectBearerJWT(logEntry)
+	if logEntry.Metadata.JWT != nil {
+		logEntry.Metadata.JWT.Subject = DefaultIdentifierHashing.Hash(logEntry.Metadata.JWT.Subject)
+	}
+	if logEntry.Metadata.Caller != nil {
+		logEntry.Metadata.Caller.hashIdentifiers(DefaultIdentifierHashing)
+	}
+	DefaultConsumerIdentification.inspectConsumer(logEntry)
+	if DefaultGraphQLDetection.Enabled {
+		logEntry.Metadata.GraphQL = getGraphQLMetadata(logEntry)
+	}
+	return logEntry, nil
+}
 
+// newLogEntry returns a Firetail SaaS LogEntry for the firetail Record with the provided request value and request time, and the response
+// value given by getLogEntryResponse.
+func (r *Record) newLogEntry(logEntryRequest LogEntryRequest, requestTime int64) *LogEntry {
+	return &LogEntry{
+		DateCreated:   requestTime,
+		ExecutionTime: r.ExecutionTime,
+		Request:       logEntryRequest,
+		Response:      r.getLogEntryResponse(),
+		Version:       The110Alpha,
+		Metadata: LogEntryMetadata{
+			Source: "lambda-extension",
+		},
+	}
+}
+
+// getAPIGatewayV1LogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is an
+// events.APIGatewayProxyRequest. Otherwise, it returns nil and false.
+func (r *Record) getAPIGatewayV1LogEntry() (*LogEntry, bool) {
 	var apiGatewayV1Request events.APIGatewayProxyRequest
-	apiGatewayV1RequestErr := json.Unmarshal(r.Event, &apiGatewayV1Request)
-	// If there was no err in unmarshalling into an events.APIGatewayProxyRequest, and the HTTPMethod was populated with a non-zero value, we will
-	// assume it's an events.APIGatewayProxyRequest.
-	if apiGatewayV1RequestErr == nil && apiGatewayV1Request.Resource != "" {
-		logEntryRequest := &LogEntryRequest{
+	// If there was no err in unmarshalling into an events.APIGatewayProxyRequest, and the Resource & HTTPMethod were populated with non-zero
+	// values, we will assume it's an events.APIGatewayProxyRequest.
+	if err := json.Unmarshal(r.Event, &apiGatewayV1Request); err != nil || apiGatewayV1Request.Resource == "" ||
+		apiGatewayV1Request.HTTPMethod == "" {
+		return nil, false
+	}
+
+	logEntry := r.newLogEntry(
+		LogEntryRequest{
 			Body:         apiGatewayV1Request.Body,
-			Headers:      apiGatewayV1Request.MultiValueHeaders,
+			Headers:      normaliseHeaders(apiGatewayV1Request.Headers, apiGatewayV1Request.MultiValueHeaders),
 			HTTPProtocol: LogEntryHTTPProtocol(apiGatewayV1Request.RequestContext.Protocol),
 			IP:           apiGatewayV1Request.RequestContext.Identity.SourceIP,
 			Method:       LogEntryMethod(apiGatewayV1Request.RequestContext.HTTPMethod),
 			URI:          "https://" + apiGatewayV1Request.RequestContext.DomainName + apiGatewayV1Request.RequestContext.Path,
-			Resource:     apiGatewayV1Request.Resource,
-		}
-		if logEntryRequest.Headers == nil {
-			logEntryRequest.Headers = map[string][]string{}
-		}
-		return logEntryRequest, apiGatewayV1Request.RequestContext.RequestTimeEpoch, nil
-	}
-	err = multierror.Append(err, apiGatewayV1RequestErr)
+			Resource:     normaliseResource(apiGatewayV1Request.Resource),
+		},
+		apiGatewayV1Request.RequestContext.RequestTimeEpoch,
+	)
+	logEntry.Metadata.Caller = getAPIGatewayV1CallerIdentity(apiGatewayV1Request.RequestContext)
+	logEntry.Metadata.Consumer = getConsumerIdentity(apiGatewayV1Request.RequestContext.Identity.APIKeyID, getAPIGatewayV1ClientCert(r.Event))
+
+	return logEntry, true
+}
 
+// getAPIGatewayV2LogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is an
+// events.APIGatewayV2HTTPRequest, which is also the event Lambda function URLs use. Otherwise, it returns nil and false. Almost any JSON
+// object can be unmarshalled into an events.APIGatewayV2HTTPRequest, so only events stating version 2.0 and a route key are accepted.
+func (r *Record) getAPIGatewayV2LogEntry() (*LogEntry, bool) {
 	var apiGatewayV2Request events.APIGatewayV2HTTPRequest
-	apiGatewayV2RequestErr := json.Unmarshal(r.Event, &apiGatewayV2Request)
-	if apiGatewayV2RequestErr == nil {
-		logEntryRequest := &LogEntryRequest{
-			Body:         apiGatewayV2Request.Body,
-			Headers:      map[string][]string{},
-			HTTPProtocol: LogEntryHTTPProtocol(apiGatewayV2Request.RequestContext.HTTP.Protocol),
-			IP:           apiGatewayV2Request.RequestContext.HTTP.SourceIP,
-			Method:       LogEntryMethod(apiGatewayV2Request.RequestContext.HTTP.Method),
-			URI:          "https://" + apiGatewayV2Request.RequestContext.DomainName + apiGatewayV2Request.RequestContext.HTTP.Path,
-			Resource:     apiGatewayV2Request.RawPath,
-		}
-		for header, value := range apiGatewayV2Request.Headers {
-			logEntryRequest.Headers[header] = strings.Split(value, ",")
-		}
-		return logEntryRequest, apiGatewayV2Request.RequestContext.TimeEpoch, nil
+	if err := json.Unmarshal(r.Event, &apiGatewayV2Request); err != nil || apiGatewayV2Request.Version != "2.0" ||
+		apiGatewayV2Request.RouteKey == "" {
+		return nil, false
+	}
+
+	logEntryRequest := LogEntryRequest{
+		Body:         apiGatewayV2Request.Body,
+		Headers:      normaliseHeaders(apiGatewayV2Request.Headers, nil),
+		HTTPProtocol: LogEntryHTTPProtocol(apiGatewayV2Request.RequestContext.HTTP.Protocol),
+		IP:           apiGatewayV2Request.RequestContext.HTTP.SourceIP,
+		Method:       LogEntryMethod(apiGatewayV2Request.RequestContext.HTTP.Method),
+		URI:          "https://" + apiGatewayV2Request.RequestContext.DomainName + apiGatewayV2Request.RequestContext.HTTP.Path,
+		Resource: getResourceFromRouteKey(
+			apiGatewayV2Request.RouteKey,
+			apiGatewayV2Request.RawPath,
+			apiGatewayV2Request.RequestContext.Stage,
+		),
+	}
+	// API Gateway v2 moves the request's cookies out of its headers & into a separate field, so we fold them back into a Cookie header
+	if len(apiGatewayV2Request.Cookies) > 0 {
+		logEntryRequest.Headers["cookie"] = append(logEntryRequest.Headers["cookie"], strings.Join(apiGatewayV2Request.Cookies, "; "))
+	}
+
+	logEntry := r.newLogEntry(logEntryRequest, apiGatewayV2Request.RequestContext.TimeEpoch)
+	logEntry.Metadata.Caller = getAPIGatewayV2CallerIdentity(apiGatewayV2Request.RequestContext)
+	logEntry.Metadata.Consumer = getConsumerIdentity("", getAPIGatewayV2ClientCert(r.Event))
+
+	return logEntry, true
+}
+
+// getLogEntryResponse returns the value for the response field of a Firetail SaaS LogEntry based upon the value of the firetail Record's
+// Response value. Headers and MultiValueHeaders are normalised & merged the same way API Gateway merges them, any cookies set by the response are
+// included as Set-Cookie headers, and base64 encoded bodies are decoded if their content type is textual.
+func (r *Record) getLogEntryResponse() LogEntryResponse {
+	responseHeaders := normaliseHeaders(r.Response.Headers, r.Response.MultiValueHeaders)
+	if len(r.Response.Cookies) > 0 {
+		responseHeaders["set-cookie"] = append(responseHeaders["set-cookie"], r.Response.Cookies...)
+	}
+
+	logEntryResponse := LogEntryResponse{
+		Body:       r.Response.Body,
+		Headers:    responseHeaders,
+		StatusCode: r.Response.StatusCode,
+	}
+
+	if r.Response.IsBase64Encoded {
+		logEntryResponse.Body, logEntryResponse.IsBase64Encoded = decodeBase64Body(r.Response.Body, getHeaderValue(responseHeaders, "content-type"))
 	}
-	err = multierror.Append(err, apiGatewayV2RequestErr)
 
-	return nil, 0, err
+	return logEntryResponse
 }
@@ -1,56 +1,52 @@
 package firetail
 
-import "log"
+import (
+	"context"
+	"log"
+)
 
-// recordReceiver receives records from the client into batches & passes them to the batch callback. If the batch callback
-// returns an err, it does not remove the log entries from the batch.
-func RecordReceiver(recordsChannel chan Record, maxBatchSize int, firetailApiUrl, firetailApiToken string) {
-	recordsBatch := []Record{}
-
-	for {
-		newRecords, recordsRemaining := receiveRecords(recordsChannel, maxBatchSize-len(recordsBatch))
-		recordsBatch = append(recordsBatch, newRecords...)
-
-		// If the batch is empty, but there's records remaining, then we continue; else we return.
-		if len(recordsBatch) == 0 {
-			if recordsRemaining {
-				continue
-			} else {
-				return
-			}
-		}
-
-		// Give the batch to the batch callback. If it errs, we continue
-		log.Printf("Attempting to send batch of %d record(s) to Firetail...", len(recordsBatch))
-		recordsSent, err := SendRecordsToSaaS(recordsBatch, firetailApiUrl, firetailApiToken)
+// RecordReceiver sends the batches of records received by the batcher to Firetail on the upload pool, until the batcher's records channel
+// is closed, at which point the upload pool is shut down once its batches have been sent. Each attempt to send a batch goes through the
+// DefaultCircuitBreaker, and batches which fail to send are retried according to the DefaultRetryPolicy, which dead-letters them if they
+// can't be sent. Batches which aren't sent because the circuit is open are kept by the DefaultSpool. Any batches spooled by a previous
+// instance of the extension are replayed concurrently, as are batches kept in the spool by this instance once a batch has been sent
+// successfully.
+func RecordReceiver(batcher *Batcher, uploadPool *UploadPool, firetailApiUrl, firetailApiToken string) {
+	sendWithRetries := func(recordsBatch []Record) error {
+		var result SendResult
+		err := DefaultRetryPolicy.SendWithRetries(recordsBatch, func(batch []Record) error {
+			return DefaultCircuitBreaker.Send(batch, func(batch []Record) error {
+				log.Printf("Attempting to send batch of %d record(s) to Firetail...", len(batch))
+				var err error
+				result, err = SendRecordsToSaaS(batch, firetailApiUrl, firetailApiToken)
+				if recordErr := result.RecordErr(); recordErr != nil {
+					log.Println("Error sending some records to Firetail, they were dropped:", recordErr.Error())
+				}
+				return err
+			})
+		})
 		if err != nil {
 			log.Println("Error sending records to Firetail:", err.Error())
-			continue
+			return err
 		}
-		log.Println("Successfully sent", recordsSent, "record(s) to Firetail.")
-
-		// If the batch callback succeeded, we can clear the batch!
-		recordsBatch = []Record{}
+		log.Println("Successfully sent", result.Sent, "record(s) to Firetail.")
+		return nil
 	}
-}
 
-// ReceiveRecords returns a slice of firetail Records up to the size of `limit`, and a boolean indicating that the channel
-// still has items to be read - it will only be `false` when the channel is closed & empty. It achieves this by continuously
-// reading from the log server's recordsChannel until it's empty, or the size limit has been reached.
-func receiveRecords(recordsChannel chan Record, limit int) ([]Record, bool) {
-	records := []Record{}
-	for {
-		select {
-		case record, open := <-recordsChannel:
-			if !open {
-				return records, false
-			}
-			records = append(records, record)
-			if len(records) == limit {
-				return records, true
-			}
-		default:
-			return records, true
+	replay := func(replaySpool func(func([]Record) error) error) {
+		if err := replaySpool(sendWithRetries); err != nil {
+			log.Println("Error replaying spooled records:", err.Error())
 		}
 	}
+
+	go replay(DefaultSpool.Replay)
+
+	batcher.Run(func(recordsBatch []Record) {
+		uploadPool.Submit(recordsBatch, func(batch []Record) {
+			if err := DefaultSpool.Send(batch, sendWithRetries); err == nil {
+				go replay(DefaultSpool.ReplayKept)
+			}
+		})
+	})
+	uploadPool.Shutdown(context.Background())
 }
@@ -2,7 +2,6 @@ package firetail
 
 import (
 	"encoding/json"
-	"strings"
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -145,12 +144,27 @@ func TestGetLogEntryRequestAPIGatewayProxyRequest(t *testing.T) {
 		ExecutionTime: 50,
 	}
 
-	logEntry, requestAt, err := testRecord.getLogEntryRequest()
-	require.Nil(t, err)
+	apiGatewayLogEntry, ok := testRecord.getAPIGatewayV1LogEntry()
+	require.True(t, ok)
+	logEntry, requestAt := apiGatewayLogEntry.Request, apiGatewayLogEntry.DateCreated
 
 	assert.Equal(t, int64(1668685315222), requestAt)
 	assert.Equal(t, apiGatewayProxyRequest.Body, logEntry.Body)
-	assert.Equal(t, apiGatewayProxyRequest.MultiValueHeaders, logEntry.Headers)
+	assert.Equal(t,
+		map[string][]string{
+			"content-length":    {"0"},
+			"host":              {"5iagptskg6.execute-api.eu-west-2.amazonaws.com"},
+			"postman-token":     {"8639a798-d0e7-420a-bd98-0c5cb16c6115"},
+			"user-agent":        {"PostmanRuntime/7.28.4"},
+			"x-amzn-trace-id":   {"Root=1-63761e03-7bc79fb21f90dbbe66feba18"},
+			"x-forwarded-for":   {"37.228.214.117"},
+			"x-forwarded-port":  {"443"},
+			"x-forwarded-proto": {"https"},
+			"accept":            {"*/*"},
+			"accept-encoding":   {"gzip", "deflate", "br"},
+		},
+		logEntry.Headers,
+	)
 	assert.Equal(t, apiGatewayProxyRequest.RequestContext.Protocol, string(logEntry.HTTPProtocol))
 	assert.Equal(t, apiGatewayProxyRequest.RequestContext.Identity.SourceIP, logEntry.IP)
 	assert.Equal(t, apiGatewayProxyRequest.RequestContext.HTTPMethod, string(logEntry.Method))
@@ -177,8 +191,9 @@ func TestGetLogEntryRequestAPIGatewayProxyRequestWithNoRequestHeaders(t *testing
 		ExecutionTime: 50,
 	}
 
-	logEntry, requestAt, err := testRecord.getLogEntryRequest()
-	require.Nil(t, err)
+	apiGatewayLogEntry, ok := testRecord.getAPIGatewayV1LogEntry()
+	require.True(t, ok)
+	logEntry, requestAt := apiGatewayLogEntry.Request, apiGatewayLogEntry.DateCreated
 
 	assert.Equal(t, int64(1668685315222), requestAt)
 	assert.Equal(t, apiGatewayProxyRequest.Body, logEntry.Body)
@@ -207,8 +222,9 @@ func TestGetLogEntryRequestAPIGatewayV2HTTPRequest(t *testing.T) {
 		ExecutionTime: 50,
 	}
 
-	logEntry, requestAt, err := testRecord.getLogEntryRequest()
-	require.Nil(t, err)
+	apiGatewayLogEntry, ok := testRecord.getAPIGatewayV2LogEntry()
+	require.True(t, ok)
+	logEntry, requestAt := apiGatewayLogEntry.Request, apiGatewayLogEntry.DateCreated
 
 	assert.Equal(t, int64(1668685228137), requestAt)
 	assert.Equal(t, apiGatewayV2HTTPRequest.Body, logEntry.Body)
@@ -218,11 +234,21 @@ func TestGetLogEntryRequestAPIGatewayV2HTTPRequest(t *testing.T) {
 	assert.Equal(t, "https://"+apiGatewayV2HTTPRequest.RequestContext.DomainName+apiGatewayV2HTTPRequest.RequestContext.HTTP.Path, logEntry.URI)
 	assert.Equal(t, apiGatewayV2HTTPRequest.RawPath, logEntry.Resource)
 
-	expectedHeaders := map[string][]string{}
-	for header, value := range apiGatewayV2HTTPRequest.Headers {
-		expectedHeaders[header] = strings.Split(value, ",")
-	}
-	assert.Equal(t, expectedHeaders, logEntry.Headers)
+	assert.Equal(t,
+		map[string][]string{
+			"accept":            {"*/*"},
+			"accept-encoding":   {"gzip", "deflate", "br"},
+			"content-length":    {"0"},
+			"host":              {"5iagptskg6.execute-api.eu-west-2.amazonaws.com"},
+			"postman-token":     {"071909b8-8176-47cb-8b36-cd0e8ea2081c"},
+			"user-agent":        {"PostmanRuntime/7.28.4"},
+			"x-amzn-trace-id":   {"Root=1-63761dac-7dc96ebc6ea580e704c4a0f2"},
+			"x-forwarded-for":   {"37.228.214.117"},
+			"x-forwarded-port":  {"443"},
+			"x-forwarded-proto": {"https"},
+		},
+		logEntry.Headers,
+	)
 }
 
 func TestGetLogEntryRequestUnsupportedPayload(t *testing.T) {
@@ -247,10 +273,150 @@ func TestGetLogEntryRequestUnsupportedPayload(t *testing.T) {
 		ExecutionTime: 50,
 	}
 
-	logEntry, requestAt, err := testRecord.getLogEntryRequest()
+	logEntry, err := testRecord.getLogEntry()
 	assert.Nil(t, logEntry)
-	assert.Equal(t, int64(0), requestAt)
 	require.NotNil(t, err)
-	assert.Contains(t, err.Error(), "json: cannot unmarshal string into Go struct field APIGatewayProxyRequest.headers of type map[string]string")
-	assert.Contains(t, err.Error(), "json: cannot unmarshal string into Go struct field APIGatewayV2HTTPRequest.headers of type map[string]string")
+	assert.Contains(t, err.Error(), "No event mapper detected the source of the record's event, tried: ")
+	assert.Contains(t, err.Error(), "apigateway-v1 (0)")
+}
+
+func TestGetLogEntryRequestAPIGatewayV2HTTPRequestWithCookies(t *testing.T) {
+	apiGatewayV2HTTPRequest := getNewAPIGatewayV2HTTPRequest()
+	apiGatewayV2HTTPRequest.Cookies = []string{"session=abc123", "theme=dark"}
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(apiGatewayV2HTTPRequest)
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes)}
+
+	logEntry, ok := testRecord.getAPIGatewayV2LogEntry()
+	require.True(t, ok)
+	assert.Equal(t, []string{"session=abc123; theme=dark"}, logEntry.Request.Headers["cookie"])
+}
+
+func TestGetLogEntryResponseWithCookies(t *testing.T) {
+	testRecord := Record{
+		Response: RecordResponse{
+			StatusCode: 200,
+			Body:       "{\"Description\":\"This is a test response body\"}",
+			Headers: map[string]string{
+				"Test-Header-Name": "Test-Header-Value",
+			},
+			Cookies: []string{
+				"session=abc123; Path=/; HttpOnly",
+				"theme=dark; Expires=Wed, 21 Oct 2015 07:28:00 GMT",
+			},
+		},
+	}
+
+	logEntryResponse := testRecord.getLogEntryResponse()
+	assert.Equal(t, int64(200), logEntryResponse.StatusCode)
+	assert.Equal(t, testRecord.Response.Body, logEntryResponse.Body)
+	assert.Equal(t,
+		map[string][]string{
+			"test-header-name": {"Test-Header-Value"},
+			"set-cookie": {
+				"session=abc123; Path=/; HttpOnly",
+				"theme=dark; Expires=Wed, 21 Oct 2015 07:28:00 GMT",
+			},
+		},
+		logEntryResponse.Headers,
+	)
+}
+
+func TestGetLogEntryResponseMergesMultiValueHeaders(t *testing.T) {
+	testRecord := Record{
+		Response: RecordResponse{
+			StatusCode: 200,
+			Headers: map[string]string{
+				"Content-Type": "application/json",
+				"Vary":         "Origin",
+			},
+			MultiValueHeaders: map[string][]string{
+				"vary":         {"Accept", "Accept-Encoding"},
+				"X-Multi-Only": {"one", "two"},
+			},
+		},
+	}
+
+	logEntryResponse := testRecord.getLogEntryResponse()
+	assert.Equal(t,
+		map[string][]string{
+			"content-type": {"application/json"},
+			"vary":         {"Accept", "Accept-Encoding"},
+			"x-multi-only": {"one", "two"},
+		},
+		logEntryResponse.Headers,
+	)
+}
+
+func TestGetLogEntryResponseDecodesTextualBase64Body(t *testing.T) {
+	testRecord := Record{
+		Response: RecordResponse{
+			StatusCode: 200,
+			Body:       "eyJEZXNjcmlwdGlvbiI6IlRoaXMgaXMgYSB0ZXN0IHJlc3BvbnNlIGJvZHkifQ==",
+			Headers: map[string]string{
+				"content-type": "application/json",
+			},
+			IsBase64Encoded: true,
+		},
+	}
+
+	logEntryResponse := testRecord.getLogEntryResponse()
+	assert.Equal(t, "{\"Description\":\"This is a test response body\"}", logEntryResponse.Body)
+	assert.False(t, logEntryResponse.IsBase64Encoded)
+}
+
+func TestGetLogEntryResponseMarksBinaryBase64Body(t *testing.T) {
+	testRecord := Record{
+		Response: RecordResponse{
+			StatusCode: 200,
+			Body:       "iVBORw0KGgo=",
+			MultiValueHeaders: map[string][]string{
+				"Content-Type": {"image/png"},
+			},
+			IsBase64Encoded: true,
+		},
+	}
+
+	logEntryResponse := testRecord.getLogEntryResponse()
+	assert.Equal(t, "iVBORw0KGgo=", logEntryResponse.Body)
+	assert.True(t, logEntryResponse.IsBase64Encoded)
+}
+
+func TestRecordID(t *testing.T) {
+	testRecord := Record{
+		Event:      json.RawMessage(`{"version": "2.0"}`),
+		Response:   RecordResponse{StatusCode: 200, Body: "hello"},
+		CapturedAt: 1668685315222,
+		RequestID:  "8476a536-e9f4-11e8-9739-2dfe598c3fcd",
+	}
+	recordID := testRecord.ID()
+	assert.Regexp(t, "^8476a536-e9f4-11e8-9739-2dfe598c3fcd:[0-9a-f]{32}$", recordID)
+
+	// The ID must be the same after the record has been marshalled & unmarshalled, such as when it's spooled
+	recordBytes, err := testRecord.Marshal()
+	require.Nil(t, err)
+	unmarshalledRecord, err := UnmarshalRecord(recordBytes)
+	require.Nil(t, err)
+	assert.Equal(t, recordID, unmarshalledRecord.ID())
+
+	// Records with the same request ID but different content must have different IDs
+	testRecord.Response.Body = "world"
+	assert.NotEqual(t, recordID, testRecord.ID())
+
+	// Records without a request ID are identified by the time they were captured, so identical requests at different times differ
+	testRecord.RequestID = ""
+	recordID = testRecord.ID()
+	assert.Regexp(t, "^1668685315222:[0-9a-f]{32}$", recordID)
+	testRecord.CapturedAt++
+	assert.NotEqual(t, recordID, testRecord.ID())
+
+	testRecord.CapturedAt = 0
+	assert.Regexp(t, "^[0-9a-f]{32}$", testRecord.ID())
+
+	// Records which can't be marshalled are hashed including the time they were captured too
+	invalidRecord := Record{Event: json.RawMessage(`{`), CapturedAt: 1668685315222}
+	invalidRecordID := invalidRecord.ID()
+	invalidRecord.CapturedAt++
+	assert.NotEqual(t, invalidRecordID, invalidRecord.ID())
 }
@@ -0,0 +1,70 @@
+package firetail
+
+import "strings"
+
+// defaultRouteKey is the routeKey API Gateway uses for requests which didn't match any other route, and which Lambda function URLs use
+// for every request
+const defaultRouteKey = "$default"
+
+// defaultRouteResource is the resource of requests to the $default route. The $default route matches any path, like a greedy path
+// parameter, so it's given the same resource as an ANY /{proxy+} route rather than the request's raw path, which would give every
+// distinct path its own resource.
+const defaultRouteResource = "/{proxy+}"
+
+// getResourceFromRouteKey returns the resource path from an API Gateway v2 routeKey, which takes the form "METHOD /path/{param}" or
+// "$default". The $default route has no path template, so its resource is defaultRouteResource. If there's no routeKey at all, the
+// resource is derived from the raw path of the request with the stage name removed, as API Gateway includes it in the raw path of
+// requests to any stage other than the $default stage.
+func getResourceFromRouteKey(routeKey, rawPath, stage string) string {
+	routeKey = strings.TrimSpace(routeKey)
+
+	if routeKey == defaultRouteKey {
+		return defaultRouteResource
+	}
+
+	if routeKey == "" {
+		return normaliseResource(trimStage(rawPath, stage))
+	}
+
+	// Routes are of the form "METHOD /path", where METHOD may be "ANY"; we only want the path.
+	if _, path, found := strings.Cut(routeKey, " "); found {
+		return normaliseResource(path)
+	}
+
+	return normaliseResource(routeKey)
+}
+
+// trimStage removes the stage name from the start of a raw path, if it's the path's first segment. Paths to the $default stage don't
+// include it, so they're returned unchanged.
+func trimStage(rawPath, stage string) string {
+	if stage == "" || stage == defaultRouteKey {
+		return rawPath
+	}
+	if rawPath == "/"+stage {
+		return "/"
+	}
+	if strings.HasPrefix(rawPath, "/"+stage+"/") {
+		return strings.TrimPrefix(rawPath, "/"+stage)
+	}
+	return rawPath
+}
+
+// normaliseResource normalises a resource path so that the same route yields the same resource regardless of the event source it came
+// from. It trims whitespace, ensures a leading slash and removes any trailing slash. Greedy path parameters such as {proxy+} are left
+// intact, as that is how they appear in the OpenAPI specs API Gateway imports & exports. An empty resource is left empty.
+func normaliseResource(resource string) string {
+	resource = strings.TrimSpace(resource)
+	if resource == "" {
+		return ""
+	}
+	if !strings.HasPrefix(resource, "/") {
+		resource = "/" + resource
+	}
+	if len(resource) > 1 {
+		resource = strings.TrimRight(resource, "/")
+		if resource == "" {
+			resource = "/"
+		}
+	}
+	return resource
+}
@@ -0,0 +1,67 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetResourceFromRouteKey(t *testing.T) {
+	testCases := []struct {
+		routeKey         string
+		rawPath          string
+		stage            string
+		expectedResource string
+	}{
+		{"GET /users/{id}", "/users/42", "$default", "/users/{id}"},
+		{"ANY /{proxy+}", "/users/42", "$default", "/{proxy+}"},
+		{"POST /users/{id}/", "/users/42/", "$default", "/users/{id}"},
+		{"$default", "/users/42", "$default", "/{proxy+}"},
+		{"$default", "/prod/users/42", "prod", "/{proxy+}"},
+		{"", "/users/42", "", "/users/42"},
+		{"", "/prod/users/42", "prod", "/users/42"},
+		{"", "/prod/", "prod", "/"},
+		{"", "/prod", "prod", "/"},
+		{"", "/production/users/42", "prod", "/production/users/42"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.routeKey+" "+testCase.rawPath, func(t *testing.T) {
+			assert.Equal(t, testCase.expectedResource, getResourceFromRouteKey(testCase.routeKey, testCase.rawPath, testCase.stage))
+		})
+	}
+}
+
+func TestNormaliseResource(t *testing.T) {
+	testCases := map[string]string{
+		"":                "",
+		"/":               "/",
+		"//":              "/",
+		"/hi":             "/hi",
+		"/hi/":            "/hi",
+		"hi":              "/hi",
+		" /pets/{id} ":    "/pets/{id}",
+		"/pets/{proxy+}/": "/pets/{proxy+}",
+	}
+	for resource, expectedResource := range testCases {
+		assert.Equal(t, expectedResource, normaliseResource(resource))
+	}
+}
+
+func TestGetLogEntryRequestAPIGatewayV2HTTPRequestUsesRouteTemplate(t *testing.T) {
+	apiGatewayV2HTTPRequest := getNewAPIGatewayV2HTTPRequest()
+	apiGatewayV2HTTPRequest.RouteKey = "GET /users/{id}"
+	apiGatewayV2HTTPRequest.RawPath = "/users/42"
+	apiGatewayV2HTTPRequest.RequestContext.RouteKey = "GET /users/{id}"
+	apiGatewayV2HTTPRequest.RequestContext.HTTP.Path = "/users/42"
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(apiGatewayV2HTTPRequest)
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes)}
+
+	logEntry, ok := testRecord.getAPIGatewayV2LogEntry()
+	require.True(t, ok)
+	assert.Equal(t, "/users/{id}", logEntry.Request.Resource)
+	assert.Equal(t, "https://5iagptskg6.execute-api.eu-west-2.amazonaws.com/users/42", logEntry.Request.URI)
+}
@@ -0,0 +1,216 @@
+package firetail
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// RetriableError is returned when sending records to Firetail failed in a way which may succeed if retried, such as a 429 or 5xx response.
+// RetryAfter is the delay requested by the response's Retry-After header, if it had one.
+type RetriableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetriableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetriableError) Unwrap() error {
+	return e.Err
+}
+
+// PermanentError is returned when sending records to Firetail failed in a way which will fail again if retried, such as a 4xx response
+// or a record which can't be marshalled
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// isRetriable returns true if an error from sending records to Firetail may not recur if the records are sent again. Errors are retriable
+// unless they're a PermanentError, so network errors are retried. If the error is a multierror, it's retriable if any of its errors are.
+func isRetriable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var multiErr *multierror.Error
+	if errors.As(err, &multiErr) {
+		for _, wrappedErr := range multiErr.Errors {
+			if isRetriable(wrappedErr) {
+				return true
+			}
+		}
+		return false
+	}
+	var permanentErr *PermanentError
+	return !errors.As(err, &permanentErr)
+}
+
+// getRetryAfter returns the delay requested by a RetriableError in an error, or 0 if it doesn't have one
+func getRetryAfter(err error) time.Duration {
+	var retriableErr *RetriableError
+	if errors.As(err, &retriableErr) {
+		return retriableErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter returns the delay requested by a Retry-After header value, which may be a number of seconds or an HTTP date, or 0 if
+// it can't be parsed or is in the past
+func parseRetryAfter(retryAfter string, now time.Time) time.Duration {
+	retryAfter = strings.TrimSpace(retryAfter)
+	if retryAfter == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if retryAt, err := http.ParseTime(retryAfter); err == nil && retryAt.After(now) {
+		return retryAt.Sub(now)
+	}
+	return 0
+}
+
+// RetryPolicy configures how batches of records are retried when sending them to Firetail fails with a retriable error. Retries are
+// delayed by an exponential backoff with full jitter, or the delay requested by the Firetail API's Retry-After header if it's longer.
+// Once a batch has been attempted MaxAttempts times, its next retry would be later than MaxAge after its first attempt, or it fails with
+// a permanent error, it's given to the DeadLetterCallback & dropped, so it isn't kept in the spool to be sent again.
+type RetryPolicy struct {
+	MaxAttempts        int                             // The maximum number of times a batch is attempted, including its first attempt
+	InitialBackoff     time.Duration                   // The maximum delay before the first retry, which doubles for each retry after it
+	MaxBackoff         time.Duration                   // The maximum delay before any retry, not including delays requested by Retry-After headers
+	MaxAge             time.Duration                   // A batch isn't retried if the retry would be later than this after its first attempt
+	DeadLetterCallback func(batch []Record, err error) // A callback given batches which couldn't be sent, and the last err sending them
+}
+
+// DefaultRetryPolicy is the RetryPolicy used when sending records to Firetail
+var DefaultRetryPolicy = &RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	MaxAge:         30 * time.Second,
+	DeadLetterCallback: func(batch []Record, err error) {
+		log.Printf("Gave up sending batch of %d record(s) to Firetail, err: %s", len(batch), err.Error())
+	},
+}
+
+// LoadEnvVars configures the RetryPolicy from the FIRETAIL_RETRY_MAX_ATTEMPTS, FIRETAIL_RETRY_INITIAL_BACKOFF, FIRETAIL_RETRY_MAX_BACKOFF &
+// FIRETAIL_RETRY_MAX_AGE env vars. Durations are parsed by time.ParseDuration.
+func (p *RetryPolicy) LoadEnvVars() error {
+	if maxAttemptsStr, isSet := os.LookupEnv("FIRETAIL_RETRY_MAX_ATTEMPTS"); isSet {
+		maxAttempts, err := strconv.Atoi(maxAttemptsStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_RETRY_MAX_ATTEMPTS invalid")
+		}
+		if maxAttempts < 1 {
+			return errors.Errorf("FIRETAIL_RETRY_MAX_ATTEMPTS is %d but must be >= 1", maxAttempts)
+		}
+		p.MaxAttempts = maxAttempts
+	}
+	for _, durationEnvVar := range []struct {
+		name     string
+		duration *time.Duration
+	}{
+		{"FIRETAIL_RETRY_INITIAL_BACKOFF", &p.InitialBackoff},
+		{"FIRETAIL_RETRY_MAX_BACKOFF", &p.MaxBackoff},
+		{"FIRETAIL_RETRY_MAX_AGE", &p.MaxAge},
+	} {
+		durationStr, isSet := os.LookupEnv(durationEnvVar.name)
+		if !isSet {
+			continue
+		}
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("%s invalid", durationEnvVar.name))
+		}
+		if duration < 0 {
+			return errors.Errorf("%s is %s but must be >= 0", durationEnvVar.name, durationStr)
+		}
+		*durationEnvVar.duration = duration
+	}
+	return nil
+}
+
+// backoff returns the delay before the provided retry of a batch, where the first retry is 1. The delay is chosen at random between 0 and
+// InitialBackoff doubled for each retry after the first, capped at MaxBackoff, unless the retryAfter requested by the Firetail API is longer.
+func (p *RetryPolicy) backoff(retry int, retryAfter time.Duration) time.Duration {
+	maxDelay := p.InitialBackoff
+	for i := 1; i < retry && maxDelay < p.MaxBackoff; i++ {
+		maxDelay *= 2
+	}
+	if maxDelay > p.MaxBackoff {
+		maxDelay = p.MaxBackoff
+	}
+	delay := time.Duration(0)
+	if maxDelay > 0 {
+		delay = time.Duration(rand.Int63n(int64(maxDelay) + 1))
+	}
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// SendWithRetries calls send with the batch until it succeeds, or fails with an error which isn't retriable, or the RetryPolicy's attempts
+// or age are exhausted, in which case the batch is given to the DeadLetterCallback & the last err is returned as a PermanentError, so that
+// a spool doesn't keep a batch which has been dead-lettered. If send fails with a CircuitOpenError the batch isn't retried, and is only
+// given to the DeadLetterCallback if the circuit breaker drops it rather than spooling it. It blocks while waiting to retry.
+func (p *RetryPolicy) SendWithRetries(batch []Record, send func([]Record) error) error {
+	firstAttemptAt := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := send(batch)
+		if err == nil {
+			return nil
+		}
+		// Retrying while a circuit breaker is open would only wait for its cooldown, so the batch is left to its overflow policy
+		var circuitOpenErr *CircuitOpenError
+		if errors.As(err, &circuitOpenErr) {
+			if circuitOpenErr.OverflowPolicy == OverflowPolicyDrop {
+				p.deadLetter(batch, err)
+			}
+			return err
+		}
+		if !isRetriable(err) {
+			p.deadLetter(batch, err)
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			err = errors.WithMessage(err, fmt.Sprintf("Gave up after %d attempt(s)", attempt))
+			p.deadLetter(batch, err)
+			return &PermanentError{err}
+		}
+		delay := p.backoff(attempt, getRetryAfter(err))
+		if time.Since(firstAttemptAt)+delay > p.MaxAge {
+			err = errors.WithMessage(err, fmt.Sprintf("Gave up after %d attempt(s) as the next would exceed the max age of %s", attempt, p.MaxAge))
+			p.deadLetter(batch, err)
+			return &PermanentError{err}
+		}
+		log.Printf("Retrying batch of %d record(s) in %s after err: %s", len(batch), delay, err.Error())
+		time.Sleep(delay)
+	}
+}
+
+func (p *RetryPolicy) deadLetter(batch []Record, err error) {
+	if p.DeadLetterCallback != nil {
+		p.DeadLetterCallback(batch, err)
+	}
+}
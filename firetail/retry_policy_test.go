@@ -0,0 +1,172 @@
+package firetail
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestRetryPolicy(deadLetters *[][]Record) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+		MaxAge:         time.Second,
+		DeadLetterCallback: func(batch []Record, err error) {
+			*deadLetters = append(*deadLetters, batch)
+		},
+	}
+}
+
+func TestIsRetriable(t *testing.T) {
+	assert.False(t, isRetriable(nil))
+	assert.True(t, isRetriable(errors.New("connection reset by peer")))
+	assert.True(t, isRetriable(&RetriableError{Err: errors.New("Got 503 response from firetail api")}))
+	assert.False(t, isRetriable(&PermanentError{errors.New("Got 400 response from firetail api")}))
+	assert.False(t, isRetriable(errors.WithMessage(&PermanentError{errors.New("Got 400 response from firetail api")}, "Err sending")))
+
+	marshalErrs := multierror.Append(nil, &PermanentError{errors.New("Err marshalling record to bytes")})
+	assert.False(t, isRetriable(marshalErrs))
+	assert.True(t, isRetriable(multierror.Append(marshalErrs, errors.New("Failed to make log request"))))
+	assert.True(t, isRetriable(errors.WithMessage(multierror.Append(marshalErrs, errors.New("Failed to make log request")), "Err sending")))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 11, 17, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Thu, 17 Nov 2022 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Thu, 17 Nov 2022 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	retryPolicy := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, retryPolicy.backoff(1, 0), 100*time.Millisecond)
+		assert.LessOrEqual(t, retryPolicy.backoff(3, 0), 400*time.Millisecond)
+		assert.LessOrEqual(t, retryPolicy.backoff(10, 0), time.Second)
+		assert.GreaterOrEqual(t, retryPolicy.backoff(10, 0), time.Duration(0))
+		assert.Equal(t, 5*time.Second, retryPolicy.backoff(1, 5*time.Second))
+	}
+}
+
+func TestSendWithRetriesSucceedsAfterRetriableErrs(t *testing.T) {
+	deadLetters := [][]Record{}
+	attempts := 0
+	err := getTestRetryPolicy(&deadLetters).SendWithRetries([]Record{{}}, func(batch []Record) error {
+		attempts++
+		if attempts < 3 {
+			return &RetriableError{Err: errors.New("Got 503 response from firetail api")}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Len(t, deadLetters, 0)
+}
+
+func TestSendWithRetriesDeadLettersPermanentErrs(t *testing.T) {
+	deadLetters := [][]Record{}
+	attempts := 0
+	err := getTestRetryPolicy(&deadLetters).SendWithRetries([]Record{{}}, func(batch []Record) error {
+		attempts++
+		return &PermanentError{errors.New("Got 400 response from firetail api")}
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "Got 400 response from firetail api", err.Error())
+	assert.Equal(t, 1, attempts)
+	assert.Len(t, deadLetters, 1)
+}
+
+func TestSendWithRetriesMaxAttempts(t *testing.T) {
+	deadLetters := [][]Record{}
+	attempts := 0
+	err := getTestRetryPolicy(&deadLetters).SendWithRetries([]Record{{}}, func(batch []Record) error {
+		attempts++
+		return errors.New("connection reset by peer")
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "Gave up after 3 attempt(s): connection reset by peer", err.Error())
+	assert.False(t, isRetriable(err))
+	assert.Equal(t, 3, attempts)
+	assert.Len(t, deadLetters, 1)
+}
+
+func TestSendWithRetriesMaxAge(t *testing.T) {
+	deadLetters := [][]Record{}
+	attempts := 0
+	err := getTestRetryPolicy(&deadLetters).SendWithRetries([]Record{{}}, func(batch []Record) error {
+		attempts++
+		return &RetriableError{Err: errors.New("Got 429 response from firetail api"), RetryAfter: time.Minute}
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, "Gave up after 1 attempt(s) as the next would exceed the max age of 1s: Got 429 response from firetail api", err.Error())
+	assert.False(t, isRetriable(err))
+	assert.Equal(t, 1, attempts)
+	assert.Len(t, deadLetters, 1)
+}
+
+func TestSendRecordsToSaaSErrStatusCodes(t *testing.T) {
+	testCases := []struct {
+		statusCode         int
+		retryAfter         string
+		expectRetriable    bool
+		expectedRetryAfter time.Duration
+	}{
+		{http.StatusTooManyRequests, "7", true, 7 * time.Second},
+		{http.StatusServiceUnavailable, "", true, 0},
+		{http.StatusBadRequest, "", false, 0},
+		{http.StatusUnauthorized, "", false, 0},
+	}
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprint(testCase.statusCode), func(t *testing.T) {
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if testCase.retryAfter != "" {
+					w.Header().Set("Retry-After", testCase.retryAfter)
+				}
+				w.WriteHeader(testCase.statusCode)
+				fmt.Fprintf(w, `{"message":"failure"}`)
+			}))
+			defer testServer.Close()
+
+			_, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "")
+			require.NotNil(t, err)
+			assert.Equal(t, testCase.expectRetriable, isRetriable(err))
+			assert.Equal(t, testCase.expectedRetryAfter, getRetryAfter(err))
+		})
+	}
+}
+
+func TestRetryPolicyLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_RETRY_MAX_ATTEMPTS", "10")
+	t.Setenv("FIRETAIL_RETRY_INITIAL_BACKOFF", "250ms")
+	t.Setenv("FIRETAIL_RETRY_MAX_BACKOFF", "10s")
+	t.Setenv("FIRETAIL_RETRY_MAX_AGE", "1m")
+	retryPolicy := &RetryPolicy{}
+	require.Nil(t, retryPolicy.LoadEnvVars())
+	assert.Equal(t, &RetryPolicy{
+		MaxAttempts:    10,
+		InitialBackoff: 250 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		MaxAge:         time.Minute,
+	}, retryPolicy)
+
+	t.Setenv("FIRETAIL_RETRY_MAX_AGE", "-1m")
+	err := (&RetryPolicy{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_RETRY_MAX_AGE is -1m but must be >= 0", err.Error())
+
+	t.Setenv("FIRETAIL_RETRY_MAX_ATTEMPTS", "0")
+	err = (&RetryPolicy{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_RETRY_MAX_ATTEMPTS is 0 but must be >= 1", err.Error())
+}
@@ -0,0 +1,91 @@
+package firetail
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// s3ObjectLambdaEvent is the event S3 Object Lambda invokes functions with to transform the response to an S3 request
+type s3ObjectLambdaEvent struct {
+	XAmzRequestID     string          `json:"xAmzRequestId"`
+	HeadObjectContext json.RawMessage `json:"headObjectContext"` // Present for HeadObject requests; all other supported requests are GETs
+	Configuration     struct {
+		AccessPointArn           string `json:"accessPointArn"`
+		SupportingAccessPointArn string `json:"supportingAccessPointArn"`
+	} `json:"configuration"`
+	UserRequest struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	} `json:"userRequest"`
+	UserIdentity struct {
+		Type        string `json:"type"`
+		PrincipalID string `json:"principalId"`
+		ARN         string `json:"arn"`
+		AccountID   string `json:"accountId"`
+	} `json:"userIdentity"`
+	ProtocolVersion string `json:"protocolVersion"`
+}
+
+// s3ObjectLambdaResponse is the value S3 Object Lambda functions conventionally return. The transformed object itself is sent to S3 by
+// the function using the WriteGetObjectResponse API, so it can't be captured by the extension.
+type s3ObjectLambdaResponse struct {
+	StatusCode int64 `json:"status_code"`
+}
+
+// getS3ObjectLambdaLogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is an S3
+// Object Lambda event. Otherwise, it returns nil and false.
+func (r *Record) getS3ObjectLambdaLogEntry() (*LogEntry, bool) {
+	var s3ObjectLambdaEvent s3ObjectLambdaEvent
+	if err := json.Unmarshal(r.Event, &s3ObjectLambdaEvent); err != nil || s3ObjectLambdaEvent.UserRequest.URL == "" ||
+		s3ObjectLambdaEvent.Configuration.AccessPointArn == "" {
+		return nil, false
+	}
+
+	userRequestURL, err := url.Parse(s3ObjectLambdaEvent.UserRequest.URL)
+	if err != nil {
+		return nil, false
+	}
+
+	method := Get
+	if s3ObjectLambdaEvent.HeadObjectContext != nil {
+		method = Head
+	}
+
+	// The user request URL may be presigned, so its query string is dropped to avoid logging the signature. S3 Object Lambda events
+	// don't include the time of the request or the IP of the client.
+	logEntry := r.newLogEntry(
+		LogEntryRequest{
+			Headers:      normaliseHeaders(s3ObjectLambdaEvent.UserRequest.Headers, nil),
+			HTTPProtocol: HTTP11,
+			Method:       method,
+			URI:          userRequestURL.Scheme + "://" + userRequestURL.Host + userRequestURL.EscapedPath(),
+			Resource:     normaliseResource(userRequestURL.Path),
+		},
+		0,
+	)
+
+	if logEntry.Response.StatusCode == 0 {
+		var s3ObjectLambdaResponse s3ObjectLambdaResponse
+		if err := json.Unmarshal(r.RawResponse, &s3ObjectLambdaResponse); err == nil {
+			logEntry.Response.StatusCode = s3ObjectLambdaResponse.StatusCode
+		}
+	}
+
+	logEntry.Metadata.S3ObjectLambda = &LogEntryS3ObjectLambdaMetadata{
+		AccessPointArn:           s3ObjectLambdaEvent.Configuration.AccessPointArn,
+		SupportingAccessPointArn: s3ObjectLambdaEvent.Configuration.SupportingAccessPointArn,
+		RequestID:                s3ObjectLambdaEvent.XAmzRequestID,
+		UserIdentityType:         s3ObjectLambdaEvent.UserIdentity.Type,
+		PrincipalID:              s3ObjectLambdaEvent.UserIdentity.PrincipalID,
+		UserArn:                  s3ObjectLambdaEvent.UserIdentity.ARN,
+	}
+	if s3ObjectLambdaEvent.UserIdentity.ARN != "" {
+		logEntry.Metadata.Caller = &LogEntryCallerMetadata{
+			Type:         callerTypeIAM,
+			IAMPrincipal: s3ObjectLambdaEvent.UserIdentity.ARN,
+			AccountID:    s3ObjectLambdaEvent.UserIdentity.AccountID,
+		}
+	}
+
+	return logEntry, true
+}
@@ -0,0 +1,90 @@
+package firetail
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testS3ObjectLambdaEvent = `{
+	"xAmzRequestId": "1a5ed718-5f53-471d-b6fe-5cf62d88d02a",
+	"getObjectContext": {
+		"inputS3Url": "https://myap-111122223333.s3-accesspoint.us-east-1.amazonaws.com/example?X-Amz-Security-Token=snip",
+		"outputRoute": "io-use1-001",
+		"outputToken": "OutputToken"
+	},
+	"configuration": {
+		"accessPointArn": "arn:aws:s3-object-lambda:us-east-1:111122223333:accesspoint/example-object-lambda-ap",
+		"supportingAccessPointArn": "arn:aws:s3:us-east-1:111122223333:accesspoint/example-ap",
+		"payload": "{}"
+	},
+	"userRequest": {
+		"url": "https://object-lambda-111122223333.s3-object-lambda.us-east-1.amazonaws.com/example?X-Amz-Signature=secret",
+		"headers": {
+			"Host": "object-lambda-111122223333.s3-object-lambda.us-east-1.amazonaws.com",
+			"Accept-Encoding": "identity",
+			"X-Amz-Content-SHA256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+		}
+	},
+	"userIdentity": {
+		"type": "AssumedRole",
+		"principalId": "AROAEXAMPLE:session",
+		"arn": "arn:aws:sts::111122223333:assumed-role/Admin/session",
+		"accountId": "111122223333"
+	},
+	"protocolVersion": "1.00"
+}`
+
+func TestGetS3ObjectLambdaLogEntry(t *testing.T) {
+	testRecord := Record{
+		Event:       json.RawMessage(testS3ObjectLambdaEvent),
+		RawResponse: json.RawMessage(`{"status_code":200}`),
+	}
+
+	logEntry, ok := testRecord.getS3ObjectLambdaLogEntry()
+	require.True(t, ok)
+
+	assert.Equal(t, Get, logEntry.Request.Method)
+	assert.Equal(t, "https://object-lambda-111122223333.s3-object-lambda.us-east-1.amazonaws.com/example", logEntry.Request.URI)
+	assert.Equal(t, "/example", logEntry.Request.Resource)
+	assert.Equal(t, []string{"identity"}, logEntry.Request.Headers["accept-encoding"])
+	assert.Equal(t, int64(200), logEntry.Response.StatusCode)
+	assert.Equal(t,
+		&LogEntryS3ObjectLambdaMetadata{
+			AccessPointArn:           "arn:aws:s3-object-lambda:us-east-1:111122223333:accesspoint/example-object-lambda-ap",
+			SupportingAccessPointArn: "arn:aws:s3:us-east-1:111122223333:accesspoint/example-ap",
+			RequestID:                "1a5ed718-5f53-471d-b6fe-5cf62d88d02a",
+			UserIdentityType:         "AssumedRole",
+			PrincipalID:              "AROAEXAMPLE:session",
+			UserArn:                  "arn:aws:sts::111122223333:assumed-role/Admin/session",
+		},
+		logEntry.Metadata.S3ObjectLambda,
+	)
+	assert.Equal(t,
+		&LogEntryCallerMetadata{Type: "iam", IAMPrincipal: "arn:aws:sts::111122223333:assumed-role/Admin/session", AccountID: "111122223333"},
+		logEntry.Metadata.Caller,
+	)
+}
+
+func TestGetS3ObjectLambdaLogEntryHeadObject(t *testing.T) {
+	testRecord := Record{
+		Event: json.RawMessage(`{"headObjectContext":{"inputS3Url":"https://example"},"configuration":{"accessPointArn":"arn:aws:s3-object-lambda:us-east-1:111122223333:accesspoint/ap"},"userRequest":{"url":"https://example.com/key","headers":{}},"protocolVersion":"1.01"}`),
+	}
+
+	logEntry, ok := testRecord.getS3ObjectLambdaLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, Head, logEntry.Request.Method)
+}
+
+func TestGetS3ObjectLambdaLogEntryNotS3ObjectLambda(t *testing.T) {
+	apiGatewayProxyRequestBytes, err := json.Marshal(getNewAPIGatewayProxyRequest())
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayProxyRequestBytes)}
+
+	logEntry, ok := testRecord.getS3ObjectLambdaLogEntry()
+	assert.False(t, ok)
+	assert.Nil(t, logEntry)
+}
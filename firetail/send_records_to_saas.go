@@ -2,87 +2,268 @@ package firetail
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"log"
 	"net/http"
 
 	"github.com/hashicorp/go-multierror"
 )
 
+// SendResult is the outcome of sending records to Firetail with SendRecordsToSaaS
+type SendResult struct {
+	Sent       int     // The number of records included in the requests sent to Firetail, less those it rejected
+	RecordErrs []error // The errs of the individual records which were dropped because they'd fail again if they were sent again
+}
+
+// RecordErr returns the SendResult's RecordErrs combined into one error, or nil if there weren't any
+func (r SendResult) RecordErr() error {
+	var recordErrs error
+	for _, recordErr := range r.RecordErrs {
+		recordErrs = multierror.Append(recordErrs, recordErr)
+	}
+	return recordErrs
+}
+
 // SendMessagesToSaaS takes an array of Firetail log records, and an API URL & key, and sends those records to the API provided. It returns
-// an integer value representing the number of records that were included in the request sent to Firetail, and an error containing any errors
-// that were encountered when Marshalling the records and attempting to send them to Firetail - note, it is possible for some logs to be sent
-// to Firetail, while other logs fail due to errs while attempting to Marshal them.
-func SendRecordsToSaaS(records []Record, apiUrl, apiKey string) (int, error) {
-	reqBytes := []byte{}
-	marshalledRecords := 0
-
-	var errs error
+// a SendResult with the number of records that were included in the requests sent to Firetail & the errors encountered with individual
+// records, and an error if the records couldn't be delivered to Firetail. Records which fail to be mapped or marshalled into log entries,
+// or which the Firetail API rejects, will fail again if they're sent again, so they're dropped & returned in the SendResult's RecordErrs
+// while the rest of the records are sent; only the returned error means the records should be retried or dead-lettered. Log entries larger
+// than the DefaultLogEntryTruncation's MaxBytes have their bodies truncated, and if the log entries are larger than the DefaultBatchLimits'
+// MaxBytes they're split across several requests, which are sent in order until one fails with a retriable error. A request which the
+// Firetail API responds to with a 413 is split in half & its halves sent in its place, and the records of a request which fails with any
+// other permanent error are dropped & returned in the RecordErrs, unless none of the requests were delivered, in which case the error is
+// returned for the whole batch. Each log entry is given its record's ID, and each request an Idempotency-Key header derived from the IDs
+// of its records, so if records are sent again after it's unclear whether Firetail received them, such as when a request times out or the
+// execution environment is frozen mid-request, Firetail can deduplicate them. If the Firetail API rejects the API token, sending to the API
+// URL with the API token is disabled & no further requests are made to it.
+func SendRecordsToSaaS(records []Record, apiUrl, apiKey string) (SendResult, error) {
+	result := SendResult{}
+	if sendingDisabled(apiUrl, apiKey) {
+		return result, &PermanentError{&SendingDisabledError{}}
+	}
+
+	requests := []logEntriesRequest{}
+	request := newLogEntriesRequest()
+
 	for _, record := range records {
-		logEntryRequest, requestTime, err := record.getLogEntryRequest()
+		logEntry, err := record.getLogEntry()
 		if err != nil {
-			errs = multierror.Append(errs, fmt.Errorf("Err creating log entry request value, err: %s", err.Error()))
+			result.RecordErrs = append(result.RecordErrs, &PermanentError{fmt.Errorf("Err creating log entry value, err: %s", err.Error())})
 			continue
 		}
 
-		responseHeaders := map[string][]string{}
-		for headerName, headerValue := range record.Response.Headers {
-			responseHeaders[headerName] = []string{headerValue}
-		}
+		logEntry.ID = record.ID()
 
-		logEntryBytes, err := json.Marshal(LogEntry{
-			DateCreated:   requestTime,
-			ExecutionTime: record.ExecutionTime,
-			Request:       *logEntryRequest,
-			Response: LogEntryResponse{
-				Body:       record.Response.Body,
-				Headers:    responseHeaders,
-				StatusCode: record.Response.StatusCode,
-			},
-			Version: The100Alpha,
-			Metadata: LogEntryMetadata{
-				Source: "lambda-extension",
-			},
-		})
+		logEntryBytes, err := DefaultLogEntryTruncation.marshalLogEntry(logEntry)
 		if err != nil {
-			errs = multierror.Append(errs, fmt.Errorf("Err marshalling record to bytes, err: %s", err.Error()))
+			result.RecordErrs = append(result.RecordErrs, &PermanentError{fmt.Errorf("Err marshalling record to bytes, err: %s", err.Error())})
 			continue
 		}
 
-		reqBytes = append(reqBytes, logEntryBytes...)
-		reqBytes = append(reqBytes, '\n')
-		marshalledRecords += 1
+		// If the log entry would take the request over the max batch size, it starts a new request
+		if request.records > 0 && len(request.reqBytes)+len(logEntryBytes)+1 > DefaultBatchLimits.MaxBytes {
+			requests = append(requests, request)
+			request = newLogEntriesRequest()
+		}
+		request.add(logEntryBytes, logEntry.ID)
 	}
 
 	// If there's no request bytes, there's no point making a request to Firetail
-	if len(reqBytes) == 0 {
-		return 0, multierror.Append(errs, fmt.Errorf("Failed to marshal any Firetail records to bytes"))
+	if request.records == 0 {
+		return result, nil
+	}
+	requests = append(requests, request)
+
+	delivered := false
+	var failedErrs []error // The errs of the records in requests which failed with a permanent error
+	var lastErr error
+	for len(requests) > 0 {
+		request := requests[0]
+		requests = requests[1:]
+
+		rejections, err := sendLogEntries(request, apiUrl, apiKey)
+		if err == nil {
+			delivered = true
+			result.Sent += request.records - len(rejections)
+			for _, rejection := range rejections {
+				result.RecordErrs = append(result.RecordErrs, &PermanentError{rejection})
+			}
+			continue
+		}
+
+		// If the request failed with a retriable error it may still have been received, so its records are counted as sent. If the batch
+		// is sent again, Firetail can deduplicate the requests which were received by their idempotency keys.
+		var permanentErr *PermanentError
+		if !errors.As(err, &permanentErr) {
+			result.Sent += request.records
+			return result, err
+		}
+
+		// If the API token was rejected, no further requests can be made so the records in the remaining requests fail too
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			disableSending(apiUrl, apiKey, authErr)
+			for _, failedRequest := range append([]logEntriesRequest{request}, requests...) {
+				failedErrs = append(failedErrs, failedRequest.recordErrs(err)...)
+			}
+			lastErr = err
+			break
+		}
+
+		// If the request was too large, its halves are sent in its place so only log entries which are too large on their own are dropped
+		var requestTooLargeErr *RequestTooLargeError
+		if errors.As(err, &requestTooLargeErr) && request.records > 1 {
+			firstHalf, secondHalf := request.split()
+			requests = append([]logEntriesRequest{firstHalf, secondHalf}, requests...)
+			continue
+		}
+
+		failedErrs = append(failedErrs, request.recordErrs(err)...)
+		lastErr = err
+	}
+
+	// If none of the requests were delivered, the batch failed as a whole. Otherwise, only the records in the requests which failed are
+	// dropped, so the records which were delivered aren't dead-lettered with them.
+	if !delivered && lastErr != nil {
+		return result, lastErr
+	}
+	result.RecordErrs = append(result.RecordErrs, failedErrs...)
+	return result, nil
+}
+
+// logEntriesRequest is the body of a request to the Firetail API, the number of log entries it holds, their lines & IDs, and a hash of
+// their IDs
+type logEntriesRequest struct {
+	reqBytes []byte
+	records  int
+	lines    [][]byte
+	ids      []string
+	idsHash  hash.Hash
+}
+
+func newLogEntriesRequest() logEntriesRequest {
+	return logEntriesRequest{reqBytes: []byte{}, idsHash: sha256.New()}
+}
+
+// add appends the marshalled log entry with the provided ID to the request
+func (r *logEntriesRequest) add(logEntryBytes []byte, id string) {
+	r.reqBytes = append(r.reqBytes, logEntryBytes...)
+	r.reqBytes = append(r.reqBytes, '\n')
+	r.records += 1
+	r.lines = append(r.lines, logEntryBytes)
+	r.ids = append(r.ids, id)
+	r.idsHash.Write([]byte(id + "\n"))
+}
+
+// split returns two requests holding the first & second halves of the request's log entries
+func (r *logEntriesRequest) split() (logEntriesRequest, logEntriesRequest) {
+	firstHalf, secondHalf := newLogEntriesRequest(), newLogEntriesRequest()
+	for i, line := range r.lines {
+		if i < len(r.lines)/2 {
+			firstHalf.add(line, r.ids[i])
+		} else {
+			secondHalf.add(line, r.ids[i])
+		}
 	}
+	return firstHalf, secondHalf
+}
+
+// recordErrs returns an err for each of the records in the request, which failed to be sent with the provided err
+func (r *logEntriesRequest) recordErrs(err error) []error {
+	recordErrs := []error{}
+	for _, id := range r.ids {
+		recordErrs = append(recordErrs, fmt.Errorf("Err sending record %s, err: %w", id, err))
+	}
+	return recordErrs
+}
 
-	req, err := http.NewRequest("POST", apiUrl, bytes.NewBuffer(reqBytes))
+// idempotencyKey returns the request's Idempotency-Key header value, which is the same whenever the same records are sent in a request
+func (r *logEntriesRequest) idempotencyKey() string {
+	return hex.EncodeToString(r.idsHash.Sum(nil))
+}
+
+// sendLogEntries sends the request's newline-delimited log entries to the Firetail API with its idempotency key, returning the log entries
+// the API reported it rejected, or the error given by getStatusCodeError if it responded with an error status code
+func sendLogEntries(request logEntriesRequest, apiUrl, apiKey string) ([]*RejectedLogEntryError, error) {
+	// The execution of this request may be frozen at any time, so it may be received by Firetail even if it appears to have failed. If it's
+	// sent again, it has the same idempotency key so Firetail can deduplicate it.
+	resp, err := postLogEntries(request.reqBytes, request.idempotencyKey(), apiUrl, apiKey, DefaultUploadCompression)
 	if err != nil {
-		return 0, multierror.Append(errs, err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("x-ft-api-key", apiKey)
+	if err := getStatusCodeError(resp, len(request.reqBytes)); err != nil {
+		return nil, err
+	}
 
-	// The execution of this request may be frozen at any time - we need to break this down so we know if the request
-	// was successfully written - if it was, should we make a second request? It risks double reporting assuming the
-	// request received a success response... 🤔
-	// TODO: investigate above.
-	resp, err := http.DefaultClient.Do(req)
+	resBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return marshalledRecords, multierror.Append(errs, fmt.Errorf("Failed to make log request, err: %s", err.Error()))
+		return nil, fmt.Errorf("Failed to read logs API response: %s", err.Error())
+	}
+	var res loggingAPIResponse
+	if err := json.Unmarshal(resBytes, &res); err != nil {
+		return nil, fmt.Errorf("Failed to decode logs API response: %s", err.Error())
+	}
+	if res.Message != "success" && res.Message != "partial success" {
+		var resMap map[string]interface{}
+		json.Unmarshal(resBytes, &resMap)
+		return nil, fmt.Errorf("Got err response from firetail api: %v", resMap)
 	}
 
-	var res map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&res)
+	rejections := []*RejectedLogEntryError{}
+	rejectedLines := map[int]bool{}
+	for _, rejected := range res.Rejected {
+		if rejected.Line < 1 || rejected.Line > request.records || rejectedLines[rejected.Line] {
+			continue
+		}
+		rejectedLines[rejected.Line] = true
+		rejections = append(rejections, &RejectedLogEntryError{Line: rejected.Line, Reason: rejected.Reason})
+	}
+	return rejections, nil
+}
+
+// postLogEntries posts the newline-delimited log entries to the Firetail API, compressed according to the UploadCompression. If the
+// Firetail API responds to a compressed upload with a 415 Unsupported Media Type, it's resent uncompressed & compression is disabled.
+func postLogEntries(reqBytes []byte, idempotencyKey, apiUrl, apiKey string, uploadCompression *UploadCompression) (*http.Response, error) {
+	body, contentEncoding := reqBytes, ""
+	if uploadCompression.enabled() {
+		compressedBody, compressedContentEncoding, err := uploadCompression.compress(reqBytes)
+		if err != nil {
+			log.Println("Err compressing log request, sending it uncompressed, err:", err.Error())
+		} else {
+			body, contentEncoding = compressedBody, compressedContentEncoding
+		}
+	}
+
+	req, err := http.NewRequest("POST", apiUrl, bytes.NewBuffer(body))
 	if err != nil {
-		return marshalledRecords, multierror.Append(errs, fmt.Errorf("Failed to decode logs API response: %s", err.Error()))
+		return nil, &PermanentError{err}
 	}
-	if res["message"] != "success" {
-		return marshalledRecords, multierror.Append(errs, fmt.Errorf("Got err response from firetail api: %v", res))
+	req.Header.Set("x-ft-api-key", apiKey)
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make log request, err: %s", err.Error())
+	}
+
+	if contentEncoding != "" && resp.StatusCode == http.StatusUnsupportedMediaType {
+		log.Printf("Firetail API rejected %s compressed log request, disabling compression", contentEncoding)
+		resp.Body.Close()
+		uploadCompression.reject()
+		return postLogEntries(reqBytes, idempotencyKey, apiUrl, apiKey, uploadCompression)
 	}
 
-	return marshalledRecords, errs
+	return resp, nil
 }
@@ -72,11 +72,11 @@ func TestSendRecordToSaas(t *testing.T) {
 
 	testRecord := getValidRecord(t)
 
-	recordsSent, err := SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
+	result, err := SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
 	assert.Nil(t, err)
-	assert.Equal(t, 1, recordsSent)
+	assert.Equal(t, 1, result.Sent)
 	assert.Equal(t,
-		"{\"dateCreated\":1668685315222,\"executionTime\":50,\"request\":{\"body\":\"\",\"headers\":{\"Content-Length\":[\"0\"],\"Host\":[\"5iagptskg6.execute-api.eu-west-2.amazonaws.com\"],\"Postman-Token\":[\"8639a798-d0e7-420a-bd98-0c5cb16c6115\"],\"User-Agent\":[\"PostmanRuntime/7.28.4\"],\"X-Amzn-Trace-Id\":[\"Root=1-63761e03-7bc79fb21f90dbbe66feba18\"],\"X-Forwarded-For\":[\"37.228.214.117\"],\"X-Forwarded-Port\":[\"443\"],\"X-Forwarded-Proto\":[\"https\"],\"accept\":[\"*/*\"],\"accept-encoding\":[\"gzip, deflate, br\"]},\"httpProtocol\":\"HTTP/1.1\",\"ip\":\"37.228.214.117\",\"method\":\"GET\",\"uri\":\"https://5iagptskg6.execute-api.eu-west-2.amazonaws.com/hi\",\"resource\":\"/hi\"},\"response\":{\"body\":\"{\\\"Description\\\":\\\"This is a test response body\\\"}\",\"headers\":{\"Test-Header-Name\":[\"Test-Header-Value\"]},\"statusCode\":200},\"version\":\"1.0.0-alpha\",\"metadata\":{\"source\":\"lambda-extension\"}}\n",
+		"{\"id\":\"2b76e34e64f3f96b905c003ae361a613\",\"dateCreated\":1668685315222,\"executionTime\":50,\"request\":{\"body\":\"\",\"headers\":{\"accept\":[\"*/*\"],\"accept-encoding\":[\"gzip\",\"deflate\",\"br\"],\"content-length\":[\"0\"],\"host\":[\"5iagptskg6.execute-api.eu-west-2.amazonaws.com\"],\"postman-token\":[\"8639a798-d0e7-420a-bd98-0c5cb16c6115\"],\"user-agent\":[\"PostmanRuntime/7.28.4\"],\"x-amzn-trace-id\":[\"Root=1-63761e03-7bc79fb21f90dbbe66feba18\"],\"x-forwarded-for\":[\"37.228.214.117\"],\"x-forwarded-port\":[\"443\"],\"x-forwarded-proto\":[\"https\"]},\"httpProtocol\":\"HTTP/1.1\",\"ip\":\"37.228.214.117\",\"method\":\"GET\",\"uri\":\"https://5iagptskg6.execute-api.eu-west-2.amazonaws.com/hi\",\"resource\":\"/hi\"},\"response\":{\"body\":\"{\\\"Description\\\":\\\"This is a test response body\\\"}\",\"headers\":{\"test-header-name\":[\"Test-Header-Value\"]},\"statusCode\":200},\"version\":\"1.1.0-alpha\",\"metadata\":{\"source\":\"lambda-extension\"}}\n",
 		string(receivedBody),
 	)
 }
@@ -88,17 +88,31 @@ func TestSendInvalidRecordToSaas(t *testing.T) {
 
 	invalidRecord := getInvalidRecord(t)
 
-	recordsSent, err := SendRecordsToSaaS([]Record{invalidRecord}, testServer.URL, "")
-	assert.Equal(t, 0, recordsSent)
-	require.NotNil(t, err)
-	assert.Contains(t, err.Error(), "json: cannot unmarshal string into Go struct field APIGatewayProxyRequest.headers of type map[string]string")
-	assert.Contains(t, err.Error(), "json: cannot unmarshal string into Go struct field APIGatewayV2HTTPRequest.headers of type map[string]string")
+	result, err := SendRecordsToSaaS([]Record{invalidRecord}, testServer.URL, "")
+	assert.Equal(t, 0, result.Sent)
+	assert.Nil(t, err)
+	require.Len(t, result.RecordErrs, 1)
+	assert.Contains(t, result.RecordErrs[0].Error(), "No event mapper detected the source of the record's event")
 	assert.Nil(t, receivedBody)
 }
 
+func TestSendValidAndInvalidRecordsToSaas(t *testing.T) {
+	var receivedBody []byte
+	testServer := getTestServer(t, &receivedBody)
+	defer testServer.Close()
+
+	result, err := SendRecordsToSaaS([]Record{getInvalidRecord(t), getValidRecord(t)}, testServer.URL, "")
+	assert.Equal(t, 1, result.Sent)
+	// The batch was delivered, so it mustn't be retried or dead-lettered because of the record which couldn't be mapped
+	assert.Nil(t, err)
+	require.Len(t, result.RecordErrs, 1)
+	assert.Contains(t, result.RecordErr().Error(), "1 error occurred")
+	assert.NotNil(t, receivedBody)
+}
+
 func TestSendRecordToInvalidApiUrl(t *testing.T) {
-	recordsSent, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, "\n", "")
-	assert.Equal(t, 0, recordsSent)
+	result, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, "\n", "")
+	assert.Equal(t, 0, result.Sent)
 	require.NotNil(t, err)
 	assert.Contains(t, err.Error(), `parse "\n": net/url: invalid control character in URL`)
 }
@@ -110,8 +124,8 @@ func TestSendRecordToUnavailableSaas(t *testing.T) {
 
 	testRecord := getValidRecord(t)
 
-	recordsSent, err := SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
-	assert.Equal(t, 1, recordsSent)
+	result, err := SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
+	assert.Equal(t, 1, result.Sent)
 	require.NotNil(t, err)
 	assert.Contains(t, err.Error(), "connect: connection refused")
 	assert.Contains(t, err.Error(), "Failed to make log request, err: Post")
@@ -124,8 +138,38 @@ func TestSendRecordWithSaasFailure(t *testing.T) {
 
 	testRecord := getValidRecord(t)
 
-	recordsSent, err := SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
-	assert.Equal(t, 1, recordsSent)
+	result, err := SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
+	assert.Equal(t, 1, result.Sent)
 	require.NotNil(t, err)
 	assert.Contains(t, err.Error(), "Got err response from firetail api: map[message:failure]")
 }
+
+func TestSendRecordsToSaaSIdempotencyKey(t *testing.T) {
+	idempotencyKeys := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKeys = append(idempotencyKeys, r.Header.Get("Idempotency-Key"))
+		if len(idempotencyKeys) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	testRecord := getValidRecord(t)
+	otherTestRecord := getValidRecord(t)
+	otherTestRecord.RequestID = "8476a536-e9f4-11e8-9739-2dfe598c3fcd"
+
+	_, err := SendRecordsToSaaS([]Record{testRecord, otherTestRecord}, testServer.URL, "")
+	require.NotNil(t, err)
+	_, err = SendRecordsToSaaS([]Record{testRecord, otherTestRecord}, testServer.URL, "")
+	require.Nil(t, err)
+	_, err = SendRecordsToSaaS([]Record{testRecord}, testServer.URL, "")
+	require.Nil(t, err)
+
+	// Retries of the same records must have the same key, but a request with different records must not
+	require.Len(t, idempotencyKeys, 3)
+	assert.Regexp(t, "^[0-9a-f]{64}$", idempotencyKeys[0])
+	assert.Equal(t, idempotencyKeys[0], idempotencyKeys[1])
+	assert.NotEqual(t, idempotencyKeys[0], idempotencyKeys[2])
+}
@@ -0,0 +1,453 @@
+package firetail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const spoolSegmentExtension = ".segment"
+
+// spoolSession prefixes the names of the segments written by this instance of the extension. It's the time the extension started, zero
+// padded so that segments sort by the order they were written in.
+var spoolSession = fmt.Sprintf("%020d", time.Now().UnixNano())
+
+// Spool configures a durable spool of batches of records on disk. Batches are written to the spool as a segment file if they fail to be
+// sent to Firetail with a retriable error, or if they're still being sent when the extension shuts down and KeepPending is called. If
+// WriteAhead is enabled, batches are instead written to the spool before they're sent, so that batches which are still being sent when
+// the execution environment is frozen and never thawed are kept too, at the cost of writing every batch to disk. A batch's segment is
+// removed once the batch has been sent or has failed with an error which isn't retriable. Kept batches are replayed when the extension
+// next starts with the same /tmp directory, such as after the execution environment is reset by a function timeout or crash, and by
+// ReplayKept once batches are being sent successfully again.
+//
+// Each line of a segment is a record's JSON preceded by its CRC-32 checksum, so corrupt or partially written records are skipped when a
+// segment is replayed rather than failing the whole segment. Records older than the MaxAge are expired rather than replayed, and the oldest
+// segments which aren't being sent are removed to keep the spool within its MaxBytes.
+type Spool struct {
+	Enabled    bool
+	WriteAhead bool          // If true, batches are written to the spool before they're sent rather than only if they can't be sent
+	Dir        string        // The directory the segments are written to
+	MaxBytes   int           // The maximum total size of the segments in the spool, in bytes
+	MaxAge     time.Duration // Records captured longer ago than this are expired rather than replayed
+
+	mutex     sync.Mutex
+	segments  int                   // The number of segments written by this instance of the extension, used to name them
+	inFlight  map[string]bool       // The paths of the segments which are being sent or replayed, which mustn't be replayed or evicted
+	pending   map[int]*pendingBatch // The batches being sent which weren't written to the spool before they were sent, by their ID
+	batches   int                   // The number of batches sent by this instance of the extension, used as their IDs
+	kept      int32                 // Set to 1 when a batch is kept in the spool, until it's next replayed
+	replaying int32                 // Set to 1 while the spool is being replayed
+}
+
+// pendingBatch is a batch being sent which wasn't written to the spool before it was sent, and the path of the segment it was written to
+// by KeepPending, if it has been
+type pendingBatch struct {
+	batch   []Record
+	segment string
+}
+
+// DefaultSpool is the Spool used for batches of records sent to Firetail
+var DefaultSpool = &Spool{
+	Enabled:  true,
+	Dir:      filepath.Join(os.TempDir(), "firetail-spool"),
+	MaxBytes: 64 * 1024 * 1024,
+	MaxAge:   time.Hour,
+}
+
+// LoadEnvVars configures the Spool from the FIRETAIL_SPOOL_ENABLED, FIRETAIL_SPOOL_WRITE_AHEAD, FIRETAIL_SPOOL_DIR, FIRETAIL_SPOOL_MAX_BYTES
+// & FIRETAIL_SPOOL_MAX_AGE env vars. FIRETAIL_SPOOL_MAX_AGE is parsed by time.ParseDuration.
+func (s *Spool) LoadEnvVars() error {
+	if enabledStr, isSet := os.LookupEnv("FIRETAIL_SPOOL_ENABLED"); isSet {
+		enabled, err := strconv.ParseBool(enabledStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_SPOOL_ENABLED invalid")
+		}
+		s.Enabled = enabled
+	}
+	if writeAheadStr, isSet := os.LookupEnv("FIRETAIL_SPOOL_WRITE_AHEAD"); isSet {
+		writeAhead, err := strconv.ParseBool(writeAheadStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_SPOOL_WRITE_AHEAD invalid")
+		}
+		s.WriteAhead = writeAhead
+	}
+	if dir, isSet := os.LookupEnv("FIRETAIL_SPOOL_DIR"); isSet {
+		if dir == "" {
+			return errors.New("FIRETAIL_SPOOL_DIR is set but empty")
+		}
+		s.Dir = dir
+	}
+	if maxBytesStr, isSet := os.LookupEnv("FIRETAIL_SPOOL_MAX_BYTES"); isSet {
+		maxBytes, err := strconv.Atoi(maxBytesStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_SPOOL_MAX_BYTES invalid")
+		}
+		if maxBytes < 1 {
+			return errors.Errorf("FIRETAIL_SPOOL_MAX_BYTES is %d but must be >= 1", maxBytes)
+		}
+		s.MaxBytes = maxBytes
+	}
+	if maxAgeStr, isSet := os.LookupEnv("FIRETAIL_SPOOL_MAX_AGE"); isSet {
+		maxAge, err := time.ParseDuration(maxAgeStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_SPOOL_MAX_AGE invalid")
+		}
+		if maxAge <= 0 {
+			return errors.Errorf("FIRETAIL_SPOOL_MAX_AGE is %s but must be > 0", maxAgeStr)
+		}
+		s.MaxAge = maxAge
+	}
+	return nil
+}
+
+// Send calls send with the batch, and keeps it in the spool to be replayed if send returns a retriable error. If the Spool's WriteAhead is
+// enabled, the batch is written to the spool before send is called, and its segment is removed unless send returns a retriable error. If
+// the Spool is disabled, send is called without spooling the batch.
+func (s *Spool) Send(batch []Record, send func([]Record) error) error {
+	if !s.Enabled {
+		return send(batch)
+	}
+	if !s.WriteAhead {
+		return s.sendPending(batch, send)
+	}
+	segment, err := s.write(batch)
+	if err != nil {
+		log.Printf("Err writing batch of %d record(s) to spool, sending it without spooling, err: %s", len(batch), err.Error())
+		return send(batch)
+	}
+	err = send(batch)
+	if isRetriable(err) {
+		s.keep(batch, segment)
+		return err
+	}
+	s.remove(segment)
+	s.release(segment)
+	return err
+}
+
+// sendPending calls send with the batch while it's pending, so that KeepPending writes it to the spool if the extension shuts down while
+// it's being sent, then writes it to the spool if send returns a retriable error & it hasn't been already
+func (s *Spool) sendPending(batch []Record, send func([]Record) error) error {
+	s.mutex.Lock()
+	if s.pending == nil {
+		s.pending = map[int]*pendingBatch{}
+	}
+	s.batches++
+	batchID := s.batches
+	s.pending[batchID] = &pendingBatch{batch: batch}
+	s.mutex.Unlock()
+
+	err := send(batch)
+
+	s.mutex.Lock()
+	segment := s.pending[batchID].segment
+	delete(s.pending, batchID)
+	s.mutex.Unlock()
+
+	if !isRetriable(err) {
+		if segment != "" {
+			s.remove(segment)
+			s.release(segment)
+		}
+		return err
+	}
+	if segment == "" {
+		var writeErr error
+		if segment, writeErr = s.write(batch); writeErr != nil {
+			log.Printf("Err writing batch of %d record(s) to spool, it can't be replayed, err: %s", len(batch), writeErr.Error())
+			return err
+		}
+	}
+	s.keep(batch, segment)
+	return err
+}
+
+// keep logs that the batch is being kept in its segment to be replayed, and releases the segment so it can be
+func (s *Spool) keep(batch []Record, segment string) {
+	log.Printf("Keeping batch of %d record(s) in spooled segment %s to be replayed", len(batch), segment)
+	atomic.StoreInt32(&s.kept, 1)
+	s.release(segment)
+}
+
+// KeepPending writes the batches which are still being sent, and weren't written to the spool before they were sent, to the spool so that
+// they're replayed when the extension next starts. It should be called when the extension shuts down, once the batches have been given as
+// long as possible to be sent. If a batch is then sent after all, its segment is removed.
+func (s *Spool) KeepPending() error {
+	if !s.Enabled {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var errs error
+	for _, pending := range s.pending {
+		if pending.segment != "" {
+			continue
+		}
+		segment, err := s.writeLocked(pending.batch)
+		if err != nil {
+			errs = multierror.Append(errs, errors.WithMessage(err, fmt.Sprintf("Err keeping batch of %d record(s) in spool", len(pending.batch))))
+			continue
+		}
+		log.Printf("Keeping pending batch of %d record(s) in spooled segment %s to be replayed", len(pending.batch), segment)
+		pending.segment = segment
+	}
+	return errs
+}
+
+// ReplayKept calls Replay if a batch has been kept in the spool since it was last replayed
+func (s *Spool) ReplayKept(send func([]Record) error) error {
+	if atomic.LoadInt32(&s.kept) == 0 {
+		return nil
+	}
+	return s.Replay(send)
+}
+
+// Replay calls send with the records of each segment in the spool which isn't being sent, oldest first, skipping records which are corrupt
+// or older than the MaxAge. Each segment is removed once send succeeds or returns an error which isn't retriable. If send returns a
+// retriable error, replaying stops & the remaining segments are kept for the next replay. If the spool is already being replayed, Replay
+// returns immediately.
+func (s *Spool) Replay(send func([]Record) error) error {
+	if !s.Enabled || !atomic.CompareAndSwapInt32(&s.replaying, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreInt32(&s.replaying, 0)
+	atomic.StoreInt32(&s.kept, 0)
+
+	segments, err := s.list()
+	if err != nil {
+		return errors.WithMessage(err, "Err listing spooled segments")
+	}
+	for _, segment := range segments {
+		segmentPath := filepath.Join(s.Dir, segment.Name())
+		if !s.acquire(segmentPath) {
+			continue
+		}
+		err := s.replaySegment(segmentPath, segment.ModTime(), send)
+		s.release(segmentPath)
+		if err != nil {
+			atomic.StoreInt32(&s.kept, 1)
+			return err
+		}
+	}
+	return nil
+}
+
+// replaySegment calls send with the records of a segment, removing it unless send returns a retriable error
+func (s *Spool) replaySegment(segmentPath string, writtenAt time.Time, send func([]Record) error) error {
+	records, err := s.read(segmentPath, writtenAt)
+	if err != nil {
+		log.Printf("Err reading spooled segment %s, removing it, err: %s", segmentPath, err.Error())
+		s.remove(segmentPath)
+		return nil
+	}
+	if len(records) == 0 {
+		s.remove(segmentPath)
+		return nil
+	}
+	log.Printf("Replaying %d record(s) from spooled segment %s", len(records), segmentPath)
+	if err := send(records); isRetriable(err) {
+		return errors.WithMessage(err, fmt.Sprintf("Err replaying spooled segment %s", segmentPath))
+	} else if err != nil {
+		log.Printf("Err replaying spooled segment %s, removing it, err: %s", segmentPath, err.Error())
+	}
+	s.remove(segmentPath)
+	return nil
+}
+
+// acquire marks the segment as in flight, returning false if it already is
+func (s *Spool) acquire(segmentPath string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.inFlight[segmentPath] {
+		return false
+	}
+	if s.inFlight == nil {
+		s.inFlight = map[string]bool{}
+	}
+	s.inFlight[segmentPath] = true
+	return true
+}
+
+// release marks the segment as no longer in flight
+func (s *Spool) release(segmentPath string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.inFlight, segmentPath)
+}
+
+// write writes the batch to a new segment in the spool, marks it as in flight & returns its path, first removing expired segments & as many
+// of the oldest segments as are needed to keep the spool within its MaxBytes
+func (s *Spool) write(batch []Record) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.writeLocked(batch)
+}
+
+// writeLocked is write for callers which hold the spool's mutex
+func (s *Spool) writeLocked(batch []Record) (string, error) {
+	var segmentBytes bytes.Buffer
+	for _, record := range batch {
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&segmentBytes, "%08x %s\n", crc32.ChecksumIEEE(recordBytes), recordBytes)
+	}
+	if segmentBytes.Len() > s.MaxBytes {
+		return "", errors.Errorf("Segment is %d bytes, which exceeds the spool's max of %d", segmentBytes.Len(), s.MaxBytes)
+	}
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return "", err
+	}
+	if err := s.evict(s.MaxBytes - segmentBytes.Len()); err != nil {
+		return "", err
+	}
+	s.segments++
+	segmentPath := filepath.Join(s.Dir, fmt.Sprintf("%s-%08d%s", spoolSession, s.segments, spoolSegmentExtension))
+	if err := os.WriteFile(segmentPath, segmentBytes.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	if s.inFlight == nil {
+		s.inFlight = map[string]bool{}
+	}
+	s.inFlight[segmentPath] = true
+	return segmentPath, nil
+}
+
+// evict removes segments last modified longer ago than the MaxAge, then removes the oldest segments until their total size is within the
+// provided number of bytes. Segments which are in flight are never removed, as they're being sent or replayed, so they may keep the total
+// size above the provided number of bytes.
+func (s *Spool) evict(maxBytes int) error {
+	segments, err := s.list()
+	if err != nil {
+		return err
+	}
+	totalBytes := 0
+	for _, segment := range segments {
+		totalBytes += int(segment.Size())
+	}
+	for _, segment := range segments {
+		if totalBytes <= maxBytes && time.Since(segment.ModTime()) <= s.MaxAge {
+			continue
+		}
+		segmentPath := filepath.Join(s.Dir, segment.Name())
+		if s.inFlight[segmentPath] {
+			continue
+		}
+		if err := os.Remove(segmentPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("Evicted spooled segment %s", segmentPath)
+		totalBytes -= int(segment.Size())
+	}
+	return nil
+}
+
+// list returns the segments in the spool, oldest first
+func (s *Spool) list() ([]os.FileInfo, error) {
+	dirEntries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	segments := []os.FileInfo{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), spoolSegmentExtension) {
+			continue
+		}
+		segment, err := dirEntry.Info()
+		if err != nil {
+			// The segment may have been removed since the directory was read
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// read returns the records in a segment which aren't corrupt or expired. Records captured at an unknown time are assumed to have been
+// captured when the segment was written.
+func (s *Spool) read(segmentPath string, writtenAt time.Time) ([]Record, error) {
+	segmentFile, err := os.Open(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer segmentFile.Close()
+
+	records := []Record{}
+	corruptRecords, expiredRecords := 0, 0
+	reader := bufio.NewReader(segmentFile)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A final line without a newline was only partially written
+			if len(line) > 0 {
+				corruptRecords++
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		record, ok := parseSpooledRecord(line[:len(line)-1])
+		if !ok {
+			corruptRecords++
+			continue
+		}
+		capturedAt := writtenAt
+		if record.CapturedAt != 0 {
+			capturedAt = time.UnixMilli(record.CapturedAt)
+		}
+		if time.Since(capturedAt) > s.MaxAge {
+			expiredRecords++
+			continue
+		}
+		records = append(records, record)
+	}
+
+	if corruptRecords > 0 {
+		log.Printf("Skipped %d corrupt record(s) in spooled segment %s", corruptRecords, segmentPath)
+	}
+	if expiredRecords > 0 {
+		log.Printf("Expired %d record(s) in spooled segment %s", expiredRecords, segmentPath)
+	}
+	return records, nil
+}
+
+// parseSpooledRecord parses a line of a segment, returning false if its checksum doesn't match or it isn't a valid record
+func parseSpooledRecord(line []byte) (Record, bool) {
+	if len(line) < 9 || line[8] != ' ' {
+		return Record{}, false
+	}
+	checksum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil || uint32(checksum) != crc32.ChecksumIEEE(line[9:]) {
+		return Record{}, false
+	}
+	var record Record
+	if err := json.Unmarshal(line[9:], &record); err != nil {
+		return Record{}, false
+	}
+	return record, true
+}
+
+func (s *Spool) remove(segmentPath string) {
+	if err := os.Remove(segmentPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Err removing spooled segment %s, err: %s", segmentPath, err.Error())
+	}
+}
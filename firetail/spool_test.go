@@ -0,0 +1,299 @@
+package firetail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestSpool(t *testing.T) *Spool {
+	return &Spool{Enabled: true, Dir: t.TempDir(), MaxBytes: 1024 * 1024, MaxAge: time.Hour}
+}
+
+// writePreviousSegment writes the batch to the spool as if it had been written by a previous instance of the extension
+func writePreviousSegment(t *testing.T, spool *Spool, batch []Record) string {
+	segmentPath, err := spool.write(batch)
+	require.Nil(t, err)
+	previousSegmentPath := filepath.Join(spool.Dir, fmt.Sprintf("%020d-%08d%s", 0, spool.segments, spoolSegmentExtension))
+	require.Nil(t, os.Rename(segmentPath, previousSegmentPath))
+	return previousSegmentPath
+}
+
+func getSegmentNames(t *testing.T, spool *Spool) []string {
+	segments, err := spool.list()
+	require.Nil(t, err)
+	segmentNames := []string{}
+	for _, segment := range segments {
+		segmentNames = append(segmentNames, segment.Name())
+	}
+	return segmentNames
+}
+
+func TestSpoolSendDoesntWriteSentBatch(t *testing.T) {
+	spool := getTestSpool(t)
+	err := spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+		// Without write-ahead, the batch mustn't be spooled while it's being sent
+		assert.Empty(t, getSegmentNames(t, spool))
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolSendWriteAheadRemovesSentSegment(t *testing.T) {
+	spool := getTestSpool(t)
+	spool.WriteAhead = true
+	err := spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+		// With write-ahead, the batch must be spooled while it's being sent
+		assert.Len(t, getSegmentNames(t, spool), 1)
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolKeepPending(t *testing.T) {
+	spool := getTestSpool(t)
+	sending := make(chan struct{})
+	unblock := make(chan struct{})
+	sent := make(chan error)
+	go func() {
+		sent <- spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+			close(sending)
+			<-unblock
+			return nil
+		})
+	}()
+	<-sending
+
+	// The batch is still being sent on shutdown, so it must be kept, but not replayed while it's still being sent
+	require.Nil(t, spool.KeepPending())
+	assert.Len(t, getSegmentNames(t, spool), 1)
+	require.Nil(t, spool.Replay(func(batch []Record) error {
+		t.Fatal("A batch being sent mustn't be replayed")
+		return nil
+	}))
+
+	// If it's then sent after all, its segment is removed
+	close(unblock)
+	require.Nil(t, <-sent)
+	assert.Empty(t, getSegmentNames(t, spool))
+	require.Nil(t, spool.KeepPending())
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolSendRemovesPermanentlyFailedSegment(t *testing.T) {
+	spool := getTestSpool(t)
+	err := spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+		return &PermanentError{fmt.Errorf("Got 400 response from firetail api")}
+	})
+	require.NotNil(t, err)
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolSendKeepsRetriableSegment(t *testing.T) {
+	spool := getTestSpool(t)
+	err := spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+		return &RetriableError{Err: fmt.Errorf("Got 503 response from firetail api")}
+	})
+	require.NotNil(t, err)
+	assert.Len(t, getSegmentNames(t, spool), 1)
+}
+
+func TestSpoolSendDoesntKeepDeadLetteredBatch(t *testing.T) {
+	for _, writeAhead := range []bool{false, true} {
+		t.Run(fmt.Sprintf("WriteAhead=%t", writeAhead), func(t *testing.T) {
+			spool := getTestSpool(t)
+			spool.WriteAhead = writeAhead
+			deadLetters := [][]Record{}
+			retryPolicy := getTestRetryPolicy(&deadLetters)
+
+			err := spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+				return retryPolicy.SendWithRetries(batch, func(batch []Record) error {
+					return &RetriableError{Err: fmt.Errorf("Got 503 response from firetail api")}
+				})
+			})
+			require.NotNil(t, err)
+			assert.Len(t, deadLetters, 1)
+			assert.Empty(t, getSegmentNames(t, spool))
+
+			// The dead-lettered batch mustn't be replayed
+			replayedBatches := 0
+			require.Nil(t, spool.Replay(func(batch []Record) error {
+				replayedBatches++
+				return nil
+			}))
+			assert.Equal(t, 0, replayedBatches)
+		})
+	}
+}
+
+func TestSpoolSendDisabled(t *testing.T) {
+	spool := getTestSpool(t)
+	spool.Enabled = false
+	batchesSent := 0
+	err := spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+		batchesSent++
+		return &RetriableError{Err: fmt.Errorf("Got 503 response from firetail api")}
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, 1, batchesSent)
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolReplay(t *testing.T) {
+	spool := getTestSpool(t)
+	testRecord := getValidRecord(t)
+	testRecord.CapturedAt = time.Now().UnixMilli()
+	writePreviousSegment(t, spool, []Record{testRecord, testRecord})
+	writePreviousSegment(t, spool, []Record{testRecord})
+
+	// Segments which are still being sent mustn't be replayed
+	_, err := spool.write([]Record{testRecord})
+	require.Nil(t, err)
+
+	replayedBatches := [][]Record{}
+	err = spool.Replay(func(batch []Record) error {
+		replayedBatches = append(replayedBatches, batch)
+		return nil
+	})
+	require.Nil(t, err)
+	require.Len(t, replayedBatches, 2)
+	assert.Equal(t, []Record{testRecord, testRecord}, replayedBatches[0])
+	assert.Equal(t, []Record{testRecord}, replayedBatches[1])
+	assert.Len(t, getSegmentNames(t, spool), 1)
+}
+
+func TestSpoolReplayStopsOnRetriableError(t *testing.T) {
+	spool := getTestSpool(t)
+	writePreviousSegment(t, spool, []Record{getValidRecord(t)})
+	writePreviousSegment(t, spool, []Record{getValidRecord(t)})
+
+	replays := 0
+	err := spool.Replay(func(batch []Record) error {
+		replays++
+		return &RetriableError{Err: fmt.Errorf("Got 503 response from firetail api")}
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Err replaying spooled segment")
+	assert.Equal(t, 1, replays)
+	assert.Len(t, getSegmentNames(t, spool), 2)
+}
+
+func TestSpoolReplaySkipsCorruptRecords(t *testing.T) {
+	spool := getTestSpool(t)
+	testRecord := getValidRecord(t)
+	segmentPath := writePreviousSegment(t, spool, []Record{testRecord, testRecord, testRecord})
+
+	// Corrupt the first record, and truncate the last as if it were only partially written
+	segmentBytes, err := os.ReadFile(segmentPath)
+	require.Nil(t, err)
+	segmentBytes[20] ^= 0xff
+	segmentBytes = segmentBytes[:len(segmentBytes)-10]
+	require.Nil(t, os.WriteFile(segmentPath, segmentBytes, 0600))
+
+	replayedBatches := [][]Record{}
+	err = spool.Replay(func(batch []Record) error {
+		replayedBatches = append(replayedBatches, batch)
+		return nil
+	})
+	require.Nil(t, err)
+	require.Len(t, replayedBatches, 1)
+	assert.Equal(t, []Record{testRecord}, replayedBatches[0])
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolReplayExpiresRecords(t *testing.T) {
+	spool := getTestSpool(t)
+	expiredRecord := getValidRecord(t)
+	expiredRecord.CapturedAt = time.Now().Add(-2 * time.Hour).UnixMilli()
+	writePreviousSegment(t, spool, []Record{expiredRecord})
+
+	err := spool.Replay(func(batch []Record) error {
+		t.Fatal("Expired records shouldn't be replayed")
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Empty(t, getSegmentNames(t, spool))
+}
+
+func TestSpoolWriteEvictsOldestSegments(t *testing.T) {
+	spool := getTestSpool(t)
+	segmentPath, err := spool.write([]Record{getValidRecord(t)})
+	require.Nil(t, err)
+	spool.release(segmentPath)
+	segment, err := os.Stat(segmentPath)
+	require.Nil(t, err)
+
+	// The spool can hold two segments, so writing a third evicts the first
+	spool.MaxBytes = int(segment.Size()) * 2
+	secondSegmentPath, err := spool.write([]Record{getValidRecord(t)})
+	require.Nil(t, err)
+	_, err = spool.write([]Record{getValidRecord(t)})
+	require.Nil(t, err)
+
+	segmentNames := getSegmentNames(t, spool)
+	require.Len(t, segmentNames, 2)
+	assert.NotContains(t, segmentNames, filepath.Base(segmentPath))
+
+	// The remaining segments are in flight, so writing another can't evict them
+	_, err = spool.write([]Record{getValidRecord(t)})
+	require.Nil(t, err)
+	assert.Contains(t, getSegmentNames(t, spool), filepath.Base(secondSegmentPath))
+	assert.Len(t, getSegmentNames(t, spool), 3)
+
+	_, err = spool.write([]Record{getValidRecord(t), getValidRecord(t), getValidRecord(t)})
+	require.NotNil(t, err)
+	assert.Regexp(t, "^Segment is [0-9]+ bytes, which exceeds the spool's max of [0-9]+$", err.Error())
+}
+
+func TestSpoolLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_SPOOL_ENABLED", "false")
+	t.Setenv("FIRETAIL_SPOOL_WRITE_AHEAD", "true")
+	t.Setenv("FIRETAIL_SPOOL_DIR", "/tmp/test-spool")
+	t.Setenv("FIRETAIL_SPOOL_MAX_BYTES", "2048")
+	t.Setenv("FIRETAIL_SPOOL_MAX_AGE", "10m")
+	spool := &Spool{Enabled: true}
+	require.Nil(t, spool.LoadEnvVars())
+	assert.Equal(t, &Spool{Enabled: false, WriteAhead: true, Dir: "/tmp/test-spool", MaxBytes: 2048, MaxAge: 10 * time.Minute}, spool)
+
+	t.Setenv("FIRETAIL_SPOOL_MAX_AGE", "0s")
+	err := (&Spool{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_SPOOL_MAX_AGE is 0s but must be > 0", err.Error())
+
+	t.Setenv("FIRETAIL_SPOOL_MAX_BYTES", "0")
+	err = (&Spool{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_SPOOL_MAX_BYTES is 0 but must be >= 1", err.Error())
+}
+
+func TestSpoolReplayKept(t *testing.T) {
+	spool := getTestSpool(t)
+	replayedBatches := [][]Record{}
+	replay := func(batch []Record) error {
+		replayedBatches = append(replayedBatches, batch)
+		return nil
+	}
+
+	// Nothing has been kept, so nothing is replayed
+	writePreviousSegment(t, spool, []Record{getValidRecord(t)})
+	require.Nil(t, spool.ReplayKept(replay))
+	assert.Empty(t, replayedBatches)
+
+	// Once a batch has been kept, it's replayed along with any other segments which aren't being sent
+	spool.Send([]Record{getValidRecord(t)}, func(batch []Record) error {
+		return &RetriableError{Err: &CircuitOpenError{OverflowPolicy: OverflowPolicySpool}}
+	})
+	require.Nil(t, spool.ReplayKept(replay))
+	assert.Len(t, replayedBatches, 2)
+	assert.Empty(t, getSegmentNames(t, spool))
+
+	require.Nil(t, spool.ReplayKept(replay))
+	assert.Len(t, replayedBatches, 2)
+}
@@ -0,0 +1,80 @@
+package firetail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// The algorithms bulk uploads to Firetail can be compressed with. Only gzip is supported, as zstd would require a dependency outside the
+// standard library, and the Firetail logging API isn't known to accept it.
+const (
+	UploadCompressionNone = "none"
+	UploadCompressionGzip = "gzip"
+)
+
+// UploadCompression configures the compression of bulk uploads to Firetail. If the Firetail API responds to a compressed upload with a
+// 415 Unsupported Media Type, the upload is resent uncompressed & compression is disabled for all further uploads.
+type UploadCompression struct {
+	Algorithm string // One of UploadCompressionNone or UploadCompressionGzip
+	Level     int    // The gzip compression level, from gzip.HuffmanOnly to gzip.BestCompression
+	rejected  int32  // Set to 1 once the Firetail API has rejected a compressed upload
+}
+
+// DefaultUploadCompression is the UploadCompression used for bulk uploads to Firetail. It doesn't compress uploads until configured otherwise.
+var DefaultUploadCompression = &UploadCompression{Algorithm: UploadCompressionNone, Level: gzip.DefaultCompression}
+
+// LoadEnvVars configures the UploadCompression from the FIRETAIL_UPLOAD_COMPRESSION & FIRETAIL_UPLOAD_COMPRESSION_LEVEL env vars
+func (c *UploadCompression) LoadEnvVars() error {
+	if algorithm, isSet := os.LookupEnv("FIRETAIL_UPLOAD_COMPRESSION"); isSet {
+		switch algorithm {
+		case UploadCompressionNone, UploadCompressionGzip:
+			c.Algorithm = algorithm
+		default:
+			return errors.Errorf("FIRETAIL_UPLOAD_COMPRESSION is %s but must be one of %s or %s", algorithm,
+				UploadCompressionNone, UploadCompressionGzip)
+		}
+	}
+	if levelStr, isSet := os.LookupEnv("FIRETAIL_UPLOAD_COMPRESSION_LEVEL"); isSet {
+		level, err := strconv.Atoi(levelStr)
+		if err != nil {
+			return errors.WithMessage(err, "FIRETAIL_UPLOAD_COMPRESSION_LEVEL invalid")
+		}
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return errors.Errorf("FIRETAIL_UPLOAD_COMPRESSION_LEVEL is %d but must be >= %d and <= %d", level, gzip.HuffmanOnly,
+				gzip.BestCompression)
+		}
+		c.Level = level
+	}
+	return nil
+}
+
+// enabled returns true if uploads should be compressed
+func (c *UploadCompression) enabled() bool {
+	return c.Algorithm == UploadCompressionGzip && atomic.LoadInt32(&c.rejected) == 0
+}
+
+// reject disables compression for all further uploads, after the Firetail API has rejected a compressed upload
+func (c *UploadCompression) reject() {
+	atomic.StoreInt32(&c.rejected, 1)
+}
+
+// compress returns the upload body compressed, and the value for its Content-Encoding header
+func (c *UploadCompression) compress(body []byte) ([]byte, string, error) {
+	var compressedBody bytes.Buffer
+	gzipWriter, err := gzip.NewWriterLevel(&compressedBody, c.Level)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := gzipWriter.Write(body); err != nil {
+		return nil, "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, "", err
+	}
+	return compressedBody.Bytes(), UploadCompressionGzip, nil
+}
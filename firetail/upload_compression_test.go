@@ -0,0 +1,91 @@
+package firetail
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendRecordsToSaaSGzip(t *testing.T) {
+	defaultUploadCompression := DefaultUploadCompression
+	defer func() { DefaultUploadCompression = defaultUploadCompression }()
+	DefaultUploadCompression = &UploadCompression{Algorithm: UploadCompressionGzip, Level: gzip.BestCompression}
+
+	var contentEncoding string
+	var receivedBody []byte
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentEncoding = r.Header.Get("Content-Encoding")
+		gzipReader, err := gzip.NewReader(r.Body)
+		require.Nil(t, err)
+		receivedBody, err = io.ReadAll(gzipReader)
+		require.Nil(t, err)
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	var uncompressedBody []byte
+	uncompressedTestServer := getTestServer(t, &uncompressedBody)
+	defer uncompressedTestServer.Close()
+	DefaultUploadCompression.Algorithm = UploadCompressionNone
+	_, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, uncompressedTestServer.URL, "")
+	require.Nil(t, err)
+
+	DefaultUploadCompression.Algorithm = UploadCompressionGzip
+	result, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "")
+	require.Nil(t, err)
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, "gzip", contentEncoding)
+	assert.Equal(t, string(uncompressedBody), string(receivedBody))
+}
+
+func TestSendRecordsToSaaSGzipRejected(t *testing.T) {
+	defaultUploadCompression := DefaultUploadCompression
+	defer func() { DefaultUploadCompression = defaultUploadCompression }()
+	DefaultUploadCompression = &UploadCompression{Algorithm: UploadCompressionGzip, Level: gzip.DefaultCompression}
+
+	contentEncodings := []string{}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentEncodings = append(contentEncodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		fmt.Fprintf(w, `{"message":"success"}`)
+	}))
+	defer testServer.Close()
+
+	result, err := SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "")
+	require.Nil(t, err)
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, []string{"gzip", ""}, contentEncodings)
+
+	// Compression stays disabled for later uploads
+	result, err = SendRecordsToSaaS([]Record{getValidRecord(t)}, testServer.URL, "")
+	require.Nil(t, err)
+	assert.Equal(t, 1, result.Sent)
+	assert.Equal(t, []string{"gzip", "", ""}, contentEncodings)
+}
+
+func TestUploadCompressionLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_UPLOAD_COMPRESSION", "gzip")
+	t.Setenv("FIRETAIL_UPLOAD_COMPRESSION_LEVEL", "9")
+	uploadCompression := &UploadCompression{Algorithm: UploadCompressionNone}
+	require.Nil(t, uploadCompression.LoadEnvVars())
+	assert.Equal(t, &UploadCompression{Algorithm: UploadCompressionGzip, Level: gzip.BestCompression}, uploadCompression)
+
+	t.Setenv("FIRETAIL_UPLOAD_COMPRESSION_LEVEL", "10")
+	err := (&UploadCompression{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_UPLOAD_COMPRESSION_LEVEL is 10 but must be >= -2 and <= 9", err.Error())
+
+	t.Setenv("FIRETAIL_UPLOAD_COMPRESSION", "zstd")
+	err = (&UploadCompression{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_UPLOAD_COMPRESSION is zstd but must be one of none or gzip", err.Error())
+}
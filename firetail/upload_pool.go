@@ -0,0 +1,171 @@
+package firetail
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// UploadConcurrency configures how many batches of records are uploaded to Firetail at once, so that a backlog of batches, such as after
+// the execution environment is thawed, isn't held up behind a single slow request. The number of batches in flight, being uploaded or
+// waiting for a worker, is capped by the MaxInFlightBatches & MaxInFlightBytes, beyond which submitting a batch blocks.
+//
+// With one worker, batches are uploaded in the order they were batched. With more, a batch may be uploaded before batches batched earlier
+// than it have finished uploading, or been retried, so log entries may reach Firetail out of order, but the log entries within a batch
+// keep their order. Each log entry carries its own dateCreated & ID regardless of the order it's received in.
+type UploadConcurrency struct {
+	Workers            int // The number of batches uploaded at once
+	MaxInFlightBatches int // The maximum number of batches being uploaded or waiting for a worker
+	MaxInFlightBytes   int // The maximum approximate size of the batches being uploaded or waiting for a worker. A larger batch is uploaded alone
+}
+
+// DefaultUploadConcurrency is the UploadConcurrency used to upload batches of records to Firetail
+var DefaultUploadConcurrency = &UploadConcurrency{
+	Workers:            4,
+	MaxInFlightBatches: 8,
+	MaxInFlightBytes:   8 * 1024 * 1024,
+}
+
+// LoadEnvVars configures the UploadConcurrency from the FIRETAIL_UPLOAD_WORKERS, FIRETAIL_MAX_IN_FLIGHT_BATCHES &
+// FIRETAIL_MAX_IN_FLIGHT_BYTES env vars
+func (c *UploadConcurrency) LoadEnvVars() error {
+	for _, intEnvVar := range []struct {
+		name  string
+		value *int
+	}{
+		{"FIRETAIL_UPLOAD_WORKERS", &c.Workers},
+		{"FIRETAIL_MAX_IN_FLIGHT_BATCHES", &c.MaxInFlightBatches},
+		{"FIRETAIL_MAX_IN_FLIGHT_BYTES", &c.MaxInFlightBytes},
+	} {
+		valueStr, isSet := os.LookupEnv(intEnvVar.name)
+		if !isSet {
+			continue
+		}
+		value, err := strconv.Atoi(valueStr)
+		if err != nil {
+			return errors.WithMessage(err, intEnvVar.name+" invalid")
+		}
+		if value < 1 {
+			return errors.Errorf("%s is %d but must be >= 1", intEnvVar.name, value)
+		}
+		*intEnvVar.value = value
+	}
+	return nil
+}
+
+// UploadPool uploads batches of records on a pool of workers, limited by its UploadConcurrency. The execution environment may be frozen
+// or shut down while batches are in flight, so Wait can be used to wait for them to finish, such as after a Batcher has been flushed.
+type UploadPool struct {
+	concurrency     UploadConcurrency
+	uploads         chan func()
+	mutex           sync.Mutex
+	inFlightBatches int
+	inFlightBytes   int
+	released        chan struct{} // Closed & replaced whenever a batch finishes uploading
+	shutdown        bool
+	workers         sync.WaitGroup
+}
+
+// NewUploadPool returns an UploadPool with its workers started
+func NewUploadPool(concurrency UploadConcurrency) *UploadPool {
+	p := &UploadPool{
+		concurrency: concurrency,
+		uploads:     make(chan func(), concurrency.MaxInFlightBatches),
+		released:    make(chan struct{}),
+	}
+	p.workers.Add(concurrency.Workers)
+	for i := 0; i < concurrency.Workers; i++ {
+		go func() {
+			defer p.workers.Done()
+			for upload := range p.uploads {
+				upload()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues the batch to be passed to the upload func by a worker, blocking until the batch is within the UploadPool's in-flight
+// limits. If the UploadPool has been shut down, the batch is passed to the upload func before Submit returns.
+func (p *UploadPool) Submit(batch []Record, upload func([]Record)) {
+	batchBytes := 0
+	for _, record := range batch {
+		batchBytes += record.size()
+	}
+	for {
+		p.mutex.Lock()
+		if p.shutdown {
+			p.mutex.Unlock()
+			upload(batch)
+			return
+		}
+		if p.inFlightBatches == 0 || (p.inFlightBatches < p.concurrency.MaxInFlightBatches &&
+			p.inFlightBytes+batchBytes <= p.concurrency.MaxInFlightBytes) {
+			p.inFlightBatches++
+			p.inFlightBytes += batchBytes
+			// The uploads channel's buffer fits the max in-flight batches, so this never blocks
+			p.uploads <- func() {
+				upload(batch)
+				p.release(batchBytes)
+			}
+			p.mutex.Unlock()
+			return
+		}
+		released := p.released
+		p.mutex.Unlock()
+		<-released
+	}
+}
+
+func (p *UploadPool) release(batchBytes int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inFlightBatches--
+	p.inFlightBytes -= batchBytes
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// Wait returns once no batches are in flight. If the context is done first, its err is returned.
+func (p *UploadPool) Wait(ctx context.Context) error {
+	for {
+		p.mutex.Lock()
+		if p.inFlightBatches == 0 {
+			p.mutex.Unlock()
+			return nil
+		}
+		released := p.released
+		p.mutex.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Shutdown stops the UploadPool's workers once the batches in flight have been uploaded, and returns once they have stopped. If the
+// context is done first, its err is returned & the workers are left to finish the batches in flight.
+func (p *UploadPool) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.shutdown {
+		p.shutdown = true
+		close(p.uploads)
+	}
+	p.mutex.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
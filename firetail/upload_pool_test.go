@@ -0,0 +1,162 @@
+package firetail
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadPoolUploadsConcurrently(t *testing.T) {
+	pool := NewUploadPool(UploadConcurrency{Workers: 2, MaxInFlightBatches: 2, MaxInFlightBytes: 1024 * 1024})
+	started := make(chan struct{}, 2)
+	unblock := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) {
+			started <- struct{}{}
+			<-unblock
+		})
+	}
+
+	// Both uploads must be started before either has finished
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("Uploads weren't started concurrently")
+		}
+	}
+	close(unblock)
+	require.Nil(t, pool.Shutdown(context.Background()))
+}
+
+func TestUploadPoolSingleWorkerKeepsOrder(t *testing.T) {
+	pool := NewUploadPool(UploadConcurrency{Workers: 1, MaxInFlightBatches: 4, MaxInFlightBytes: 1024 * 1024})
+	uploadedBatches := []int{}
+	for i := 0; i < 10; i++ {
+		batchNumber := i
+		pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) {
+			uploadedBatches = append(uploadedBatches, batchNumber)
+		})
+	}
+	require.Nil(t, pool.Shutdown(context.Background()))
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, uploadedBatches)
+}
+
+// assertSubmitBlocks submits the batch to the pool, asserting that Submit blocks until unblock is closed
+func assertSubmitBlocks(t *testing.T, pool *UploadPool, batch []Record, unblock chan struct{}) {
+	submitted := make(chan struct{})
+	go func() {
+		pool.Submit(batch, func(batch []Record) {})
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("Submit should block while the pool is at its in-flight limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(unblock)
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("Submit should unblock once a batch has finished uploading")
+	}
+}
+
+func TestUploadPoolMaxInFlightBatches(t *testing.T) {
+	pool := NewUploadPool(UploadConcurrency{Workers: 1, MaxInFlightBatches: 2, MaxInFlightBytes: 1024 * 1024})
+	unblock := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) { <-unblock })
+	}
+	assertSubmitBlocks(t, pool, []Record{getValidRecord(t)}, unblock)
+	require.Nil(t, pool.Shutdown(context.Background()))
+}
+
+func TestUploadPoolMaxInFlightBytes(t *testing.T) {
+	testRecord := getValidRecord(t)
+	pool := NewUploadPool(UploadConcurrency{Workers: 4, MaxInFlightBatches: 4, MaxInFlightBytes: testRecord.size() * 3})
+	unblock := make(chan struct{})
+	pool.Submit([]Record{testRecord, testRecord}, func(batch []Record) { <-unblock })
+	assertSubmitBlocks(t, pool, []Record{testRecord, testRecord}, unblock)
+	require.Nil(t, pool.Shutdown(context.Background()))
+}
+
+func TestUploadPoolOversizedBatchUploadedAlone(t *testing.T) {
+	testRecord := getValidRecord(t)
+	pool := NewUploadPool(UploadConcurrency{Workers: 2, MaxInFlightBatches: 2, MaxInFlightBytes: testRecord.size()})
+	uploaded := false
+	pool.Submit([]Record{testRecord, testRecord}, func(batch []Record) { uploaded = true })
+	require.Nil(t, pool.Shutdown(context.Background()))
+	assert.True(t, uploaded)
+}
+
+func TestUploadPoolWait(t *testing.T) {
+	pool := NewUploadPool(UploadConcurrency{Workers: 1, MaxInFlightBatches: 1, MaxInFlightBytes: 1024 * 1024})
+	require.Nil(t, pool.Wait(context.Background()))
+
+	unblock := make(chan struct{})
+	pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) { <-unblock })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pool.Wait(ctx))
+
+	close(unblock)
+	require.Nil(t, pool.Wait(context.Background()))
+	require.Nil(t, pool.Shutdown(context.Background()))
+}
+
+func TestUploadPoolShutdown(t *testing.T) {
+	pool := NewUploadPool(UploadConcurrency{Workers: 2, MaxInFlightBatches: 4, MaxInFlightBytes: 1024 * 1024})
+	uploadsMutex := sync.Mutex{}
+	uploads := 0
+	for i := 0; i < 4; i++ {
+		pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) {
+			time.Sleep(10 * time.Millisecond)
+			uploadsMutex.Lock()
+			uploads++
+			uploadsMutex.Unlock()
+		})
+	}
+
+	// Shutdown must wait for the batches in flight to be uploaded
+	require.Nil(t, pool.Shutdown(context.Background()))
+	assert.Equal(t, 4, uploads)
+
+	// Once the pool has been shut down, batches are uploaded before Submit returns
+	uploadedAfterShutdown := false
+	pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) { uploadedAfterShutdown = true })
+	assert.True(t, uploadedAfterShutdown)
+}
+
+func TestUploadPoolShutdownTimeout(t *testing.T) {
+	pool := NewUploadPool(UploadConcurrency{Workers: 1, MaxInFlightBatches: 1, MaxInFlightBytes: 1024 * 1024})
+	unblock := make(chan struct{})
+	defer close(unblock)
+	pool.Submit([]Record{getValidRecord(t)}, func(batch []Record) { <-unblock })
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pool.Shutdown(ctx))
+}
+
+func TestUploadConcurrencyLoadEnvVars(t *testing.T) {
+	t.Setenv("FIRETAIL_UPLOAD_WORKERS", "2")
+	t.Setenv("FIRETAIL_MAX_IN_FLIGHT_BATCHES", "3")
+	t.Setenv("FIRETAIL_MAX_IN_FLIGHT_BYTES", "1024")
+	concurrency := &UploadConcurrency{}
+	require.Nil(t, concurrency.LoadEnvVars())
+	assert.Equal(t, &UploadConcurrency{Workers: 2, MaxInFlightBatches: 3, MaxInFlightBytes: 1024}, concurrency)
+
+	t.Setenv("FIRETAIL_MAX_IN_FLIGHT_BYTES", "lots")
+	err := (&UploadConcurrency{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "FIRETAIL_MAX_IN_FLIGHT_BYTES invalid")
+
+	t.Setenv("FIRETAIL_UPLOAD_WORKERS", "0")
+	err = (&UploadConcurrency{}).LoadEnvVars()
+	require.NotNil(t, err)
+	assert.Equal(t, "FIRETAIL_UPLOAD_WORKERS is 0 but must be >= 1", err.Error())
+}
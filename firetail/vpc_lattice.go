@@ -0,0 +1,158 @@
+package firetail
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// vpcLatticeV1Request is the event VPC Lattice invokes Lambda targets with when the target group's event structure version is V1
+type vpcLatticeV1Request struct {
+	RawPath               string            `json:"raw_path"`
+	Method                string            `json:"method"`
+	Headers               map[string]string `json:"headers"`
+	QueryStringParameters map[string]string `json:"query_string_parameters"`
+	Body                  string            `json:"body"`
+	IsBase64Encoded       bool              `json:"is_base64_encoded"`
+}
+
+// vpcLatticeV2Request is the event VPC Lattice invokes Lambda targets with when the target group's event structure version is V2
+type vpcLatticeV2Request struct {
+	Version               string                            `json:"version"`
+	Path                  string                            `json:"path"`
+	Method                string                            `json:"method"`
+	Headers               map[string][]string               `json:"headers"`
+	QueryStringParameters map[string][]string               `json:"queryStringParameters"`
+	Body                  string                            `json:"body"`
+	IsBase64Encoded       bool                              `json:"isBase64Encoded"`
+	RequestContext        vpcLatticeV2RequestRequestContext `json:"requestContext"`
+}
+
+type vpcLatticeV2RequestRequestContext struct {
+	ServiceNetworkArn string             `json:"serviceNetworkArn"`
+	ServiceArn        string             `json:"serviceArn"`
+	TargetGroupArn    string             `json:"targetGroupArn"`
+	Identity          vpcLatticeIdentity `json:"identity"`
+	Region            string             `json:"region"`
+	TimeEpoch         string             `json:"timeEpoch"` // The time of the request in UNIX microseconds
+}
+
+// vpcLatticeIdentity is the identity of the caller of a VPC Lattice service. V2 events include it in their request context, and V1 events
+// in the x-amzn-lattice-identity & x-amzn-source-vpc headers.
+type vpcLatticeIdentity struct {
+	SourceVpcArn   string `json:"sourceVpcArn"`
+	Type           string `json:"type"`
+	Principal      string `json:"principal"`
+	PrincipalOrgID string `json:"principalOrgID"`
+	SessionName    string `json:"sessionName"`
+}
+
+// getVPCLatticeLogEntry returns a Firetail SaaS LogEntry for the firetail Record, and true, if the Record's Event value is a VPC Lattice
+// request of either event structure version. Otherwise, it returns nil and false. VPC Lattice responses have the same shape as the
+// RecordResponse, so they're handled by getLogEntryResponse.
+func (r *Record) getVPCLatticeLogEntry() (*LogEntry, bool) {
+	var vpcLatticeV2Request vpcLatticeV2Request
+	if err := json.Unmarshal(r.Event, &vpcLatticeV2Request); err == nil &&
+		vpcLatticeV2Request.Method != "" && vpcLatticeV2Request.RequestContext.ServiceNetworkArn != "" {
+		headers := normaliseHeaders(nil, vpcLatticeV2Request.Headers)
+		requestTime := int64(0)
+		if timeEpochMicroseconds, err := strconv.ParseInt(vpcLatticeV2Request.RequestContext.TimeEpoch, 10, 64); err == nil {
+			requestTime = timeEpochMicroseconds / 1000
+		}
+		logEntry := r.newLogEntry(
+			newVPCLatticeLogEntryRequest(vpcLatticeV2Request.Method, vpcLatticeV2Request.Path, vpcLatticeV2Request.Body,
+				vpcLatticeV2Request.IsBase64Encoded, headers),
+			requestTime,
+		)
+		logEntry.Metadata.VPCLattice = &LogEntryVPCLatticeMetadata{
+			ServiceNetworkArn: vpcLatticeV2Request.RequestContext.ServiceNetworkArn,
+			ServiceArn:        vpcLatticeV2Request.RequestContext.ServiceArn,
+			TargetGroupArn:    vpcLatticeV2Request.RequestContext.TargetGroupArn,
+			Region:            vpcLatticeV2Request.RequestContext.Region,
+			IdentityType:      vpcLatticeV2Request.RequestContext.Identity.Type,
+			Principal:         vpcLatticeV2Request.RequestContext.Identity.Principal,
+			PrincipalOrgID:    vpcLatticeV2Request.RequestContext.Identity.PrincipalOrgID,
+			SessionName:       vpcLatticeV2Request.RequestContext.Identity.SessionName,
+			SourceVpcArn:      vpcLatticeV2Request.RequestContext.Identity.SourceVpcArn,
+		}
+		logEntry.Metadata.Caller = vpcLatticeV2Request.RequestContext.Identity.getCaller()
+		return logEntry, true
+	}
+
+	var vpcLatticeV1Request vpcLatticeV1Request
+	if err := json.Unmarshal(r.Event, &vpcLatticeV1Request); err == nil &&
+		vpcLatticeV1Request.Method != "" && vpcLatticeV1Request.RawPath != "" {
+		headers := normaliseHeaders(vpcLatticeV1Request.Headers, nil)
+		// V1 events don't include the time of the request or the details of the service, and only include the caller's identity in headers
+		logEntry := r.newLogEntry(
+			newVPCLatticeLogEntryRequest(vpcLatticeV1Request.Method, vpcLatticeV1Request.RawPath, vpcLatticeV1Request.Body,
+				vpcLatticeV1Request.IsBase64Encoded, headers),
+			0,
+		)
+		identity := getVPCLatticeIdentityFromHeaders(headers)
+		logEntry.Metadata.VPCLattice = &LogEntryVPCLatticeMetadata{
+			IdentityType:   identity.Type,
+			Principal:      identity.Principal,
+			PrincipalOrgID: identity.PrincipalOrgID,
+			SessionName:    identity.SessionName,
+			SourceVpcArn:   identity.SourceVpcArn,
+		}
+		logEntry.Metadata.Caller = identity.getCaller()
+		return logEntry, true
+	}
+
+	return nil, false
+}
+
+// getVPCLatticeIdentityFromHeaders returns the identity of the caller from the headers VPC Lattice adds to the requests it passes to V1
+// targets. The x-amzn-lattice-identity header is a list of key=value pairs separated by semicolons, e.g.
+// "Principal=arn:aws:sts::123456789012:assumed-role/example-role/session; SessionName=session; Type=AWS_IAM", and the x-amzn-source-vpc
+// header is the ID of the VPC the request came from.
+func getVPCLatticeIdentityFromHeaders(headers map[string][]string) vpcLatticeIdentity {
+	identity := vpcLatticeIdentity{SourceVpcArn: getHeaderValue(headers, "x-amzn-source-vpc")}
+	for _, pair := range strings.Split(getHeaderValue(headers, "x-amzn-lattice-identity"), ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "Principal":
+			identity.Principal = value
+		case "PrincipalOrgID":
+			identity.PrincipalOrgID = value
+		case "SessionName":
+			identity.SessionName = value
+		case "Type":
+			identity.Type = value
+		}
+	}
+	return identity
+}
+
+// getCaller returns the caller metadata for the identity if the caller was authenticated with IAM. Otherwise, it returns nil.
+func (i *vpcLatticeIdentity) getCaller() *LogEntryCallerMetadata {
+	if i.Type != "AWS_IAM" {
+		return nil
+	}
+	return &LogEntryCallerMetadata{Type: callerTypeIAM, IAMPrincipal: i.Principal}
+}
+
+// newVPCLatticeLogEntryRequest returns the value for the request field of a Firetail SaaS LogEntry for a VPC Lattice request. VPC Lattice
+// doesn't provide the source IP or route of a request in its events, so the IP is taken from the X-Forwarded-For header, and the resource
+// is the path of the request. Base64 encoded bodies are decoded if their content type is textual.
+func newVPCLatticeLogEntryRequest(method, path, body string, isBase64Encoded bool, headers map[string][]string) LogEntryRequest {
+	path, _, _ = strings.Cut(path, "?")
+	if isBase64Encoded {
+		body, isBase64Encoded = decodeBase64Body(body, getHeaderValue(headers, "content-type"))
+	}
+	return LogEntryRequest{
+		Body:            body,
+		Headers:         headers,
+		HTTPProtocol:    HTTP11,
+		IP:              getHeaderValue(headers, "x-forwarded-for"),
+		Method:          LogEntryMethod(method),
+		URI:             "https://" + getHeaderValue(headers, "host") + path,
+		Resource:        normaliseResource(path),
+		IsBase64Encoded: isBase64Encoded,
+	}
+}
@@ -0,0 +1,196 @@
+package firetail
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testVPCLatticeV1Event = `{
+	"raw_path": "/users/42?verbose=true",
+	"method": "GET",
+	"headers": {
+		"user-agent": "curl/7.64.1",
+		"x-forwarded-for": "10.0.2.100",
+		"host": "svc-0a40eebed65f8d69c.7d67968.vpc-lattice-svcs.eu-west-1.on.aws"
+	},
+	"query_string_parameters": {"verbose": "true"},
+	"body": "",
+	"is_base64_encoded": false
+}`
+
+const testVPCLatticeV1IdentityEvent = `{
+	"raw_path": "/users/42",
+	"method": "GET",
+	"headers": {
+		"user-agent": "curl/7.64.1",
+		"x-forwarded-for": "10.0.2.100",
+		"host": "svc-0a40eebed65f8d69c.7d67968.vpc-lattice-svcs.eu-west-1.on.aws",
+		"x-amzn-lattice-identity": "Principal=arn:aws:sts::123456789012:assumed-role/example-role/057d00f8b51257ba3c853a0f248943cf; PrincipalOrgID=o-50dc6c495c0c9188; SessionName=057d00f8b51257ba3c853a0f248943cf; Type=AWS_IAM",
+		"x-amzn-source-vpc": "vpc-0b8276c84697e7339"
+	},
+	"query_string_parameters": {},
+	"body": "",
+	"is_base64_encoded": false
+}`
+
+const testVPCLatticeV2Event = `{
+	"version": "2.0",
+	"path": "/users/42",
+	"method": "POST",
+	"headers": {
+		"user-agent": ["curl/7.64.1"],
+		"x-forwarded-for": ["10.0.2.100"],
+		"host": ["svc-0a40eebed65f8d69c.7d67968.vpc-lattice-svcs.eu-west-1.on.aws"],
+		"accept": ["application/json, text/plain"]
+	},
+	"queryStringParameters": {},
+	"body": "{\"name\":\"test\"}",
+	"isBase64Encoded": false,
+	"requestContext": {
+		"serviceNetworkArn": "arn:aws:vpc-lattice:eu-west-1:123456789012:servicenetwork/sn-0bf3f2882e9cc805a",
+		"serviceArn": "arn:aws:vpc-lattice:eu-west-1:123456789012:service/svc-0a40eebed65f8d69c",
+		"targetGroupArn": "arn:aws:vpc-lattice:eu-west-1:123456789012:targetgroup/tg-6d0ecf831eec9f09",
+		"identity": {
+			"sourceVpcArn": "arn:aws:ec2:eu-west-1:123456789012:vpc/vpc-0b8276c84697e7339",
+			"type": "AWS_IAM",
+			"principal": "arn:aws:sts::123456789012:assumed-role/example-role/057d00f8b51257ba3c853a0f248943cf",
+			"principalOrgID": "o-50dc6c495c0c9188",
+			"sessionName": "057d00f8b51257ba3c853a0f248943cf"
+		},
+		"region": "eu-west-1",
+		"timeEpoch": "1690497599177430"
+	}
+}`
+
+func TestGetVPCLatticeLogEntryV1(t *testing.T) {
+	testRecord := Record{
+		Event: json.RawMessage(testVPCLatticeV1Event),
+		Response: RecordResponse{
+			StatusCode: 200,
+			Body:       "{\"id\":42}",
+			Headers:    map[string]string{"Content-Type": "application/json"},
+		},
+		ExecutionTime: 50,
+	}
+
+	logEntry, ok := testRecord.getVPCLatticeLogEntry()
+	require.True(t, ok)
+
+	assert.Equal(t, int64(0), logEntry.DateCreated)
+	assert.Equal(t, float64(50), logEntry.ExecutionTime)
+	assert.Equal(t, Get, logEntry.Request.Method)
+	assert.Equal(t, "https://svc-0a40eebed65f8d69c.7d67968.vpc-lattice-svcs.eu-west-1.on.aws/users/42", logEntry.Request.URI)
+	assert.Equal(t, "/users/42", logEntry.Request.Resource)
+	assert.Equal(t, "10.0.2.100", logEntry.Request.IP)
+	assert.Equal(t, []string{"curl/7.64.1"}, logEntry.Request.Headers["user-agent"])
+	assert.Equal(t, int64(200), logEntry.Response.StatusCode)
+	assert.Equal(t, []string{"application/json"}, logEntry.Response.Headers["content-type"])
+	assert.Equal(t, &LogEntryVPCLatticeMetadata{}, logEntry.Metadata.VPCLattice)
+	assert.Nil(t, logEntry.Metadata.Caller)
+}
+
+func TestGetVPCLatticeLogEntryV1Identity(t *testing.T) {
+	testRecord := Record{
+		Event:    json.RawMessage(testVPCLatticeV1IdentityEvent),
+		Response: RecordResponse{StatusCode: 200},
+	}
+
+	logEntry, ok := testRecord.getVPCLatticeLogEntry()
+	require.True(t, ok)
+
+	assert.Equal(t,
+		&LogEntryVPCLatticeMetadata{
+			IdentityType:   "AWS_IAM",
+			Principal:      "arn:aws:sts::123456789012:assumed-role/example-role/057d00f8b51257ba3c853a0f248943cf",
+			PrincipalOrgID: "o-50dc6c495c0c9188",
+			SessionName:    "057d00f8b51257ba3c853a0f248943cf",
+			SourceVpcArn:   "vpc-0b8276c84697e7339",
+		},
+		logEntry.Metadata.VPCLattice,
+	)
+	assert.Equal(t,
+		&LogEntryCallerMetadata{Type: "iam", IAMPrincipal: "arn:aws:sts::123456789012:assumed-role/example-role/057d00f8b51257ba3c853a0f248943cf"},
+		logEntry.Metadata.Caller,
+	)
+}
+
+func TestGetVPCLatticeLogEntryV2(t *testing.T) {
+	testRecord := Record{
+		Event: json.RawMessage(testVPCLatticeV2Event),
+		Response: RecordResponse{
+			StatusCode: 201,
+			Body:       "{\"id\":42}",
+		},
+	}
+
+	logEntry, ok := testRecord.getVPCLatticeLogEntry()
+	require.True(t, ok)
+
+	assert.Equal(t, int64(1690497599177), logEntry.DateCreated)
+	assert.Equal(t, Post, logEntry.Request.Method)
+	assert.Equal(t, "{\"name\":\"test\"}", logEntry.Request.Body)
+	assert.Equal(t, "/users/42", logEntry.Request.Resource)
+	assert.Equal(t, "10.0.2.100", logEntry.Request.IP)
+	assert.Equal(t, []string{"application/json", "text/plain"}, logEntry.Request.Headers["accept"])
+	assert.Equal(t, int64(201), logEntry.Response.StatusCode)
+	assert.Equal(t,
+		&LogEntryVPCLatticeMetadata{
+			ServiceNetworkArn: "arn:aws:vpc-lattice:eu-west-1:123456789012:servicenetwork/sn-0bf3f2882e9cc805a",
+			ServiceArn:        "arn:aws:vpc-lattice:eu-west-1:123456789012:service/svc-0a40eebed65f8d69c",
+			TargetGroupArn:    "arn:aws:vpc-lattice:eu-west-1:123456789012:targetgroup/tg-6d0ecf831eec9f09",
+			Region:            "eu-west-1",
+			IdentityType:      "AWS_IAM",
+			Principal:         "arn:aws:sts::123456789012:assumed-role/example-role/057d00f8b51257ba3c853a0f248943cf",
+			PrincipalOrgID:    "o-50dc6c495c0c9188",
+			SessionName:       "057d00f8b51257ba3c853a0f248943cf",
+			SourceVpcArn:      "arn:aws:ec2:eu-west-1:123456789012:vpc/vpc-0b8276c84697e7339",
+		},
+		logEntry.Metadata.VPCLattice,
+	)
+	assert.Equal(t,
+		&LogEntryCallerMetadata{Type: "iam", IAMPrincipal: "arn:aws:sts::123456789012:assumed-role/example-role/057d00f8b51257ba3c853a0f248943cf"},
+		logEntry.Metadata.Caller,
+	)
+}
+
+func TestGetVPCLatticeLogEntryBase64Body(t *testing.T) {
+	textEvent := strings.Replace(
+		strings.Replace(testVPCLatticeV2Event, `"isBase64Encoded": false`, `"isBase64Encoded": true`, 1),
+		`"body": "{\"name\":\"test\"}"`, `"body": "eyJuYW1lIjoidGVzdCJ9"`, 1,
+	)
+	textEvent = strings.Replace(textEvent, `"accept": ["application/json, text/plain"]`, `"content-type": ["application/json"]`, 1)
+	logEntry, ok := (&Record{Event: json.RawMessage(textEvent)}).getVPCLatticeLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, "{\"name\":\"test\"}", logEntry.Request.Body)
+	assert.False(t, logEntry.Request.IsBase64Encoded)
+
+	binaryEvent := strings.Replace(textEvent, `"content-type": ["application/json"]`, `"content-type": ["image/png"]`, 1)
+	logEntry, ok = (&Record{Event: json.RawMessage(binaryEvent)}).getVPCLatticeLogEntry()
+	require.True(t, ok)
+	assert.Equal(t, "eyJuYW1lIjoidGVzdCJ9", logEntry.Request.Body)
+	assert.True(t, logEntry.Request.IsBase64Encoded)
+}
+
+func TestGetVPCLatticeLogEntryNotVPCLattice(t *testing.T) {
+	apiGatewayV2HTTPRequestBytes, err := json.Marshal(getNewAPIGatewayV2HTTPRequest())
+	require.Nil(t, err)
+
+	testRecord := Record{Event: json.RawMessage(apiGatewayV2HTTPRequestBytes)}
+
+	logEntry, ok := testRecord.getVPCLatticeLogEntry()
+	assert.False(t, ok)
+	assert.Nil(t, logEntry)
+}
+
+func TestGetLogEntryVPCLattice(t *testing.T) {
+	testRecord := Record{Event: json.RawMessage(testVPCLatticeV2Event)}
+
+	logEntry, err := testRecord.getLogEntry()
+	require.Nil(t, err)
+	require.NotNil(t, logEntry.Metadata.VPCLattice)
+	assert.Equal(t, "lambda-extension", logEntry.Metadata.Source)
+}
@@ -14,8 +14,12 @@ type Client struct {
 	errCallback       func(error)
 	httpServer        *http.Server
 	receiverWaitgroup *sync.WaitGroup
-	maxBatchSize      int
+	batcher           *firetail.Batcher
 	batchCallback     func([]firetail.Record) error
+	retryPolicy       *firetail.RetryPolicy
+	spool             *firetail.Spool
+	circuitBreaker    *firetail.CircuitBreaker
+	uploadPool        *firetail.UploadPool
 }
 
 func NewClient(options Options) (*Client, error) {
@@ -25,13 +29,25 @@ func NewClient(options Options) (*Client, error) {
 		return nil, err
 	}
 
+	// The batch limits other than the max batch size are shared with the proxy's batcher
+	batchLimits := *firetail.DefaultBatchLimits
+	batchLimits.MaxItems = options.maxBatchSize
+	recordsChannel := make(chan firetail.Record, options.recordsBufferSize)
+
 	client := &Client{
-		recordsChannel:    make(chan firetail.Record, options.recordsBufferSize),
+		recordsChannel:    recordsChannel,
 		errCallback:       options.ErrCallback,
 		httpServer:        &http.Server{Addr: options.LogServerAddress},
 		receiverWaitgroup: &sync.WaitGroup{},
-		maxBatchSize:      options.maxBatchSize,
+		batcher:           firetail.NewBatcher(recordsChannel, batchLimits),
 		batchCallback:     options.BatchCallback,
+		retryPolicy:       options.RetryPolicy,
+		spool:             options.Spool,
+		circuitBreaker:    options.CircuitBreaker,
+	}
+
+	if options.UploadConcurrency != nil {
+		client.uploadPool = firetail.NewUploadPool(*options.UploadConcurrency)
 	}
 
 	err = subscribeToLogsApi(options.awsLambdaRuntimeAPI, options.ExtensionID)
@@ -43,6 +59,9 @@ func NewClient(options Options) (*Client, error) {
 
 	client.receiverWaitgroup.Add(1)
 	go client.recordReceiver()
+	if client.spool != nil {
+		go client.replaySpool(client.spool.Replay)
+	}
 
 	return client, nil
 }
@@ -65,3 +84,14 @@ func (c *Client) Shutdown(ctx context.Context) error {
 	close(c.recordsChannel)
 	return err
 }
+
+// Flush passes the records the client has received so far to the batch callback, returning once it has returned or the context is done
+func (c *Client) Flush(ctx context.Context) error {
+	if err := c.batcher.Flush(ctx); err != nil {
+		return err
+	}
+	if c.uploadPool != nil {
+		return c.uploadPool.Wait(ctx)
+	}
+	return nil
+}
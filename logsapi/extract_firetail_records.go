@@ -6,6 +6,7 @@ import (
 	"firetail-lambda-extension/firetail"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
@@ -42,6 +43,13 @@ func extractFiretailRecords(requestBody []byte) ([]firetail.Record, error) {
 			continue
 		}
 
+		// The time the function logged the record is the closest we have to the time it was captured
+		if firetailRecord.CapturedAt == 0 {
+			if logMessageTime, err := time.Parse(time.RFC3339Nano, logMessage.Time); err == nil {
+				firetailRecord.CapturedAt = logMessageTime.UnixMilli()
+			}
+		}
+
 		firetailRecords = append(firetailRecords, *firetailRecord)
 	}
 
@@ -271,3 +271,22 @@ func TestExtractMessagesWithInvalidType(t *testing.T) {
 	require.NotNil(t, err)
 	assert.Equal(t, "Err unmarshalling Lambda Logs API request body into []LogMessage: json: cannot unmarshal object into Go value of type []logsapi.logMessage", err.Error())
 }
+
+func TestExtractSingleRecordSetsCapturedAt(t *testing.T) {
+	testPayloadBytes, err := json.Marshal(firetail.Record{})
+	require.Nil(t, err)
+	testRecordBytes, err := json.Marshal("firetail:log-ext:" + base64.StdEncoding.EncodeToString(testPayloadBytes))
+	require.Nil(t, err)
+	testMessageBytes, err := json.Marshal([]logMessage{{
+		Time:   "2022-11-23T10:20:39.660Z",
+		Type:   "function",
+		Record: testRecordBytes,
+	}})
+	require.Nil(t, err)
+
+	decodedRecords, err := extractFiretailRecords(testMessageBytes)
+	require.Nil(t, err)
+
+	require.Len(t, decodedRecords, 1)
+	assert.Equal(t, int64(1669198839660), decodedRecords[0].CapturedAt)
+}
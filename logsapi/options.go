@@ -12,7 +12,7 @@ import (
 
 const (
 	DefaultRecordsBufferSize = 1000
-	DefaultMaxBatchSize      = 100
+	DefaultMaxBatchSize      = firetail.DefaultMaxBatchItems
 	DefaultFiretailApiUrl    = "https://api.logging.eu-west-1.prod.firetail.app/logs/bulk"
 )
 
@@ -23,6 +23,12 @@ type Options struct {
 	LogServerAddress string                        // The address that the log server should assume
 	BatchCallback    func([]firetail.Record) error // A callback which will be provided batches of firetail records received from the Lambda Logs API
 	ErrCallback      func(err error)               // A callback used for any errs raised when handling requests from the Lambda Logs API
+	RetryPolicy      *firetail.RetryPolicy         // The policy used to retry batches the BatchCallback errs on. Defaults to firetail.DefaultRetryPolicy
+	Spool            *firetail.Spool               // The spool batches the BatchCallback can't send are kept in. If nil, batches aren't spooled
+	CircuitBreaker   *firetail.CircuitBreaker      // The circuit breaker each call to the BatchCallback goes through. If nil, there's no circuit breaker
+	// The concurrency batches are passed to the BatchCallback with, in which case it may be called concurrently. If nil, batches are passed
+	// to the BatchCallback one at a time
+	UploadConcurrency *firetail.UploadConcurrency
 
 	// Loaded from environment variables
 
@@ -83,15 +89,22 @@ func (o *Options) setDefaults() {
 			}
 			// Try to send the batch to Firetail
 			log.Printf("Attempting to send batch of %d record(s) to Firetail...", len(batch))
-			recordsSent, err := firetail.SendRecordsToSaaS(batch, o.firetailApiUrl, o.firetailApiToken)
+			result, err := firetail.SendRecordsToSaaS(batch, o.firetailApiUrl, o.firetailApiToken)
+			// Records which couldn't be sent on their own would fail again if the batch were retried, so they're only reported
+			if recordErr := result.RecordErr(); recordErr != nil {
+				o.ErrCallback(errors.WithMessage(recordErr, "Err sending some records to Firetail SaaS, they were dropped"))
+			}
 			if err != nil {
-				err = errors.WithMessage(err, fmt.Sprintf("Err sending %d record(s) to Firetail SaaS", recordsSent))
+				err = errors.WithMessage(err, fmt.Sprintf("Err sending %d record(s) to Firetail SaaS", result.Sent))
 				return err
 			}
-			log.Printf("Successfully sent %d record(s) sent to Firetail.", recordsSent)
+			log.Printf("Successfully sent %d record(s) sent to Firetail.", result.Sent)
 			return nil
 		}
 	}
+	if o.RetryPolicy == nil {
+		o.RetryPolicy = firetail.DefaultRetryPolicy
+	}
 	if o.ErrCallback == nil {
 		o.ErrCallback = func(err error) {
 			log.Println(err.Error())
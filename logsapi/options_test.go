@@ -125,7 +125,7 @@ func TestDefaultBatchCallback(t *testing.T) {
 
 	err := testOptions.BatchCallback([]firetail.Record{
 		{
-			Event: []byte(`{"description":"test event"}`),
+			Event: []byte(`{"version":"2.0","routeKey":"$default","description":"test event"}`),
 			Response: firetail.RecordResponse{
 				StatusCode: 200,
 				Body:       `{"description":"test response body"}`,
@@ -139,7 +139,7 @@ func TestDefaultBatchCallback(t *testing.T) {
 	require.Nil(t, err)
 
 	assert.Equal(t,
-		"{\"dateCreated\":0,\"executionTime\":3.142,\"request\":{\"body\":\"\",\"headers\":{},\"httpProtocol\":\"\",\"ip\":\"\",\"method\":\"\",\"uri\":\"https://\",\"resource\":\"\"},\"response\":{\"body\":\"{\\\"description\\\":\\\"test response body\\\"}\",\"headers\":{\"Test-Header-Name\":[\"Test-Header-Value\"]},\"statusCode\":200},\"version\":\"1.0.0-alpha\",\"metadata\":{\"source\":\"lambda-extension\"}}\n",
+		"{\"id\":\"ea3f1935095086610b5b3d20128ca80b\",\"dateCreated\":0,\"executionTime\":3.142,\"request\":{\"body\":\"\",\"headers\":{},\"httpProtocol\":\"\",\"ip\":\"\",\"method\":\"\",\"uri\":\"https://\",\"resource\":\"/{proxy+}\"},\"response\":{\"body\":\"{\\\"description\\\":\\\"test response body\\\"}\",\"headers\":{\"test-header-name\":[\"Test-Header-Value\"]},\"statusCode\":200},\"version\":\"1.1.0-alpha\",\"metadata\":{\"source\":\"lambda-extension\"}}\n",
 		string(requestBody),
 	)
 }
@@ -153,7 +153,7 @@ func TestDefaultBatchCallbackFail(t *testing.T) {
 
 	err := testOptions.BatchCallback([]firetail.Record{
 		{
-			Event: []byte(`{"description":"test event"}`),
+			Event: []byte(`{"version":"2.0","routeKey":"$default","description":"test event"}`),
 			Response: firetail.RecordResponse{
 				StatusCode: 200,
 				Body:       `{"description":"test response body"}`,
@@ -165,5 +165,5 @@ func TestDefaultBatchCallbackFail(t *testing.T) {
 		},
 	})
 	require.NotNil(t, err)
-	assert.Equal(t, "Err sending 0 record(s) to Firetail SaaS: 1 error occurred:\n\t* parse \"\\n\": net/url: invalid control character in URL\n\n", err.Error())
+	assert.Equal(t, "Err sending 0 record(s) to Firetail SaaS: parse \"\\n\": net/url: invalid control character in URL", err.Error())
 }
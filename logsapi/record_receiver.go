@@ -1,57 +1,62 @@
 package logsapi
 
 import (
+	"context"
 	"firetail-lambda-extension/firetail"
+
+	"github.com/pkg/errors"
 )
 
-// recordReceiver receives records from the client into batches & passes them to the batch callback. If the batch callback
-// returns an err, it does not remove the log entries from the batch.
+// recordReceiver passes the batches of records received by the client's batcher to the batch callback, on the client's upload pool if it
+// has one, until the records channel is closed. If the batch callback returns an err, the batch is retried according to the client's
+// retry policy, which dead-letters it if it can't be sent. If the client has a spool, batches are passed to the batch callback through
+// it so those which aren't sent because the client's circuit breaker is open are kept in it, and batches kept in it are replayed once a
+// batch has been passed to the batch callback successfully.
 func (c *Client) recordReceiver() {
-	recordsBatch := []firetail.Record{}
-
-	for {
-		newRecords, recordsRemaining := c.receiveRecords(c.maxBatchSize - len(recordsBatch))
-		recordsBatch = append(recordsBatch, newRecords...)
-
-		// If the batch is empty, but there's records remaining, then we continue; else we return.
-		if len(recordsBatch) == 0 {
-			if recordsRemaining {
-				continue
-			} else {
-				c.receiverWaitgroup.Done()
-				return
-			}
+	defer c.receiverWaitgroup.Done()
+	c.batcher.Run(func(recordsBatch []firetail.Record) {
+		if c.uploadPool != nil {
+			c.uploadPool.Submit(recordsBatch, c.upload)
+		} else {
+			c.upload(recordsBatch)
 		}
+	})
+	if c.uploadPool != nil {
+		c.uploadPool.Shutdown(context.Background())
+	}
+}
 
-		// Give the batch to the batch callback. If it errs, we continue
-		err := c.batchCallback(recordsBatch)
-		if err != nil {
+// upload passes the batch to the batch callback, keeping it in the client's spool if it has one
+func (c *Client) upload(recordsBatch []firetail.Record) {
+	if c.spool == nil {
+		if err := c.sendWithRetries(recordsBatch); err != nil {
 			c.errCallback(err)
-			continue
 		}
+		return
+	}
+	if err := c.spool.Send(recordsBatch, c.sendWithRetries); err != nil {
+		c.errCallback(err)
+		return
+	}
+	go c.replaySpool(c.spool.ReplayKept)
+}
 
-		// If the batch callback succeeded, we can clear the batch!
-		recordsBatch = []firetail.Record{}
+// replaySpool passes the batches replayed from the client's spool to the batch callback, retrying them according to the client's retry
+// policy
+func (c *Client) replaySpool(replay func(func([]firetail.Record) error) error) {
+	if err := replay(c.sendWithRetries); err != nil {
+		c.errCallback(errors.WithMessage(err, "Err replaying spooled records"))
 	}
 }
 
-// ReceiveRecords returns a slice of firetail Records up to the size of `limit`, and a boolean indicating that the channel
-// still has items to be read - it will only be `false` when the channel is closed & empty. It achieves this by continuously
-// reading from the log server's recordsChannel until it's empty, or the size limit has been reached.
-func (c *Client) receiveRecords(limit int) ([]firetail.Record, bool) {
-	records := []firetail.Record{}
-	for {
-		select {
-		case record, open := <-c.recordsChannel:
-			if !open {
-				return records, false
-			}
-			records = append(records, record)
-			if len(records) == limit {
-				return records, true
-			}
-		default:
-			return records, true
+// sendWithRetries passes the batch to the batch callback, through the client's circuit breaker if it has one, retrying it according to
+// the client's retry policy. The err it returns if the batch can't be sent is left to the caller to pass to the err callback, so it's only
+// reported once.
+func (c *Client) sendWithRetries(recordsBatch []firetail.Record) error {
+	return c.retryPolicy.SendWithRetries(recordsBatch, func(batch []firetail.Record) error {
+		if c.circuitBreaker != nil {
+			return c.circuitBreaker.Send(batch, c.batchCallback)
 		}
-	}
+		return c.batchCallback(batch)
+	})
 }
@@ -166,3 +166,19 @@ func TestRecordReceiverMaxBatchSize(t *testing.T) {
 	err = client.Shutdown(ctx)
 	assert.Nil(t, err)
 }
+
+func TestRecordReceiverReportsEachFailedBatchOnce(t *testing.T) {
+	errs := []error{}
+	client := &Client{
+		errCallback: func(err error) { errs = append(errs, err) },
+		batchCallback: func(batch []firetail.Record) error {
+			return errors.New("This test batch callback always fails :(")
+		},
+		retryPolicy: &firetail.RetryPolicy{MaxAttempts: 3, MaxAge: time.Minute},
+	}
+
+	client.upload([]firetail.Record{{}})
+
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), "Gave up after 3 attempt(s)")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"firetail-lambda-extension/extensionsapi"
 	"firetail-lambda-extension/firetail"
 	"firetail-lambda-extension/logsapi"
@@ -49,6 +50,86 @@ func main() {
 		}
 	}
 
+	// Configure how the identifiers of callers are hashed before they're logged
+	if err := firetail.DefaultIdentifierHashing.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure how the API keys & client certificates of consumers are hashed & inspected before they're logged
+	if err := firetail.DefaultConsumerIdentification.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure which identity sources of Lambda authorizers are masked before they're logged
+	if err := firetail.DefaultAuthorizerMasking.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure the inspection of JWT bearer tokens
+	if err := firetail.DefaultJWTInspection.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure whether GraphQL operations are detected in request bodies
+	if err := firetail.DefaultGraphQLDetection.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure how batches of records which fail to send to Firetail are retried
+	if err := firetail.DefaultRetryPolicy.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure the spool on disk which batches of records are kept in until they've been sent to Firetail
+	if err := firetail.DefaultSpool.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure the circuit breaker around sending records to Firetail, and what happens to records while it's open. Records can only be
+	// spooled while the circuit is open if the spool is enabled.
+	if err := firetail.DefaultCircuitBreaker.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+	if firetail.DefaultCircuitBreaker.OverflowPolicy == firetail.OverflowPolicySpool && !firetail.DefaultSpool.Enabled {
+		log.Println("Spool is disabled, so records will be dropped while the circuit breaker is open")
+		firetail.DefaultCircuitBreaker.OverflowPolicy = firetail.OverflowPolicyDrop
+	}
+
+	// Configure the max size of log entries, beyond which their bodies are truncated
+	if err := firetail.DefaultLogEntryTruncation.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure the compression of bulk uploads to Firetail
+	if err := firetail.DefaultUploadCompression.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure the size & linger time of batches of records sent to Firetail
+	if err := firetail.DefaultBatchLimits.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure how many batches of records are uploaded to Firetail at once
+	if err := firetail.DefaultUploadConcurrency.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// Configure when records are flushed to Firetail relative to the invocations they were captured from
+	if err := firetail.DefaultFlushPolicy.LoadEnvVars(); err != nil {
+		panic(err)
+	}
+
+	// If an event mapping file is configured, register its mappers so they take precedence over the built-in mappers
+	if eventMappingsFile := os.Getenv("FIRETAIL_EVENT_MAPPINGS_FILE"); eventMappingsFile != "" {
+		eventMappers, err := firetail.LoadEventMappingFile(eventMappingsFile)
+		if err != nil {
+			panic(err)
+		}
+		firetail.DefaultEventMapperRegistry.Register(eventMappers...)
+		log.Println("Registered event mappers:", firetail.DefaultEventMapperRegistry.Names())
+	}
+
 	// This context will be cancelled whenever a SIGTERM or SIGINT signal is received
 	// We'll use it for our requests to the extensions API & to shutdown the log server
 	ctx := getContext()
@@ -61,18 +142,28 @@ func main() {
 	}
 	log.Println("Registered extension, ID:", extensionClient.ExtensionID)
 
+	// Each mode provides a func to flush the records it has received, and the proxy can also await the record of an invocation
+	var flush func(context.Context) error
+	var awaitInvocation func(context.Context, string) error
+	isLegacy, err := strconv.ParseBool(os.Getenv("FIRETAIL_EXTENSION_LEGACY"))
+	isLegacy = err == nil && isLegacy
+
 	// In legacy mode, we use the logs API. Otherwise, we use the new proxy client.
-	if isLegacy, err := strconv.ParseBool(os.Getenv("FIRETAIL_EXTENSION_LEGACY")); err == nil && isLegacy {
+	if isLegacy {
 		// Create a logsApiClient, start it & remember to shut it down when we're done
 		logsApiClient, err := logsapi.NewClient(logsapi.Options{
-			ExtensionID:      extensionClient.ExtensionID,
-			LogServerAddress: "sandbox:1234",
+			ExtensionID:       extensionClient.ExtensionID,
+			LogServerAddress:  "sandbox:1234",
+			Spool:             firetail.DefaultSpool,
+			CircuitBreaker:    firetail.DefaultCircuitBreaker,
+			UploadConcurrency: firetail.DefaultUploadConcurrency,
 		})
 		if err != nil {
 			panic(err)
 		}
 		go logsApiClient.Start(ctx)
 		defer logsApiClient.Shutdown(ctx)
+		flush = logsApiClient.Flush
 	} else {
 		firetailApiUrl, firetailApiUrlSet := os.LookupEnv("FIRETAIL_API_URL")
 		if !firetailApiUrlSet {
@@ -84,16 +175,35 @@ func main() {
 		}
 		go proxyServer.ListenAndServe()
 		defer proxyServer.Shutdown(ctx)
-		go firetail.RecordReceiver(
-			proxyServer.RecordsChannel,
-			logsapi.DefaultMaxBatchSize,
-			firetailApiUrl,
-			os.Getenv("FIRETAIL_API_TOKEN"),
-		)
+		batcher := firetail.NewBatcher(proxyServer.RecordsChannel, *firetail.DefaultBatchLimits)
+		uploadPool := firetail.NewUploadPool(*firetail.DefaultUploadConcurrency)
+		go firetail.RecordReceiver(batcher, uploadPool, firetailApiUrl, os.Getenv("FIRETAIL_API_TOKEN"))
+		// Flushing the batcher only submits its batch to the upload pool, so the upload pool's batches must also be waited for
+		flush = func(ctx context.Context) error {
+			if err := batcher.Flush(ctx); err != nil {
+				return err
+			}
+			return uploadPool.Wait(ctx)
+		}
+		awaitInvocation = proxyServer.AwaitInvocation
+	}
+
+	// After each invocation, its record is flushed according to the flush policy before the next event is requested, as the execution
+	// environment may then be frozen. In legacy mode, the logs API may not deliver an invocation's record until after the next event has
+	// been requested, and there's no way to tell when it has, so records are only flushed by the batch limits & on shutdown.
+	var onInvoke func(string, time.Time)
+	if isLegacy {
+		log.Println("Records aren't flushed after each invocation in legacy mode, the flush policy only applies on shutdown")
+	} else {
+		onInvoke = func(requestID string, deadline time.Time) {
+			if err := firetail.DefaultFlushPolicy.FlushInvocation(ctx, requestID, deadline, awaitInvocation, flush); err != nil {
+				log.Println("Error flushing records after invocation:", err.Error())
+			}
+		}
 	}
 
 	// awaitShutdown will block until a shutdown event is received, or the context is cancelled
-	reason, err := awaitShutdown(extensionClient, ctx)
+	reason, err := awaitShutdown(extensionClient, ctx, onInvoke)
 	if err != nil {
 		panic(err)
 	}
@@ -102,4 +212,17 @@ func main() {
 	// Sleep for 500ms to allow any final logs to be sent to the extension by the Lambda Logs API
 	log.Printf("Sleeping for 500ms to allow final logs to be processed...")
 	time.Sleep(500 * time.Millisecond)
+
+	// Flush any records which are still batched or being uploaded, regardless of the flush policy. The context may have been cancelled, so a
+	// new one is used, and its timeout keeps the shutdown within Lambda's deadline. Batches still being uploaded when it times out are then
+	// kept in the spool.
+	flushCtx, cancel := context.WithTimeout(context.Background(), firetail.DefaultFlushPolicy.Timeout)
+	defer cancel()
+	if err := flush(flushCtx); err != nil {
+		log.Println("Error flushing records on shutdown:", err.Error())
+	}
+	if err := firetail.DefaultSpool.KeepPending(); err != nil {
+		log.Println("Error keeping pending records in spool on shutdown:", err.Error())
+	}
+	log.Println("Circuit breaker diagnostics:", firetail.DefaultCircuitBreaker.Diagnostics().String())
 }
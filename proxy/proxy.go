@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -21,8 +22,11 @@ type ProxyServer struct {
 	port                  int
 	server                *http.Server
 	eventsChannel         chan *http.Response
-	lambdaResponseChannel chan *http.Request
+	lambdaResponseChannel chan *http.Request // Receives nil in place of a response for invocations which err
 	RecordsChannel        chan firetail.Record
+	invocationsMutex      sync.Mutex
+	completedInvocationID string        // The request ID of the last invocation whose response or error was captured
+	invocationCompleted   chan struct{} // Closed & replaced whenever an invocation completes
 }
 
 func NewProxyServer() (*ProxyServer, error) {
@@ -39,6 +43,7 @@ func NewProxyServer() (*ProxyServer, error) {
 		eventsChannel:         make(chan *http.Response, 1),
 		lambdaResponseChannel: make(chan *http.Request, 1),
 		RecordsChannel:        make(chan firetail.Record, 100),
+		invocationCompleted:   make(chan struct{}),
 	}
 
 	r := chi.NewRouter()
@@ -80,7 +85,12 @@ func NewProxyServer() (*ProxyServer, error) {
 		nil,
 		nil,
 	)
-	r.Post("/2018-06-01/runtime/invocation/{requestId}/error", invokeErrorHandler)
+	// An invocation which errs has no response to capture, so once its error has been proxied a nil response is passed to the record
+	// assembler in place of one, so it drops the invocation's event rather than pairing it with the next invocation's response
+	r.Post("/2018-06-01/runtime/invocation/{requestId}/error", func(w http.ResponseWriter, r *http.Request) {
+		invokeErrorHandler(w, r)
+		ps.lambdaResponseChannel <- nil
+	})
 
 	nextEndpoint, err := url.Parse(
 		fmt.Sprintf(
@@ -135,36 +145,81 @@ func (p *ProxyServer) recordAssembler() {
 		// We can record the time between receiving the event and the response
 		// to calculate the execution time of the lambda function.
 		eventReceivedAt := time.Now()
+		requestID := event.Header.Get("Lambda-Runtime-Aws-Request-Id")
 
 		lambdaResponse, ok := <-p.lambdaResponseChannel
 		if !ok {
 			log.Println("Lambda response channel closed, stopping record assembler.")
 			return
 		}
+		if lambdaResponse == nil {
+			log.Println("Invocation errored, dropping its event:", requestID)
+			p.completeInvocation(requestID)
+			continue
+		}
 
 		executionTime := time.Since(eventReceivedAt)
 
 		eventBody, err := io.ReadAll(event.Body)
 		if err != nil {
 			log.Println("Error reading event body:", err.Error())
+			p.completeInvocation(requestID)
 			continue
 		}
 		responseBody, err := io.ReadAll(lambdaResponse.Body)
 		if err != nil {
 			log.Println("Error reading response body:", err.Error())
+			p.completeInvocation(requestID)
 			continue
 		}
 
+		// Not every event source expects a response in the shape of a RecordResponse (e.g. Lambda@Edge), so if the response body can't
+		// be unmarshalled into one we still pass on the record with its raw response.
 		var recordResponse firetail.RecordResponse
 		if err := json.Unmarshal(responseBody, &recordResponse); err != nil {
-			log.Println("Error unmarshalling response body:", err.Error())
-			continue
+			log.Println("Response body is not a record response:", err.Error())
+			recordResponse = firetail.RecordResponse{}
 		}
 
 		p.RecordsChannel <- firetail.Record{
 			Event:         eventBody,
 			Response:      recordResponse,
+			RawResponse:   responseBody,
 			ExecutionTime: executionTime.Seconds(),
+			CapturedAt:    eventReceivedAt.UnixMilli(),
+			RequestID:     requestID,
+		}
+		p.completeInvocation(requestID)
+	}
+}
+
+// completeInvocation records that the invocation with the provided request ID has completed, and wakes anything awaiting it
+func (p *ProxyServer) completeInvocation(requestID string) {
+	p.invocationsMutex.Lock()
+	defer p.invocationsMutex.Unlock()
+	p.completedInvocationID = requestID
+	close(p.invocationCompleted)
+	p.invocationCompleted = make(chan struct{})
+}
+
+// AwaitInvocation blocks until the invocation with the provided request ID has completed, or the context is done. An invocation is
+// completed once its record has been passed to the RecordsChannel, or its event has been dropped after its error was proxied to the
+// runtime API. Lambda only invokes a function's execution environment with one event at a time, so only the last completed invocation is
+// remembered.
+func (p *ProxyServer) AwaitInvocation(ctx context.Context, requestID string) error {
+	for {
+		p.invocationsMutex.Lock()
+		if p.completedInvocationID == requestID {
+			p.invocationsMutex.Unlock()
+			return nil
+		}
+		invocationCompleted := p.invocationCompleted
+		p.invocationsMutex.Unlock()
+
+		select {
+		case <-invocationCompleted:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
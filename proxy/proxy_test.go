@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"firetail-lambda-extension/firetail"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getMockRuntimeApiServer returns a server mocking the Lambda runtime API, which gives each of the events in order from /next with the
+// request ID test-request-id-{n}, and accepts any response or error
+func getMockRuntimeApiServer(events ...string) *httptest.Server {
+	nextEvent := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/2018-06-01/runtime/invocation/next" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		nextEvent++
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", fmt.Sprintf("test-request-id-%d", nextEvent))
+		fmt.Fprint(w, events[nextEvent-1])
+	}))
+}
+
+// getTestProxyServer returns a ProxyServer in front of the runtime API server, with its record assembler running, and a test server
+// serving its routes
+func getTestProxyServer(t *testing.T, runtimeApiServer *httptest.Server) (*ProxyServer, *httptest.Server) {
+	t.Setenv("AWS_LAMBDA_RUNTIME_API", strings.TrimPrefix(runtimeApiServer.URL, "http://"))
+	proxyServer, err := NewProxyServer()
+	require.Nil(t, err)
+	go proxyServer.recordAssembler()
+	return proxyServer, httptest.NewServer(proxyServer.server.Handler)
+}
+
+// doRuntimeApiRequest makes a request to the proxy as the function's runtime would, returning the response body
+func doRuntimeApiRequest(t *testing.T, method, url, body string) string {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	return string(respBody)
+}
+
+func TestProxyServerInvocation(t *testing.T) {
+	runtimeApiServer := getMockRuntimeApiServer(`{"description":"test event"}`)
+	defer runtimeApiServer.Close()
+	proxyServer, testServer := getTestProxyServer(t, runtimeApiServer)
+	defer testServer.Close()
+
+	event := doRuntimeApiRequest(t, "GET", testServer.URL+"/2018-06-01/runtime/invocation/next", "")
+	assert.Equal(t, `{"description":"test event"}`, event)
+	doRuntimeApiRequest(t, "POST", testServer.URL+"/2018-06-01/runtime/invocation/test-request-id-1/response", `{"statusCode":200}`)
+
+	select {
+	case record := <-proxyServer.RecordsChannel:
+		assert.Equal(t, "test-request-id-1", record.RequestID)
+		assert.Equal(t, `{"description":"test event"}`, string(record.Event))
+		assert.Equal(t, firetail.RecordResponse{StatusCode: 200}, record.Response)
+	case <-time.After(time.Second):
+		t.Fatal("Record wasn't passed to the records channel")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, proxyServer.AwaitInvocation(ctx, "test-request-id-1"))
+}
+
+func TestProxyServerInvocationErrorThenSuccess(t *testing.T) {
+	runtimeApiServer := getMockRuntimeApiServer(`{"description":"failing event"}`, `{"description":"test event"}`)
+	defer runtimeApiServer.Close()
+	proxyServer, testServer := getTestProxyServer(t, runtimeApiServer)
+	defer testServer.Close()
+
+	doRuntimeApiRequest(t, "GET", testServer.URL+"/2018-06-01/runtime/invocation/next", "")
+	doRuntimeApiRequest(t, "POST", testServer.URL+"/2018-06-01/runtime/invocation/test-request-id-1/error", `{"errorMessage":"oops"}`)
+
+	// The errored invocation must complete without a record being passed on
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Nil(t, proxyServer.AwaitInvocation(ctx, "test-request-id-1"))
+
+	doRuntimeApiRequest(t, "GET", testServer.URL+"/2018-06-01/runtime/invocation/next", "")
+	doRuntimeApiRequest(t, "POST", testServer.URL+"/2018-06-01/runtime/invocation/test-request-id-2/response", `{"statusCode":201}`)
+
+	// The successful invocation's response must be paired with its own event, not the errored invocation's
+	select {
+	case record := <-proxyServer.RecordsChannel:
+		assert.Equal(t, "test-request-id-2", record.RequestID)
+		assert.Equal(t, `{"description":"test event"}`, string(record.Event))
+		assert.Equal(t, int64(201), record.Response.StatusCode)
+	case <-time.After(time.Second):
+		t.Fatal("Record wasn't passed to the records channel")
+	}
+	require.Nil(t, proxyServer.AwaitInvocation(ctx, "test-request-id-2"))
+	assert.Len(t, proxyServer.RecordsChannel, 0)
+}